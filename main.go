@@ -13,6 +13,46 @@ func main() {
 
 	cmdJoin := cmd.MakeJoin()
 
+	cmdSmoke := cmd.MakeSmoke()
+
+	cmdUser := cmd.MakeUser()
+
+	cmdConfig := cmd.MakeConfig()
+
+	cmdPreflight := cmd.MakePreflight()
+
+	cmdWaitSSH := cmd.MakeWaitSSH()
+
+	cmdExport := cmd.MakeExport()
+
+	cmdApp := cmd.MakeApp()
+
+	cmdNetwork := cmd.MakeNetwork()
+
+	cmdBench := cmd.MakeBench()
+
+	cmdEtcd := cmd.MakeEtcd()
+
+	cmdLogrotate := cmd.MakeLogrotate()
+
+	cmdRecord := cmd.MakeRecord()
+
+	cmdClean := cmd.MakeClean()
+
+	cmdWatchdog := cmd.MakeWatchdog()
+
+	cmdCluster := cmd.MakeCluster()
+
+	cmdDiff := cmd.MakeDiff()
+
+	cmdVersions := cmd.MakeVersions()
+
+	cmdDebug := cmd.MakeDebug()
+
+	cmdCompletion := cmd.MakeCompletion()
+
+	cmdScripts := cmd.MakeScripts()
+
 	printk3supASCIIArt := cmd.PrintK3supASCIIArt
 
 	var rootCmd = &cobra.Command{
@@ -23,9 +63,49 @@ func main() {
 		},
 	}
 
+	cmd.AddSSHAlgorithmFlags(rootCmd)
+
 	rootCmd.AddCommand(cmdInstall)
 	rootCmd.AddCommand(cmdVersion)
 	rootCmd.AddCommand(cmdJoin)
+	rootCmd.AddCommand(cmdSmoke)
+	rootCmd.AddCommand(cmdUser)
+	rootCmd.AddCommand(cmdConfig)
+	rootCmd.AddCommand(cmdPreflight)
+	rootCmd.AddCommand(cmdWaitSSH)
+	rootCmd.AddCommand(cmdExport)
+	rootCmd.AddCommand(cmdApp)
+	rootCmd.AddCommand(cmdNetwork)
+	rootCmd.AddCommand(cmdBench)
+	rootCmd.AddCommand(cmdEtcd)
+	rootCmd.AddCommand(cmdLogrotate)
+	rootCmd.AddCommand(cmdRecord)
+	rootCmd.AddCommand(cmdClean)
+	rootCmd.AddCommand(cmdWatchdog)
+	rootCmd.AddCommand(cmdCluster)
+	rootCmd.AddCommand(cmdDiff)
+	rootCmd.AddCommand(cmdVersions)
+	rootCmd.AddCommand(cmdDebug)
+	rootCmd.AddCommand(cmdCompletion)
+	rootCmd.AddCommand(cmdScripts)
+
+	addPlugins(rootCmd)
 
 	rootCmd.Execute()
 }
+
+// addPlugins registers k3sup-<name> executables from the PATH, built-in
+// commands always take precedence over a plugin of the same name
+func addPlugins(rootCmd *cobra.Command) {
+	builtin := map[string]bool{"help": true}
+	for _, c := range rootCmd.Commands() {
+		builtin[c.Name()] = true
+	}
+
+	for _, plugin := range cmd.DiscoverPlugins() {
+		if builtin[plugin.Name] {
+			continue
+		}
+		rootCmd.AddCommand(cmd.MakePlugin(plugin))
+	}
+}
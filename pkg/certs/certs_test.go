@@ -0,0 +1,74 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func newTestCA(t *testing.T) (*x509.Certificate, []byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "k3s-client-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour * 24),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return cert,
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+func Test_NewClientCertificate(t *testing.T) {
+	ca, caCertPEM, caKeyPEM := newTestCA(t)
+
+	certPEM, keyPEM, err := NewClientCertificate(caCertPEM, caKeyPEM, "alice", []string{"dev", "ops"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block, _ := pem.Decode(keyPEM); block == nil || block.Type != "EC PRIVATE KEY" {
+		t.Errorf("want an EC private key")
+	}
+
+	block, _ := pem.Decode(certPEM)
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.Subject.CommonName != "alice" {
+		t.Errorf("want CN alice, got %s", cert.Subject.CommonName)
+	}
+	if len(cert.Subject.Organization) != 2 || cert.Subject.Organization[0] != "dev" {
+		t.Errorf("want groups [dev ops], got %v", cert.Subject.Organization)
+	}
+	if cert.NotAfter.After(time.Now().Add(time.Hour + time.Minute)) {
+		t.Errorf("certificate outlives its ttl: %s", cert.NotAfter)
+	}
+
+	if err := cert.CheckSignatureFrom(ca); err != nil {
+		t.Errorf("certificate not signed by CA: %s", err)
+	}
+}
@@ -0,0 +1,33 @@
+package cmd
+
+import (
+	"github.com/spf13/cobra"
+)
+
+func MakeApp() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "app",
+		Short: "Install apps which need both the nodes and the cluster set up",
+		Long: `Install apps which need packages on every node as well as resources in
+the cluster, doing both over SSH.`,
+		Example:      `  k3sup app install nfs --server-ip 192.168.0.100 --server 10.0.0.5 --path /export`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeAppInstall())
+
+	return command
+}
+
+func makeAppInstall() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "install",
+		Short:        "Install an app",
+		Example:      `  k3sup app install nfs --help`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeInstallNFS())
+
+	return command
+}
@@ -0,0 +1,170 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// nfsCSIManifests are applied in order from the deploy directory of the
+// csi-driver-nfs release
+var nfsCSIManifests = []string{
+	"rbac-csi-nfs.yaml",
+	"csi-nfs-driverinfo.yaml",
+	"csi-nfs-controller.yaml",
+	"csi-nfs-node.yaml",
+}
+
+const nfsCSIManifestURL = "https://raw.githubusercontent.com/kubernetes-csi/csi-driver-nfs/master/deploy/%s/%s"
+
+// nfsClientCommand installs the NFS client with whichever package manager
+// the node has
+const nfsClientCommand = `if command -v apt-get > /dev/null 2>&1; then sudo apt-get update -qq && sudo DEBIAN_FRONTEND=noninteractive apt-get install -y -qq nfs-common;
+elif command -v dnf > /dev/null 2>&1; then sudo dnf install -y -q nfs-utils;
+elif command -v yum > /dev/null 2>&1; then sudo yum install -y -q nfs-utils;
+elif command -v zypper > /dev/null 2>&1; then sudo zypper --non-interactive install nfs-client;
+elif command -v apk > /dev/null 2>&1; then sudo apk add -q nfs-utils;
+else echo 'no supported package manager found' >&2; exit 1; fi`
+
+func makeInstallNFS() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "nfs",
+		Short: "Install the NFS CSI driver and a StorageClass for an NFS export",
+		Long: `Install the NFS client on every node, then deploy the NFS CSI driver and
+a StorageClass which provisions volumes as directories of an existing
+NFS export.`,
+		Example: `  k3sup app install nfs --server-ip 192.168.0.100 \
+    --ip 192.168.0.101,192.168.0.102 \
+    --server 10.0.0.5 --path /export --default`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("server-ip", nil, "Public IP of the k3s server")
+	command.Flags().IPSlice("ip", nil, "Public IPs of the agents, which also need the NFS client")
+	command.Flags().String("server-host", "", "DNS name of the k3s server, instead of --server-ip")
+	command.Flags().StringSlice("host", nil, "DNS names of the agents, which also need the NFS client")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().String("server", "", "Address of the NFS server")
+	command.Flags().String("path", "", "Path of the export on the NFS server")
+	command.Flags().String("storage-class", "nfs-csi", "Name of the StorageClass to create")
+	command.Flags().Bool("default", false, "Make the StorageClass the default")
+	command.Flags().String("csi-version", "v4.6.0", "Version of the NFS CSI driver to deploy")
+	command.Flags().String("mount-options", "nfsvers=4.1", "Comma separated NFS mount options for volumes")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		serverHost, err := getHost(command, "server-ip", "server-host")
+		if err != nil {
+			return err
+		}
+		agents, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		nfsServer, _ := command.Flags().GetString("server")
+		nfsPath, _ := command.Flags().GetString("path")
+		storageClass, _ := command.Flags().GetString("storage-class")
+		makeDefault, _ := command.Flags().GetBool("default")
+		csiVersion, _ := command.Flags().GetString("csi-version")
+		mountOptions, _ := command.Flags().GetString("mount-options")
+
+		if len(serverHost) == 0 {
+			return fmt.Errorf("give the k3s server with --server-ip or --server-host")
+		}
+		if len(nfsServer) == 0 || len(nfsPath) == 0 {
+			return fmt.Errorf("give the NFS export with --server and --path")
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		nodes := append([]string{serverHost}, agents...)
+
+		for _, node := range nodes {
+			fmt.Printf("Installing the NFS client on %s\n", node)
+			if err := installNFSClient(node, port, user, sshKeyPath); err != nil {
+				return errors.Wrapf(err, "unable to install the NFS client on %s", node)
+			}
+		}
+
+		operator, closeOperator, err := connectOperator(serverHost, port, user, sshKeyPath)
+		if err != nil {
+			return err
+		}
+		defer closeOperator()
+
+		for _, manifest := range nfsCSIManifests {
+			url := fmt.Sprintf(nfsCSIManifestURL, csiVersion, manifest)
+			fmt.Printf("Applying %s\n", url)
+
+			res, err := operator.ExecuteSilent("sudo k3s kubectl apply -f " + url)
+			if err != nil {
+				return fmt.Errorf("unable to apply %s: %s %s", manifest, err, strings.TrimSpace(string(res.StdErr)))
+			}
+		}
+
+		fmt.Printf("Creating StorageClass %s for %s:%s\n", storageClass, nfsServer, nfsPath)
+		res, err := operator.ExecuteWithStdin("sudo k3s kubectl apply -f -", strings.NewReader(nfsStorageClass(storageClass, nfsServer, nfsPath, mountOptions)))
+		if err != nil {
+			return fmt.Errorf("unable to create the StorageClass: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+		}
+
+		if makeDefault {
+			if err := setDefaultStorageClass(operator, storageClass, escalationSudo+" "); err != nil {
+				return err
+			}
+		}
+
+		fmt.Printf("Volumes with storageClassName: %s are now provisioned on %s:%s\n", storageClass, nfsServer, nfsPath)
+		return nil
+	}
+
+	return command
+}
+
+func installNFSClient(host string, port int, user, sshKeyPath string) error {
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+	defer closeOperator()
+
+	res, err := operator.ExecuteSilent(nfsClientCommand)
+	if err != nil {
+		return fmt.Errorf("%s %s", err, lastLines(res.StdErr, 5))
+	}
+	return nil
+}
+
+func nfsStorageClass(name, server, path, mountOptions string) string {
+	options := ""
+	for _, option := range strings.Split(mountOptions, ",") {
+		if option = strings.TrimSpace(option); len(option) > 0 {
+			options += fmt.Sprintf("  - %s\n", option)
+		}
+	}
+
+	class := fmt.Sprintf(`apiVersion: storage.k8s.io/v1
+kind: StorageClass
+metadata:
+  name: %s
+provisioner: nfs.csi.k8s.io
+parameters:
+  server: %s
+  share: %s
+reclaimPolicy: Delete
+volumeBindingMode: Immediate
+`, name, server, path)
+
+	if len(options) > 0 {
+		class += "mountOptions:\n" + options
+	}
+	return class
+}
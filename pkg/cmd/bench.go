@@ -0,0 +1,253 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/preflight"
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// benchSyncWrites of benchSyncSize bytes match the small, synced writes
+	// etcd and kine make to their database
+	benchSyncWrites = 300
+	benchSyncSize   = 2300
+	benchSyncDir    = "/var/lib/rancher"
+	benchSyncPath   = benchSyncDir + "/k3sup-bench"
+
+	benchHashMB = 128
+)
+
+func MakeBench() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "bench",
+		Short: "Measure whether a host is fast enough to run k3s",
+		Long: `Measure the disk sync latency, CPU and network throughput of a host and
+score them against what a k3s server and agent need. Slow SD cards are a
+common cause of unstable servers, since the datastore syncs every write.`,
+		Example:      `  k3sup bench --ip 192.168.0.100`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", nil, "Public IP of the host")
+	command.Flags().String("host", "", "DNS name of the host, instead of --ip")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().Int("network-mb", 16, "Megabytes to send to the host to measure throughput, 0 to skip")
+	command.Flags().StringP("output", "o", "table", "Output format: table or json")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		networkMB, _ := command.Flags().GetInt("network-mb")
+		output, _ := command.Flags().GetString("output")
+
+		if len(host) == 0 {
+			return fmt.Errorf("give the host with --ip or --host")
+		}
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", output)
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+		if err != nil {
+			return err
+		}
+		defer closeOperator()
+
+		results := runBench(operator, networkMB)
+
+		if output == "json" {
+			out, _ := json.MarshalIndent(results, "", "  ")
+			fmt.Println(string(out))
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "PROBE\tRESULT\tSERVER\tAGENT")
+		for _, result := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", result.Probe, result.Value, result.Server, result.Agent)
+		}
+		w.Flush()
+		printWarnings(os.Stdout)
+		return nil
+	}
+
+	return command
+}
+
+// benchResult is a measurement along with how it rates for each role
+type benchResult struct {
+	Probe  string           `json:"probe"`
+	Value  string           `json:"value"`
+	Server preflight.Status `json:"server"`
+	Agent  preflight.Status `json:"agent"`
+}
+
+func runBench(operator kssh.Operator, networkMB int) []benchResult {
+	// Each probe is timed from here, so the cost of a round trip is
+	// measured first and taken off
+	start := time.Now()
+	operator.ExecuteSilent("true")
+	roundTrip := time.Since(start)
+
+	results := []benchResult{
+		benchSync(operator, roundTrip),
+		benchCores(operator),
+		benchHash(operator, roundTrip),
+	}
+
+	if networkMB > 0 {
+		results = append(results, benchNetwork(operator, networkMB, roundTrip))
+	}
+	return results
+}
+
+func timed(roundTrip time.Duration, fn func() error) (time.Duration, error) {
+	start := time.Now()
+	err := fn()
+	elapsed := time.Since(start) - roundTrip
+	if elapsed <= 0 {
+		elapsed = time.Microsecond
+	}
+	return elapsed, err
+}
+
+func benchSync(operator kssh.Operator, roundTrip time.Duration) benchResult {
+	result := benchResult{Probe: "disk sync"}
+	command := fmt.Sprintf("sudo mkdir -p %s && sudo dd if=/dev/zero of=%s bs=%d count=%d oflag=dsync 2>&1; status=$?; sudo rm -f %s; exit $status",
+		benchSyncDir, benchSyncPath, benchSyncSize, benchSyncWrites, benchSyncPath)
+
+	elapsed, err := timed(roundTrip, func() error {
+		_, err := operator.ExecuteSilent(command)
+		return err
+	})
+	if err != nil {
+		result.Value = "unable to measure, dd may not support oflag=dsync"
+		result.Server, result.Agent = preflight.Warn, preflight.Warn
+		return result
+	}
+
+	latency := elapsed / benchSyncWrites
+	result.Value = fmt.Sprintf("%s per synced write", latency.Round(time.Microsecond*10))
+	result.Server, result.Agent = scoreSyncLatency(latency)
+	return result
+}
+
+// scoreSyncLatency follows the etcd guidance of under 10ms per sync for a
+// datastore. Agents only write container images and logs, so they can
+// cope with a much slower disk.
+func scoreSyncLatency(latency time.Duration) (server, agent preflight.Status) {
+	switch {
+	case latency < time.Millisecond*10:
+		server = preflight.Pass
+	case latency < time.Millisecond*25:
+		server = preflight.Warn
+	default:
+		server = preflight.Fail
+	}
+
+	agent = preflight.Pass
+	if latency >= time.Millisecond*50 {
+		agent = preflight.Warn
+	}
+	return server, agent
+}
+
+func benchCores(operator kssh.Operator) benchResult {
+	result := benchResult{Probe: "cpu cores"}
+
+	res, _ := operator.ExecuteSilent("nproc 2>/dev/null || grep -c ^processor /proc/cpuinfo")
+	cores, err := strconv.Atoi(strings.TrimSpace(string(res.StdOut)))
+	if err != nil {
+		result.Value = "unable to count"
+		result.Server, result.Agent = preflight.Warn, preflight.Warn
+		return result
+	}
+
+	result.Value = strconv.Itoa(cores)
+	result.Server, result.Agent = preflight.Pass, preflight.Pass
+	if cores < 2 {
+		result.Server = preflight.Warn
+	}
+	return result
+}
+
+func benchHash(operator kssh.Operator, roundTrip time.Duration) benchResult {
+	result := benchResult{Probe: "cpu sha256"}
+	command := fmt.Sprintf("dd if=/dev/zero bs=1048576 count=%d 2>/dev/null | sha256sum > /dev/null", benchHashMB)
+
+	elapsed, err := timed(roundTrip, func() error {
+		_, err := operator.ExecuteSilent(command)
+		return err
+	})
+	if err != nil {
+		result.Value = "unable to measure"
+		result.Server, result.Agent = preflight.Warn, preflight.Warn
+		return result
+	}
+
+	rate := float64(benchHashMB) / elapsed.Seconds()
+	result.Value = fmt.Sprintf("%.0f MB/s", rate)
+	result.Server, result.Agent = scoreHashRate(rate)
+	return result
+}
+
+// scoreHashRate flags CPUs in the class of the Raspberry Pi Zero, which
+// struggle to run the control plane
+func scoreHashRate(rate float64) (server, agent preflight.Status) {
+	switch {
+	case rate >= 50:
+		return preflight.Pass, preflight.Pass
+	case rate >= 20:
+		return preflight.Warn, preflight.Pass
+	}
+	return preflight.Fail, preflight.Warn
+}
+
+func benchNetwork(operator kssh.Operator, megabytes int, roundTrip time.Duration) benchResult {
+	result := benchResult{Probe: "network upload"}
+	size := int64(megabytes) * 1024 * 1024
+
+	elapsed, err := timed(roundTrip, func() error {
+		_, err := operator.ExecuteWithStdin("cat > /dev/null", io.LimitReader(zeroReader{}, size))
+		return err
+	})
+	if err != nil {
+		result.Value = "unable to measure"
+		result.Server, result.Agent = preflight.Warn, preflight.Warn
+		return result
+	}
+
+	mbits := float64(size*8) / elapsed.Seconds() / 1000 / 1000
+	result.Value = fmt.Sprintf("%.1f Mbit/s from here", mbits)
+	result.Server, result.Agent = preflight.Pass, preflight.Pass
+	if mbits < 10 {
+		result.Server, result.Agent = preflight.Warn, preflight.Warn
+	}
+	return result
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	for i := range p {
+		p[i] = 0
+	}
+	return len(p), nil
+}
@@ -0,0 +1,28 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/preflight"
+)
+
+func Test_scoreSyncLatency(t *testing.T) {
+	cases := []struct {
+		latency time.Duration
+		server  preflight.Status
+		agent   preflight.Status
+	}{
+		{time.Millisecond * 2, preflight.Pass, preflight.Pass},
+		{time.Millisecond * 15, preflight.Warn, preflight.Pass},
+		{time.Millisecond * 40, preflight.Fail, preflight.Pass},
+		{time.Millisecond * 80, preflight.Fail, preflight.Warn},
+	}
+
+	for _, c := range cases {
+		server, agent := scoreSyncLatency(c.latency)
+		if server != c.server || agent != c.agent {
+			t.Errorf("%s: want server %s agent %s, got server %s agent %s", c.latency, c.server, c.agent, server, agent)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+const (
+	caBundleName     = "k3sup-ca.crt"
+	caBundleK3sPath  = "/etc/rancher/k3s/" + caBundleName
+	registriesConfig = "/etc/rancher/k3s/registries.yaml"
+)
+
+// trustStoreCommand picks where the distro keeps extra CAs and the tool
+// which rebuilds the bundle: update-ca-trust on RHEL and Fedora, the
+// anchors directory on SUSE, and update-ca-certificates everywhere else
+const trustStoreCommand = `if command -v update-ca-trust > /dev/null 2>&1; then
+  %[3]scp %[1]s /etc/pki/ca-trust/source/anchors/%[2]s && %[3]supdate-ca-trust extract
+elif [ -d /etc/pki/trust/anchors ]; then
+  %[3]scp %[1]s /etc/pki/trust/anchors/%[2]s && %[3]supdate-ca-certificates
+else
+  %[3]smkdir -p /usr/local/share/ca-certificates && %[3]scp %[1]s /usr/local/share/ca-certificates/%[2]s && %[3]supdate-ca-certificates
+fi`
+
+// installCABundle adds a CA to the host's trust store so that the installer
+// and containerd can reach the internet through a TLS-intercepting proxy.
+// Registries listed in registries also get it as their ca_file, for those
+// which are served with a certificate from the same CA.
+func installCABundle(ctx context.Context, operator kssh.Operator, caPath string, registries []string, escalation string) error {
+	ca, err := ioutil.ReadFile(caPath)
+	if err != nil {
+		return fmt.Errorf("unable to read --ca-bundle: %s", err)
+	}
+	if !bytes.Contains(ca, []byte("-----BEGIN CERTIFICATE-----")) {
+		return fmt.Errorf("--ca-bundle %s does not contain a PEM encoded certificate", caPath)
+	}
+
+	fmt.Printf("Adding %s to the trust store\n", caPath)
+	if err := uploadFile(ctx, operator, bytes.NewReader(ca), caBundleK3sPath, "0644", escalation); err != nil {
+		return err
+	}
+
+	res, err := operator.ExecuteSilent(fmt.Sprintf(trustStoreCommand, caBundleK3sPath, caBundleName, escalation))
+	if err != nil {
+		return fmt.Errorf("unable to update the trust store: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	if len(registries) == 0 {
+		return nil
+	}
+
+	res, _ = operator.ExecuteSilent(fmt.Sprintf("test -f %s && echo exists", registriesConfig))
+	if strings.TrimSpace(string(res.StdOut)) == "exists" {
+		warn(msgCABundle, registriesConfig, caBundleK3sPath, strings.Join(registries, ", "))
+		return nil
+	}
+
+	return uploadFile(ctx, operator, strings.NewReader(registriesYAML(registries)), registriesConfig, "0600", escalation)
+}
+
+// registriesYAML configures containerd to trust the uploaded CA for each
+// registry
+func registriesYAML(registries []string) string {
+	out := "configs:\n"
+	for _, registry := range registries {
+		out += fmt.Sprintf("  %q:\n    tls:\n      ca_file: %s\n", registry, caBundleK3sPath)
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// k3sChannelURL is the update channel API which the k3s installer also
+// uses, each channel redirects to the release it currently points at
+const k3sChannelURL = "https://update.k3s.io/v1-release/channels/"
+
+var channelName = regexp.MustCompile(`^[a-z0-9][-a-z0-9.]*$`)
+
+// resolveK3sChannel finds the release a channel such as stable, latest or
+// v1.19 points at now, so that the version is known before anything is
+// installed and can be checked, recorded and passed to every node alike
+func resolveK3sChannel(channel string) (string, error) {
+	if !channelName.MatchString(channel) {
+		return "", fmt.Errorf("%q is not a k3s channel, use stable, latest or a minor version such as v1.19", channel)
+	}
+
+	client := &http.Client{
+		Timeout: time.Second * 10,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	res, err := client.Get(k3sChannelURL + channel)
+	if err != nil {
+		return "", fmt.Errorf("unable to resolve the k3s channel %s: %s", channel, err)
+	}
+	defer res.Body.Close()
+
+	location := res.Header.Get("Location")
+	if res.StatusCode < 300 || res.StatusCode >= 400 || len(location) == 0 {
+		return "", fmt.Errorf("unable to resolve the k3s channel %s: %s", channel, res.Status)
+	}
+	return channelVersion(location)
+}
+
+// channelVersion takes the release from a channel's redirect, such as
+// https://github.com/k3s-io/k3s/releases/tag/v1.19.5+k3s2
+func channelVersion(location string) (string, error) {
+	version := path.Base(location)
+	if !strings.HasPrefix(version, "v") {
+		return "", fmt.Errorf("the k3s channel points at %s, which isn't a release", location)
+	}
+	return version, nil
+}
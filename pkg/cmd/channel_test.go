@@ -0,0 +1,17 @@
+package cmd
+
+import "testing"
+
+func Test_channelVersion(t *testing.T) {
+	version, err := channelVersion("https://github.com/k3s-io/k3s/releases/tag/v1.19.5+k3s2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != "v1.19.5+k3s2" {
+		t.Errorf("want v1.19.5+k3s2, got %s", version)
+	}
+
+	if _, err := channelVersion("https://github.com/k3s-io/k3s/releases"); err == nil {
+		t.Errorf("want an error for a redirect which isn't to a release")
+	}
+}
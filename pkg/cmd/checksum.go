@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// publishedChecksum fetches the sha256 which a k3s release publishes for
+// its binary for arch. The checksums are read from GitHub where k3sup
+// runs, so that a mirror or proxy in front of the host can't vouch for
+// the binary it served.
+func publishedChecksum(version, arch string) (string, error) {
+	binary, sums, err := releaseBinary(arch)
+	if err != nil {
+		return "", err
+	}
+
+	client := &http.Client{Timeout: time.Second * 30}
+	res, err := client.Get(fmt.Sprintf(k3sDownloadURL, version, sums))
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("GitHub returned %s for %s", res.Status, sums)
+	}
+
+	sum, err := findChecksum(res.Body, binary)
+	if err != nil {
+		return "", err
+	}
+	if len(sum) == 0 {
+		return "", fmt.Errorf("%s has no checksum for %s", sums, binary)
+	}
+	return sum, nil
+}
+
+// findChecksum reads the sha256 of binary from the output of sha256sum
+func findChecksum(sums io.Reader, binary string) (string, error) {
+	scanner := bufio.NewScanner(sums)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == binary {
+			return fields[0], nil
+		}
+	}
+	return "", scanner.Err()
+}
+
+// hostArch returns the architecture uname reports for the host
+func hostArch(operator kssh.Operator) (string, error) {
+	res, err := operator.ExecuteSilent("uname -m")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the architecture of the host: %s", err)
+	}
+	return strings.TrimSpace(string(res.StdOut)), nil
+}
+
+// verifyK3sBinary checks the k3s binary against the checksum published for
+// the release, the binary on the host after the installer downloaded it or
+// a --k3s-binary before it is uploaded. When the checksums can't be fetched
+// the install goes ahead with a warning.
+func (o installOptions) verifyK3sBinary(operator kssh.Operator) error {
+	if len(o.ChecksumVersion) == 0 {
+		return nil
+	}
+
+	arch, err := hostArch(operator)
+	if err != nil {
+		return err
+	}
+	want, err := publishedChecksum(o.ChecksumVersion, arch)
+	if err != nil {
+		warn(msgChecksum, o.ChecksumVersion, err)
+		return nil
+	}
+
+	got, where := "", "on the host"
+	if len(o.Binary) > 0 {
+		file, err := os.Open(o.Binary)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		size, err := readerSize(file)
+		if err != nil {
+			return err
+		}
+		if got, err = localChecksum(file, size); err != nil {
+			return err
+		}
+		where = o.Binary
+	} else {
+		got = remoteChecksum(operator, k3sBinaryPath, o.Escalation)
+	}
+
+	if got != want {
+		return fmt.Errorf("the sha256 of k3s %s is %s but k3s %s publishes %s, the download may be corrupt or tampered with, remove it with k3sup clean", where, orDash(got), o.ChecksumVersion, want)
+	}
+	fmt.Println(message(msgChecksumVerified, where, o.ChecksumVersion))
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_findChecksum(t *testing.T) {
+	sums := `5e1fa1a0bd8b3c0a0e1b6d0c7bd1b3fd3b8a0b3a1b9a8d8c7e6f5a4b3c2d1e0f  k3s
+0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9  k3s-arm64
+1111111111111111111111111111111111111111111111111111111111111111 *k3s-armhf
+`
+	tests := []struct {
+		binary string
+		want   string
+	}{
+		{"k3s", "5e1fa1a0bd8b3c0a0e1b6d0c7bd1b3fd3b8a0b3a1b9a8d8c7e6f5a4b3c2d1e0f"},
+		{"k3s-arm64", "0a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f9"},
+		{"k3s-armhf", "1111111111111111111111111111111111111111111111111111111111111111"},
+		{"k3s-s390x", ""},
+	}
+
+	for _, test := range tests {
+		got, err := findChecksum(strings.NewReader(sums), test.binary)
+		if err != nil {
+			t.Fatalf("%s: %s", test.binary, err)
+		}
+		if got != test.want {
+			t.Errorf("%s: want %q, got %q", test.binary, test.want, got)
+		}
+	}
+}
+
+func Test_verifyK3sBinary_LocalBinary(t *testing.T) {
+	release := []byte("k3s release build")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%x  k3s\n", sha256.Sum256(release))
+	}))
+	defer server.Close()
+
+	downloadURL := k3sDownloadURL
+	k3sDownloadURL = server.URL + "/%s/%s"
+	defer func() { k3sDownloadURL = downloadURL }()
+
+	dir, err := ioutil.TempDir("", "k3sup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		binary  []byte
+		wantErr bool
+	}{
+		{"matching", release, false},
+		{"mismatching", []byte("k3s local build"), true},
+	}
+	for _, test := range tests {
+		binary := filepath.Join(dir, test.name)
+		if err := ioutil.WriteFile(binary, test.binary, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+			return kssh.CommandRes{StdOut: []byte("x86_64\n")}, nil
+		})
+		opts := installOptions{Binary: binary, ChecksumVersion: "v1.19.5+k3s1"}
+
+		err := opts.verifyK3sBinary(operator)
+		if test.wantErr && (err == nil || !strings.Contains(err.Error(), "the sha256 of k3s "+binary)) {
+			t.Errorf("%s: want a checksum error, got %v", test.name, err)
+		}
+		if !test.wantErr && err != nil {
+			t.Errorf("%s: want no error, got %s", test.name, err)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// cleanPaths hold the data of k3s, its containers and its network
+var cleanPaths = []string{"/var/lib/rancher/k3s", "/var/lib/kubelet", "/run/k3s", "/run/flannel", "/var/lib/cni", "/etc/cni/net.d"}
+
+// cleanScript removes k3s however far its installer got. The scripts the
+// installer leaves behind are used when they exist, and the same steps are
+// repeated for whatever they missed or when they were never written. With
+// --keep-config, /etc/rancher/k3s is put back after k3s-uninstall.sh
+// removed it.
+var cleanScript = remoteScript{Name: "clean", Version: 1, Body: `keep_config=
+[ "$1" = --keep-config ] && keep_config=1
+[ -n "$keep_config" ] && rm -rf /tmp/k3sup-config && cp -a /etc/rancher/k3s /tmp/k3sup-config 2> /dev/null
+
+[ -x /usr/local/bin/k3s-killall.sh ] && /usr/local/bin/k3s-killall.sh
+for s in k3s k3s-agent; do
+  systemctl disable --now $s 2> /dev/null || rc-service $s stop 2> /dev/null
+done
+pkill -9 -f 'k3s (server|agent)|containerd-shim' 2> /dev/null
+
+for d in /run/k3s /var/lib/kubelet /var/lib/rancher/k3s /run/netns/cni-; do
+  awk -v d="$d" 'index($2, d) == 1 { print $2 }' /proc/self/mounts | sort -r | xargs -r umount -l 2> /dev/null
+done
+
+for i in cni0 flannel.1 flannel-v6.1 flannel-wg kube-ipvs0; do
+  ip link delete $i 2> /dev/null
+done
+for t in iptables ip6tables; do
+  command -v $t-save > /dev/null 2>&1 || continue
+  $t-save | grep -v KUBE- | grep -v CNI- | grep -iv flannel | $t-restore
+done
+
+[ -x /usr/local/bin/k3s-uninstall.sh ] && /usr/local/bin/k3s-uninstall.sh
+[ -x /usr/local/bin/k3s-agent-uninstall.sh ] && /usr/local/bin/k3s-agent-uninstall.sh
+
+for b in kubectl crictl ctr; do
+  [ "$(readlink /usr/local/bin/$b)" = k3s ] && rm -f /usr/local/bin/$b
+done
+rm -f /usr/local/bin/k3s /usr/local/bin/k3s-killall.sh /usr/local/bin/k3s-uninstall.sh /usr/local/bin/k3s-agent-uninstall.sh
+rm -f /etc/systemd/system/k3s.service* /etc/systemd/system/k3s-agent.service* /etc/init.d/k3s /etc/init.d/k3s-agent
+command -v systemctl > /dev/null 2>&1 && systemctl daemon-reload
+
+rm -rf ` + strings.Join(cleanPaths, " ") + `
+if [ -n "$keep_config" ]; then
+  [ -d /tmp/k3sup-config ] && mkdir -p /etc/rancher && rm -rf /etc/rancher/k3s && mv /tmp/k3sup-config /etc/rancher/k3s
+else
+  rm -rf /etc/rancher/k3s
+fi
+exit 0`}
+
+func MakeClean() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "clean",
+		Short: "Remove k3s and everything it left behind from nodes",
+		Long: `Remove k3s and everything it left behind from nodes, so that a failed
+install can be tried again without reimaging the host. Services and
+containers are stopped, mounts under the k3s and kubelet directories are
+unmounted, CNI interfaces and iptables rules are deleted and the k3s
+binary, scripts and data are removed, even when the installer stopped
+part way.`,
+		Example:      `  k3sup clean --ip 192.168.0.101`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the nodes to clean, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the nodes to clean, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().Bool("keep-config", false, "Keep /etc/rancher/k3s, such as registries.yaml, for the next install")
+	command.Flags().BoolP("yes", "y", false, "Don't ask before cleaning")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		hosts, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		keepConfig, _ := command.Flags().GetBool("keep-config")
+		yes, _ := command.Flags().GetBool("yes")
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give at least one node with --ip or --host")
+		}
+
+		if !yes {
+			ok, err := prompter.Confirm(fmt.Sprintf("Remove k3s and all of its data from %s?", strings.Join(hosts, ", ")))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return fmt.Errorf("nothing was cleaned")
+			}
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		for _, host := range hosts {
+			fmt.Printf("Cleaning %s\n", host)
+			if err := cleanNode(host, port, user, sshKeyPath, keepConfig); err != nil {
+				return errors.Wrapf(err, "unable to clean %s", host)
+			}
+		}
+		return nil
+	}
+
+	return command
+}
+
+func cleanNode(host string, port int, user, sshKeyPath string, keepConfig bool) error {
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+	defer closeOperator()
+
+	if err := lockHost(operator, "clean"); err != nil {
+		return err
+	}
+	defer unlockHost(operator)
+
+	escalation, err := escalationPrefix(operator, defaultSudo)
+	if err != nil {
+		return err
+	}
+
+	args := []string{}
+	if keepConfig {
+		args = append(args, "--keep-config")
+	}
+	res, err := runScript(operator, cleanScript, escalation, args...)
+	if err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	// What k3sup uploaded has gone, so the node cache would only mislead
+	// the next install
+	if _, err := operator.ExecuteSilent("rm -f " + nodeCachePath); err != nil {
+		return err
+	}
+
+	fmt.Printf("Cleaned %s\n", host)
+	return nil
+}
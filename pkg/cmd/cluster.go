@@ -0,0 +1,247 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// clusterNode is a node of a cluster being stopped or started, connected
+// for the whole command
+type clusterNode struct {
+	host     string
+	service  string
+	name     string
+	operator kssh.Operator
+}
+
+func MakeCluster() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "cluster",
+		Short: "Stop and start a whole cluster",
+		Long: `Stop and start a whole cluster in the right order, for labs which are
+powered down overnight. stop cordons every node, then stops the agents
+and then the servers. start brings the servers back first, waits for the
+API server, starts the agents, waits for every node to be Ready and
+uncordons them.`,
+		Example: `  k3sup cluster stop --server-ip 192.168.0.100 --agent-ip 192.168.0.101,192.168.0.102
+  k3sup cluster start --server-ip 192.168.0.100 --agent-ip 192.168.0.101,192.168.0.102`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeClusterStop())
+	command.AddCommand(makeClusterStart())
+	return command
+}
+
+func addClusterNodeFlags(command *cobra.Command) {
+	command.Flags().IPSlice("server-ip", nil, "Public IPs of the servers, the first is used for the API, can be repeated")
+	command.Flags().StringSlice("server-host", nil, "DNS names of the servers, after any --server-ip, can be repeated")
+	command.Flags().IPSlice("agent-ip", nil, "Public IPs of the agents, can be repeated")
+	command.Flags().StringSlice("agent-host", nil, "DNS names of the agents, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+}
+
+func makeClusterStop() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "stop",
+		Short:        "Cordon every node, then stop the agents and then the servers",
+		SilenceUsage: true,
+	}
+	addClusterNodeFlags(command)
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		nodes, closeNodes, err := connectClusterNodes(command)
+		if err != nil {
+			return err
+		}
+		defer closeNodes()
+		defer printWarnings(os.Stdout)
+
+		api := nodes[0].operator
+		fmt.Printf("Cordoning %s\n", strings.Join(nodeNames(nodes), ", "))
+		if err := kubectlNodes(api, "cordon", nodes); err != nil {
+			return err
+		}
+
+		// Agents are stopped first and the first server last, so that the
+		// API stays up for as long as anything runs
+		for i := len(nodes) - 1; i >= 0; i-- {
+			node := nodes[i]
+			fmt.Printf("Stopping %s on %s\n", node.service, node.host)
+			if err := stopK3sService(node); err != nil {
+				return errors.Wrapf(err, "unable to stop %s on %s", node.service, node.host)
+			}
+		}
+
+		fmt.Println("The cluster is stopped, the nodes can be powered off")
+		return nil
+	}
+
+	return command
+}
+
+func makeClusterStart() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "start",
+		Short:        "Start the servers, then the agents, and uncordon every node once Ready",
+		SilenceUsage: true,
+	}
+	addClusterNodeFlags(command)
+	command.Flags().Duration("wait", time.Minute*5, "Time allowed for the API server and then for every node to become Ready")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		wait, _ := command.Flags().GetDuration("wait")
+
+		nodes, closeNodes, err := connectClusterNodes(command)
+		if err != nil {
+			return err
+		}
+		defer closeNodes()
+		defer printWarnings(os.Stdout)
+
+		api := nodes[0].operator
+		for i, node := range nodes {
+			fmt.Printf("Starting %s on %s\n", node.service, node.host)
+			if err := startK3sService(node); err != nil {
+				return errors.Wrapf(err, "unable to start %s on %s", node.service, node.host)
+			}
+
+			if i == 0 {
+				fmt.Println("Waiting for the API server")
+				if err := waitFor(wait, func() bool {
+					_, err := api.ExecuteSilent("sudo k3s kubectl get nodes")
+					return err == nil
+				}); err != nil {
+					return errors.Wrap(err, "the API server didn't come up")
+				}
+			}
+		}
+
+		for _, node := range nodes {
+			fmt.Printf("Waiting for %s to be Ready\n", node.name)
+			command := fmt.Sprintf("sudo k3s kubectl get node %s -o jsonpath='{.status.conditions[?(@.type==\"Ready\")].status}'", node.name)
+			if err := waitFor(wait, func() bool {
+				res, err := api.ExecuteSilent(command)
+				return err == nil && strings.TrimSpace(string(res.StdOut)) == "True"
+			}); err != nil {
+				return errors.Wrapf(err, "%s didn't become Ready", node.name)
+			}
+		}
+
+		fmt.Printf("Uncordoning %s\n", strings.Join(nodeNames(nodes), ", "))
+		return kubectlNodes(api, "uncordon", nodes)
+	}
+
+	return command
+}
+
+// connectClusterNodes connects to the servers, then the agents, and finds
+// the name of each as a node, which is its hostname unless k3s was given
+// --node-name
+func connectClusterNodes(command *cobra.Command) ([]clusterNode, func(), error) {
+	servers, err := getHosts(command, "server-ip", "server-host")
+	if err != nil {
+		return nil, nil, err
+	}
+	agents, err := getHosts(command, "agent-ip", "agent-host")
+	if err != nil {
+		return nil, nil, err
+	}
+	user, _ := command.Flags().GetString("user")
+	sshKey, _ := command.Flags().GetString("ssh-key")
+	port, _ := command.Flags().GetInt("ssh-port")
+
+	if len(servers) == 0 {
+		return nil, nil, fmt.Errorf("give at least one server with --server-ip or --server-host")
+	}
+
+	nodes := []clusterNode{}
+	closers := []func(){}
+	closeAll := func() {
+		for _, closeOperator := range closers {
+			closeOperator()
+		}
+	}
+
+	sshKeyPath := expandPath(sshKey)
+	for i, host := range append(servers, agents...) {
+		node := clusterNode{host: host, service: "k3s"}
+		if i >= len(servers) {
+			node.service = "k3s-agent"
+		}
+
+		operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+		if err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "unable to connect to %s", host)
+		}
+		closers = append(closers, closeOperator)
+		node.operator = operator
+
+		res, err := operator.ExecuteSilent("hostname")
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("unable to read the hostname of %s: %s", host, err)
+		}
+		node.name = strings.ToLower(strings.TrimSpace(string(res.StdOut)))
+		nodes = append(nodes, node)
+	}
+
+	return nodes, closeAll, nil
+}
+
+func nodeNames(nodes []clusterNode) []string {
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.name)
+	}
+	return names
+}
+
+func kubectlNodes(api kssh.Operator, verb string, nodes []clusterNode) error {
+	command := fmt.Sprintf("sudo k3s kubectl %s %s", verb, strings.Join(nodeNames(nodes), " "))
+	if res, err := api.ExecuteSilent(command); err != nil {
+		return fmt.Errorf("unable to %s nodes: %s %s", verb, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// stopK3sService uses k3s-killall.sh where the installer left it, since
+// stopping the service alone leaves the pods' containers running
+func stopK3sService(node clusterNode) error {
+	command := fmt.Sprintf("if [ -x /usr/local/bin/k3s-killall.sh ]; then sudo /usr/local/bin/k3s-killall.sh; "+
+		"elif command -v systemctl > /dev/null 2>&1; then sudo systemctl stop %s; else sudo rc-service %s stop; fi", node.service, node.service)
+	if res, err := node.operator.ExecuteSilent(command); err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+func startK3sService(node clusterNode) error {
+	command := fmt.Sprintf("if command -v systemctl > /dev/null 2>&1; then sudo systemctl start %s; else sudo rc-service %s start; fi", node.service, node.service)
+	if res, err := node.operator.ExecuteSilent(command); err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// waitFor polls ready every few seconds until it returns true or timeout
+// passes
+func waitFor(timeout time.Duration, ready func() bool) error {
+	deadline := time.Now().Add(timeout)
+	for !ready() {
+		if time.Now().After(deadline) {
+			return fmt.Errorf("gave up waiting after %s", timeout)
+		}
+		time.Sleep(time.Second * 5)
+	}
+	return nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// clusterNameLabel carries --cluster-name on every node
+const clusterNameLabel = "k3sup.io/cluster-name"
+
+var (
+	labelKey   = regexp.MustCompile(`^([a-z0-9]([-a-z0-9.]*[a-z0-9])?/)?[A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?$`)
+	labelValue = regexp.MustCompile(`^([A-Za-z0-9]([-A-Za-z0-9_.]*[A-Za-z0-9])?)?$`)
+)
+
+// clusterIdentity names a cluster the same way in the record, on its nodes
+// and in the kubeconfig
+type clusterIdentity struct {
+	Name   string
+	Labels map[string]string
+}
+
+func addClusterIdentityFlags(command *cobra.Command) {
+	command.Flags().String("cluster-name", "", "Name of the cluster, kept in the --record, set on each node as the "+clusterNameLabel+" label and used as the kubeconfig context")
+	command.Flags().StringToString("cluster-labels", nil, "Labels to set on each node of the cluster, kept in the --record (e.g. --cluster-labels env=prod,site=lab)")
+}
+
+// getClusterIdentity reads --cluster-name and --cluster-labels. Either one
+// which isn't given is taken from the record at recordPath, so that nodes
+// joined later carry the same name and labels.
+func getClusterIdentity(command *cobra.Command, recordPath string) (clusterIdentity, error) {
+	name, _ := command.Flags().GetString("cluster-name")
+	labels, _ := command.Flags().GetStringToString("cluster-labels")
+	identity := clusterIdentity{Name: name, Labels: labels}
+
+	if (len(name) == 0 || len(labels) == 0) && len(recordPath) > 0 {
+		if _, err := os.Stat(recordPath); err == nil {
+			record, err := readRecord(recordPath)
+			if err != nil {
+				return identity, err
+			}
+			if len(identity.Name) == 0 {
+				identity.Name = record.Name
+			}
+			if len(identity.Labels) == 0 {
+				identity.Labels = record.Labels
+			}
+		}
+	}
+
+	if len(identity.Name) > 0 && (len(identity.Name) > 63 || !namespaceName.MatchString(identity.Name)) {
+		return identity, fmt.Errorf("--cluster-name %q should be lower case letters, digits and dashes, at most 63 characters", identity.Name)
+	}
+	for key, value := range identity.Labels {
+		if !labelKey.MatchString(key) || key == clusterNameLabel {
+			return identity, fmt.Errorf("--cluster-labels has an invalid key %q", key)
+		}
+		if len(value) > 63 || !labelValue.MatchString(value) {
+			return identity, fmt.Errorf("--cluster-labels has an invalid value %q for %s", value, key)
+		}
+	}
+	return identity, nil
+}
+
+// nodeLabelArgs are the k3s flags which label a node of the cluster
+func (c clusterIdentity) nodeLabelArgs() string {
+	args := []string{}
+	if len(c.Name) > 0 {
+		args = append(args, "--node-label "+clusterNameLabel+"="+c.Name)
+	}
+
+	keys := []string{}
+	for key := range c.Labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		args = append(args, "--node-label "+key+"="+c.Labels[key])
+	}
+	return strings.Join(args, " ")
+}
+
+// checkRecordIdentity stops a node of one cluster being added to the
+// record of another
+func checkRecordIdentity(record clusterRecord, identity clusterIdentity) error {
+	if len(record.Name) > 0 && len(identity.Name) > 0 && record.Name != identity.Name {
+		return fmt.Errorf("the record is of cluster %s, not %s", record.Name, identity.Name)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_getClusterIdentity(t *testing.T) {
+	command := MakeInstall()
+	command.Flags().Set("cluster-name", "edge-lab")
+	command.Flags().Set("cluster-labels", "site=lab,env=prod")
+
+	identity, err := getClusterIdentity(command, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--node-label k3sup.io/cluster-name=edge-lab --node-label env=prod --node-label site=lab"
+	if got := identity.nodeLabelArgs(); got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	for _, flags := range []map[string]string{
+		{"cluster-name": "Edge_Lab"},
+		{"cluster-labels": "env=prod lab"},
+		{"cluster-labels": clusterNameLabel + "=other"},
+	} {
+		command := MakeInstall()
+		for flag, value := range flags {
+			command.Flags().Set(flag, value)
+		}
+		if _, err := getClusterIdentity(command, ""); err == nil {
+			t.Errorf("want an error for %v", flags)
+		}
+	}
+}
+
+func Test_getClusterIdentity_FromRecord(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-identity")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recordPath := path.Join(dir, "record.json")
+	data, _ := json.Marshal(clusterRecord{Name: "edge-lab", Labels: map[string]string{"env": "prod"}})
+	if err := ioutil.WriteFile(recordPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	identity, err := getClusterIdentity(MakeJoin(), recordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "edge-lab" || identity.Labels["env"] != "prod" {
+		t.Errorf("want the identity of the record, got %+v", identity)
+	}
+
+	command := MakeJoin()
+	command.Flags().Set("cluster-labels", "site=b")
+	identity, err = getClusterIdentity(command, recordPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Name != "edge-lab" {
+		t.Errorf("want the recorded name kept with --cluster-labels, got %q", identity.Name)
+	}
+	if len(identity.Labels) != 1 || identity.Labels["site"] != "b" {
+		t.Errorf("want the labels of --cluster-labels, got %v", identity.Labels)
+	}
+
+	record, _ := readRecord(recordPath)
+	if err := checkRecordIdentity(record, clusterIdentity{Name: "other"}); err == nil {
+		t.Error("want an error adding a node of another cluster to the record")
+	}
+}
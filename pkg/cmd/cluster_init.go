@@ -0,0 +1,28 @@
+package cmd
+
+import "fmt"
+
+// embeddedEtcdSince is the first k3s release to bootstrap etcd for
+// --cluster-init, earlier releases used an experimental dqlite
+var embeddedEtcdSince = k3sVersion{1, 19, 1}
+
+// supportsEmbeddedEtcd reports whether the k3s being installed can run
+// servers with embedded etcd. Commits and custom binaries are assumed to be
+// recent.
+func supportsEmbeddedEtcd(opts installOptions) bool {
+	version, ok := parseK3sVersion(opts.Version)
+	return !ok || len(opts.Commit) > 0 || len(opts.Binary) > 0 || version.atLeast(embeddedEtcdSince)
+}
+
+// clusterInitArgs returns the server flag which makes the first server of
+// an HA control plane start embedded etcd, so that more servers can join it
+func clusterInitArgs(cluster bool, opts installOptions) (string, error) {
+	if !cluster {
+		return "", nil
+	}
+
+	if !supportsEmbeddedEtcd(opts) {
+		return "", fmt.Errorf("--cluster needs k3s %s or newer for embedded etcd, choose one with --k3s-version", embeddedEtcdSince)
+	}
+	return "--cluster-init", nil
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// The networks k3s uses when they aren't given
+const (
+	defaultClusterCIDR = "10.42.0.0/16"
+	defaultServiceCIDR = "10.43.0.0/16"
+)
+
+func addClusterNetworkFlags(command *cobra.Command) {
+	command.Flags().String("cluster-cidr", "", "Network for pod IPs, when "+defaultClusterCIDR+" overlaps with your network")
+	command.Flags().String("service-cidr", "", "Network for service IPs, when "+defaultServiceCIDR+" overlaps with your network")
+	command.Flags().String("cluster-dns", "", "IP of the cluster's DNS service, within --service-cidr (default the 10th address of it)")
+}
+
+// getClusterNetworkArgs checks the networks of the cluster against each
+// other and the host, since k3s only finds an overlap once traffic goes
+// astray, and returns the k3s flags for them
+func getClusterNetworkArgs(command *cobra.Command, host string) (string, error) {
+	clusterCIDR, _ := command.Flags().GetString("cluster-cidr")
+	serviceCIDR, _ := command.Flags().GetString("service-cidr")
+	clusterDNS, _ := command.Flags().GetString("cluster-dns")
+
+	clusterNet, err := parseNetwork("cluster-cidr", clusterCIDR, defaultClusterCIDR)
+	if err != nil {
+		return "", err
+	}
+	serviceNet, err := parseNetwork("service-cidr", serviceCIDR, defaultServiceCIDR)
+	if err != nil {
+		return "", err
+	}
+
+	if networksOverlap(clusterNet, serviceNet) {
+		return "", fmt.Errorf("the pod network %s and the service network %s overlap, change --cluster-cidr or --service-cidr", clusterNet, serviceNet)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, n := range []*net.IPNet{clusterNet, serviceNet} {
+			if n.Contains(ip) {
+				return "", fmt.Errorf("the host %s is inside the cluster's network %s, choose another with --cluster-cidr or --service-cidr", host, n)
+			}
+		}
+	}
+
+	args := []string{}
+	if len(clusterCIDR) > 0 {
+		args = append(args, "--cluster-cidr "+clusterNet.String())
+	}
+	if len(serviceCIDR) > 0 {
+		args = append(args, "--service-cidr "+serviceNet.String())
+	}
+	if len(clusterDNS) > 0 {
+		ip := net.ParseIP(clusterDNS)
+		if ip == nil {
+			return "", fmt.Errorf("--cluster-dns %q is not an IP address", clusterDNS)
+		}
+		if !serviceNet.Contains(ip) {
+			return "", fmt.Errorf("--cluster-dns %s should be within the service network %s", clusterDNS, serviceNet)
+		}
+		args = append(args, "--cluster-dns "+ip.String())
+	}
+	return strings.Join(args, " "), nil
+}
+
+// parseNetwork reads the CIDR of flag, which has to be the address of a
+// network rather than of a host within it
+func parseNetwork(flag, cidr, defaultCIDR string) (*net.IPNet, error) {
+	if len(cidr) == 0 {
+		cidr = defaultCIDR
+	}
+	ip, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		return nil, fmt.Errorf("--%s %q is not a CIDR such as %s", flag, cidr, defaultCIDR)
+	}
+	if !ip.Equal(network.IP) {
+		return nil, fmt.Errorf("--%s %s should be the address of the network, %s", flag, cidr, network)
+	}
+	return network, nil
+}
+
+func networksOverlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
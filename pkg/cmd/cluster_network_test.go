@@ -0,0 +1,44 @@
+package cmd
+
+import "testing"
+
+func Test_getClusterNetworkArgs(t *testing.T) {
+	cases := []struct {
+		name    string
+		flags   map[string]string
+		host    string
+		want    string
+		wantErr bool
+	}{
+		{name: "defaults", host: "192.168.0.100", want: ""},
+		{name: "all three", flags: map[string]string{"cluster-cidr": "172.16.0.0/16", "service-cidr": "172.17.0.0/16", "cluster-dns": "172.17.0.10"},
+			host: "10.42.0.5", want: "--cluster-cidr 172.16.0.0/16 --service-cidr 172.17.0.0/16 --cluster-dns 172.17.0.10"},
+		{name: "not a CIDR", flags: map[string]string{"cluster-cidr": "172.16.0.0"}, wantErr: true},
+		{name: "host bits set", flags: map[string]string{"service-cidr": "172.17.0.1/16"}, wantErr: true},
+		{name: "overlaps the default service network", flags: map[string]string{"cluster-cidr": "10.0.0.0/8"}, wantErr: true},
+		{name: "contains the host", flags: map[string]string{"cluster-cidr": "192.168.0.0/24"}, host: "192.168.0.100", wantErr: true},
+		{name: "dns outside the service network", flags: map[string]string{"cluster-dns": "10.42.0.10"}, wantErr: true},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			command := MakeInstall()
+			for flag, value := range c.flags {
+				command.Flags().Set(flag, value)
+			}
+			got, err := getClusterNetworkArgs(command, c.host)
+			if c.wantErr {
+				if err == nil {
+					t.Errorf("want an error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+const clusterInfoConfigMap = "k3sup-info"
+
+// writeClusterInfo records how the cluster was created in the k3sup-info
+// ConfigMap in kube-system, so that the cluster describes itself without
+// relying on the operator's local files
+func writeClusterInfo(operator kssh.Operator, nodeIP, k3sVersion, clusterName, escalation string) error {
+	now := time.Now().UTC().Format(time.RFC3339)
+
+	// Values such as "custom binary" have spaces, so every literal is quoted
+	literals := [][2]string{
+		{"k3s-version", k3sVersion},
+		{"k3sup-version", k3supVersion()},
+		{"created-at", now},
+	}
+	if len(clusterName) > 0 {
+		literals = append(literals, [2]string{"cluster-name", clusterName})
+	}
+	literals = append(literals,
+		[2]string{nodeKey(nodeIP), "server"},
+		[2]string{nodeKey(nodeIP) + ".installed-at", now})
+
+	args := ""
+	for _, literal := range literals {
+		args += " --from-literal=" + kssh.ShellQuote(literal[0]+"="+literal[1])
+	}
+
+	command := fmt.Sprintf("%sk3s kubectl create configmap %s -n kube-system%s --dry-run -o yaml | %sk3s kubectl apply -f -",
+		escalation, clusterInfoConfigMap, args, escalation)
+
+	return runClusterInfoCommand(operator, command)
+}
+
+// recordClusterNode adds a node and its role to the k3sup-info ConfigMap
+func recordClusterNode(operator kssh.Operator, nodeIP, role, escalation string) error {
+	patch, _ := json.Marshal(map[string]map[string]string{
+		"data": {
+			nodeKey(nodeIP):                   role,
+			nodeKey(nodeIP) + ".installed-at": time.Now().UTC().Format(time.RFC3339),
+		},
+	})
+
+	command := fmt.Sprintf("%sk3s kubectl patch configmap %s -n kube-system --type merge -p %s", escalation, clusterInfoConfigMap, kssh.ShellQuote(string(patch)))
+
+	return runClusterInfoCommand(operator, command)
+}
+
+var notConfigMapKey = regexp.MustCompile(`[^-._a-zA-Z0-9]`)
+
+// nodeKey is the ConfigMap key of a node. Keys can't hold the colons of
+// an IPv6 address, so fd00::10 is recorded as node.fd00--10.
+func nodeKey(host string) string {
+	return "node." + notConfigMapKey.ReplaceAllString(host, "-")
+}
+
+// runClusterInfoCommand retries for a short while, since the API server
+// may still be starting when the installer returns
+func runClusterInfoCommand(operator kssh.Operator, command string) error {
+	deadline := time.Now().Add(time.Second * 30)
+
+	for {
+		res, err := operator.ExecuteSilent(command)
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("unable to update %s ConfigMap: %s %s", clusterInfoConfigMap, err, strings.TrimSpace(string(res.StdErr)))
+		}
+		time.Sleep(time.Second * 3)
+	}
+}
+
+func k3supVersion() string {
+	if len(Version) == 0 {
+		return "dev"
+	}
+	return Version
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_writeClusterInfo_QuotesLiterals(t *testing.T) {
+	operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+		return kssh.CommandRes{}, nil
+	})
+
+	if err := writeClusterInfo(operator, "192.168.0.10", "custom binary", "edge's lab", "sudo "); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := operator.Commands()
+	if len(commands) != 1 {
+		t.Fatalf("want one command, got %v", commands)
+	}
+	for _, want := range []string{
+		`--from-literal='k3s-version=custom binary'`,
+		`--from-literal='cluster-name=edge'\''s lab'`,
+		`--from-literal='node.192.168.0.10=server'`,
+	} {
+		if !strings.Contains(commands[0], want) {
+			t.Errorf("want %s in %q", want, commands[0])
+		}
+	}
+}
+
+func Test_recordClusterNode_IPv6(t *testing.T) {
+	operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+		return kssh.CommandRes{}, nil
+	})
+
+	if err := recordClusterNode(operator, "fd00::10", "agent", "sudo "); err != nil {
+		t.Fatal(err)
+	}
+
+	commands := operator.Commands()
+	if len(commands) != 1 || !strings.Contains(commands[0], `"node.fd00--10":"agent"`) {
+		t.Errorf("want the IPv6 host as node.fd00--10, got %v", commands)
+	}
+	if strings.Contains(commands[0], "fd00::10") {
+		t.Errorf("want no colons in the keys, got %s", commands[0])
+	}
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func MakeCompletion() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "completion SHELL",
+		Short: "Print the shell completion script for bash, zsh or powershell",
+		Long: `Print the shell completion script for bash, zsh or powershell. Load it
+from your shell's profile to complete k3sup commands and flags.`,
+		Example: `  source <(k3sup completion bash)
+  k3sup completion zsh > "${fpath[1]}/_k3sup"
+  k3sup completion powershell | Out-String | Invoke-Expression`,
+		Args:         cobra.ExactArgs(1),
+		ValidArgs:    []string{"bash", "zsh", "powershell"},
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		return writeCompletion(command.Root(), args[0], os.Stdout)
+	}
+
+	command.AddCommand(makeCompletionInstall())
+
+	return command
+}
+
+func writeCompletion(root *cobra.Command, shell string, out io.Writer) error {
+	switch shell {
+	case "bash":
+		return root.GenBashCompletion(out)
+	case "zsh":
+		return root.GenZshCompletion(out)
+	case "powershell":
+		return root.GenPowerShellCompletion(out)
+	}
+	return fmt.Errorf("unknown shell %q, use bash, zsh or powershell", shell)
+}
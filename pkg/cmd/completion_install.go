@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/spf13/cobra"
+)
+
+const completionProfileMarker = "# Added by k3sup completion install"
+
+func makeCompletionInstall() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "install",
+		Short: "Install the completion script where your shell loads it from",
+		Long: `Install the completion script where your shell loads it from: the
+Homebrew prefix when brew is installed, otherwise the per-user completion
+directory, and your profile for PowerShell. The shell is detected from
+$SHELL unless --shell is given.`,
+		Example: `  k3sup completion install
+  k3sup completion install --shell zsh`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("shell", "", "Shell to install completion for: bash, zsh or powershell")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		shell, _ := command.Flags().GetString("shell")
+		if len(shell) == 0 {
+			shell = detectShell()
+		}
+
+		script := &bytes.Buffer{}
+		if err := writeCompletion(command.Root(), shell, script); err != nil {
+			return err
+		}
+
+		path, profileLine, err := completionPath(shell)
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(path, script.Bytes(), 0644); err != nil {
+			return fmt.Errorf("unable to write %s: %s", path, err)
+		}
+		fmt.Printf("Installed %s completion to %s\n", shell, path)
+
+		if len(profileLine) > 0 {
+			profile, err := shellProfile(shell)
+			if err != nil {
+				return err
+			}
+			added, err := appendToProfile(profile, profileLine)
+			if err != nil {
+				return err
+			}
+			if added {
+				fmt.Printf("Updated %s to load it\n", profile)
+			}
+		}
+
+		fmt.Println("Start a new shell to use it")
+		return nil
+	}
+
+	return command
+}
+
+func detectShell() string {
+	if runtime.GOOS == "windows" {
+		return "powershell"
+	}
+	return filepath.Base(os.Getenv("SHELL"))
+}
+
+// completionPath decides where the script goes, and the line the shell's
+// profile needs to load it when it isn't picked up automatically
+func completionPath(shell string) (string, string, error) {
+	home, _ := homedir.Dir()
+	brewPrefix := commandOutput("brew", "--prefix")
+
+	switch shell {
+	case "bash":
+		if len(brewPrefix) > 0 {
+			return filepath.Join(brewPrefix, "etc", "bash_completion.d", "k3sup"), "", nil
+		}
+		// The user directory of bash-completion 2, loaded on demand
+		dataHome := os.Getenv("XDG_DATA_HOME")
+		if len(dataHome) == 0 {
+			dataHome = filepath.Join(home, ".local", "share")
+		}
+		return filepath.Join(dataHome, "bash-completion", "completions", "k3sup"), "", nil
+	case "zsh":
+		if len(brewPrefix) > 0 {
+			return filepath.Join(brewPrefix, "share", "zsh", "site-functions", "_k3sup"), "", nil
+		}
+		dir := filepath.Join(home, ".zsh", "completions")
+		return filepath.Join(dir, "_k3sup"), fmt.Sprintf("fpath=(%s $fpath); autoload -U compinit && compinit", dir), nil
+	case "powershell":
+		profile, err := shellProfile(shell)
+		if err != nil {
+			return "", "", err
+		}
+		path := filepath.Join(filepath.Dir(profile), "k3sup-completion.ps1")
+		return path, fmt.Sprintf(". '%s'", path), nil
+	case "fish":
+		return "", "", fmt.Errorf("fish completion isn't available yet, use bash, zsh or powershell")
+	}
+	return "", "", fmt.Errorf("unknown shell %q, give one of bash, zsh or powershell with --shell", shell)
+}
+
+func shellProfile(shell string) (string, error) {
+	home, _ := homedir.Dir()
+
+	switch shell {
+	case "zsh":
+		return filepath.Join(home, ".zshrc"), nil
+	case "powershell":
+		for _, name := range []string{"pwsh", "powershell"} {
+			if profile := commandOutput(name, "-NoProfile", "-Command", "$PROFILE"); len(profile) > 0 {
+				return profile, nil
+			}
+		}
+		return "", fmt.Errorf("unable to find your PowerShell profile, is PowerShell installed?")
+	}
+	return "", fmt.Errorf("no profile is updated for %s", shell)
+}
+
+// appendToProfile adds line to the profile unless it is already there
+func appendToProfile(profile, line string) (bool, error) {
+	existing, err := ioutil.ReadFile(profile)
+	if err != nil && !os.IsNotExist(err) {
+		return false, err
+	}
+	if strings.Contains(string(existing), line) {
+		return false, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(profile), 0755); err != nil {
+		return false, err
+	}
+	file, err := os.OpenFile(profile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "\n%s\n%s\n", completionProfileMarker, line)
+	return err == nil, err
+}
+
+// commandOutput runs a local command, returning its trimmed output or an
+// empty string when it isn't installed or fails
+func commandOutput(name string, args ...string) string {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,164 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// patchTargets maps each target to the path its fragment is written to.
+// k3s renders containerd's config.toml from the template, and reads extra
+// configuration such as kubelet-arg from the config.yaml.d directory.
+var patchTargets = map[string]func(file string) string{
+	"containerd": func(file string) string {
+		return "/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl"
+	},
+	"kubelet": func(file string) string {
+		return "/etc/rancher/k3s/config.yaml.d/" + filepath.Base(file)
+	},
+}
+
+func MakeConfig() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "config",
+		Short:        "Manage the k3s configuration on nodes",
+		Long:         `Manage the k3s configuration on nodes.`,
+		Example:      `  k3sup config patch --file config.toml.tmpl --target containerd --ip 192.168.0.100,192.168.0.101`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeConfigPatch())
+
+	return command
+}
+
+func makeConfigPatch() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "patch",
+		Short: "Place a configuration fragment on each node and restart k3s",
+		Long: `Place a configuration fragment on each node and restart k3s.
+
+Targets:
+  containerd  the file replaces k3s' containerd config.toml.tmpl
+  kubelet     the file is added to /etc/rancher/k3s/config.yaml.d/ and
+              should contain kubelet-arg entries
+
+Any file being replaced is kept alongside it with a .bak-<timestamp> suffix.`,
+		Example: `  k3sup config patch --file config.toml.tmpl --target containerd \
+    --ip 192.168.0.100,192.168.0.101,192.168.0.102`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the nodes to patch, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the nodes to patch, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().String("file", "", "Local configuration fragment to place on each node")
+	command.Flags().String("target", "containerd", "Component to configure: containerd or kubelet")
+	command.Flags().Bool("restart", true, "Restart k3s on each node to apply the change")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		hosts, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		file, _ := command.Flags().GetString("file")
+		target, _ := command.Flags().GetString("target")
+		restart, _ := command.Flags().GetBool("restart")
+
+		targetPath, ok := patchTargets[target]
+		if !ok {
+			return fmt.Errorf("unknown target %q, use containerd or kubelet", target)
+		}
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give at least one node with --ip or --host")
+		}
+		if len(file) == 0 {
+			return fmt.Errorf("give the configuration fragment to place with --file")
+		}
+
+		data, err := ioutil.ReadFile(expandPath(file))
+		if err != nil {
+			return fmt.Errorf("unable to read --file: %s", err)
+		}
+
+		remotePath := targetPath(file)
+		sshKeyPath := expandPath(sshKey)
+		failed := 0
+		defer printWarnings(os.Stdout)
+
+		for _, host := range hosts {
+			fmt.Printf("Patching %s on %s\n", remotePath, host)
+
+			if err := patchNode(host, port, user, sshKeyPath, data, remotePath, restart); err != nil {
+				failed++
+				fmt.Printf("FAIL\t%s\t%s\n", host, err)
+				continue
+			}
+			fmt.Printf("OK\t%s\n", host)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to patch %d of %d node(s)", failed, len(hosts))
+		}
+		return nil
+	}
+
+	return command
+}
+
+func patchNode(host string, port int, user, sshKeyPath string, data []byte, remotePath string, restart bool) error {
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+
+	defer closeOperator()
+
+	if remoteChecksum(operator, remotePath, escalationSudo+" ") == fmt.Sprintf("%x", sha256.Sum256(data)) {
+		fmt.Printf("%s is unchanged on %s, nothing to do\n", remotePath, host)
+		return nil
+	}
+
+	backup := fmt.Sprintf("if [ -f '%s' ]; then sudo cp -p '%s' '%s.bak-%d'; fi", remotePath, remotePath, remotePath, time.Now().Unix())
+	if res, err := operator.ExecuteSilent(backup); err != nil {
+		return fmt.Errorf("unable to back up %s: %s %s", remotePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	if err := uploadFile(context.Background(), operator, bytes.NewReader(data), remotePath, "0600", escalationSudo+" "); err != nil {
+		return err
+	}
+
+	if restart {
+		return restartK3s(operator)
+	}
+	return nil
+}
+
+// restartK3s restarts whichever of the server or agent services is
+// installed on the node
+func restartK3s(operator kssh.Operator) error {
+	command := "if systemctl cat k3s > /dev/null 2>&1; then sudo systemctl restart k3s; " +
+		"elif systemctl cat k3s-agent > /dev/null 2>&1; then sudo systemctl restart k3s-agent; " +
+		"else echo 'no k3s service found' >&2; exit 1; fi"
+
+	res, err := operator.ExecuteSilent(command)
+	if err != nil {
+		return fmt.Errorf("unable to restart k3s: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
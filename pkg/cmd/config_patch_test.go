@@ -0,0 +1,16 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_configPatch_NeedsFile(t *testing.T) {
+	command := makeConfigPatch()
+	command.Flags().Set("ip", "192.168.0.100")
+
+	err := command.RunE(command, nil)
+	if err == nil || !strings.Contains(err.Error(), "--file") {
+		t.Errorf("want an error asking for --file, got %v", err)
+	}
+}
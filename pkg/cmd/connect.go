@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// connectOperator opens an SSH connection to host using the private key at
+// sshKeyPath, falling back to the ssh-agent for encrypted keys. The
+// returned function closes both the connection and the agent.
+func connectOperator(host string, port int, user, sshKeyPath string) (kssh.Operator, func(), error) {
+	authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+	}
+
+	operator, err := dialOperator(host, port, user, authMethod)
+	if err != nil {
+		closeSSHAgent()
+		return nil, nil, err
+	}
+
+	closeAll := func() {
+		operator.Close()
+		closeSSHAgent()
+	}
+
+	return operator, closeAll, nil
+}
+
+// waitForSSH keeps trying to connect to host until it succeeds or the
+// timeout passes. The key is only loaded once, so a passphrase is asked
+// for at most once.
+func waitForSSH(ctx context.Context, host string, port int, user, sshKeyPath string, timeout time.Duration) (kssh.Operator, func(), error) {
+	authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		operator, err := dialOperator(host, port, user, authMethod)
+		if err == nil {
+			closeAll := func() {
+				operator.Close()
+				closeSSHAgent()
+			}
+			return operator, closeAll, nil
+		}
+
+		if time.Now().After(deadline) {
+			closeSSHAgent()
+			return nil, nil, errors.Wrapf(err, "gave up waiting for ssh after %s", timeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			closeSSHAgent()
+			return nil, nil, ctx.Err()
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func dialOperator(host string, port int, user string, authMethod ssh.AuthMethod) (kssh.Operator, error) {
+	config := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{
+			authMethod,
+		},
+		HostKeyCallback:   ssh.InsecureIgnoreHostKey(),
+		Timeout:           time.Second * 10,
+		Config:            sshAlgorithms,
+		HostKeyAlgorithms: sshHostKeyAlgorithms,
+	}
+
+	warn(msgHostKey)
+
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	operator, err := kssh.NewSSHOperator(address, config)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to connect to %s over ssh", address)
+	}
+
+	return operator, nil
+}
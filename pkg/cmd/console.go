@@ -0,0 +1,35 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// colorOutput reports whether escape codes can be written to stdout. They
+// are left out when NO_COLOR is set, when output is redirected and on
+// consoles which can't interpret them, such as conhost before Windows 10.
+func colorOutput() bool {
+	if len(os.Getenv("NO_COLOR")) > 0 {
+		return false
+	}
+
+	fd := int(os.Stdout.Fd())
+	if !terminal.IsTerminal(fd) {
+		return false
+	}
+	return enableVirtualTerminal(fd)
+}
+
+// askYesNo asks question and reports whether it was answered yes, anything
+// else, including no input, is taken as no
+func askYesNo(question string, in io.Reader, out io.Writer) bool {
+	fmt.Fprintf(out, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(in).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+// +build !windows
+
+package cmd
+
+func enableVirtualTerminal(fd int) bool {
+	return true
+}
@@ -0,0 +1,21 @@
+//go:build windows
+// +build windows
+
+package cmd
+
+import "golang.org/x/sys/windows"
+
+// enableVirtualTerminal asks the console to interpret escape codes, which
+// fails on conhost before Windows 10
+func enableVirtualTerminal(fd int) bool {
+	var mode uint32
+	handle := windows.Handle(fd)
+	if err := windows.GetConsoleMode(handle, &mode); err != nil {
+		return false
+	}
+
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(handle, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+)
+
+// cryptoPolicyEnv selects the crypto policy at runtime, a binary built
+// with the fips tag always uses the fips policy
+const cryptoPolicyEnv = "K3SUP_CRYPTO_POLICY"
+
+const (
+	cryptoPolicyDefault = "default"
+	cryptoPolicyFIPS    = "fips"
+)
+
+// builtWithFIPS is set by crypto_policy_fips.go
+var builtWithFIPS = false
+
+// FIPS-approved SSH algorithms, the only ones offered under the fips
+// policy. RSA host keys are kept since verifying SHA-1 signatures is still
+// allowed for legacy use, and most hosts have no other key.
+var (
+	fipsSSHCiphers = []string{
+		"aes128-gcm@openssh.com", "aes128-ctr", "aes192-ctr", "aes256-ctr",
+	}
+	fipsSSHKeyExchanges = []string{
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group-exchange-sha256",
+	}
+	fipsSSHMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256",
+	}
+	fipsSSHHostKeyAlgorithms = []string{
+		"ecdsa-sha2-nistp256-cert-v01@openssh.com", "ecdsa-sha2-nistp384-cert-v01@openssh.com", "ecdsa-sha2-nistp521-cert-v01@openssh.com",
+		"ecdsa-sha2-nistp256", "ecdsa-sha2-nistp384", "ecdsa-sha2-nistp521",
+		"ssh-rsa-cert-v01@openssh.com", "ssh-rsa",
+	}
+)
+
+// cryptoPolicy returns the policy in force, from the build tag or from
+// $K3SUP_CRYPTO_POLICY
+func cryptoPolicy() (string, error) {
+	if builtWithFIPS {
+		return cryptoPolicyFIPS, nil
+	}
+
+	switch policy := os.Getenv(cryptoPolicyEnv); policy {
+	case "", cryptoPolicyDefault:
+		return cryptoPolicyDefault, nil
+	case cryptoPolicyFIPS:
+		return cryptoPolicyFIPS, nil
+	default:
+		return "", fmt.Errorf("unknown $%s %q, use default or fips", cryptoPolicyEnv, policy)
+	}
+}
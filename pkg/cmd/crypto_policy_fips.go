@@ -0,0 +1,8 @@
+//go:build fips
+// +build fips
+
+package cmd
+
+func init() {
+	builtWithFIPS = true
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// debugCollector is one piece of state gathered from a failing node, saved
+// to File in the bundle
+type debugCollector struct {
+	Name    string
+	File    string
+	Command string
+}
+
+// debugCollectors are run in order, each may fail without stopping the
+// others since a broken node rarely answers all of them. %[1]d is the
+// number of journal lines to read.
+var debugCollectors = []debugCollector{
+	{"service status", "service.txt", "systemctl status k3s k3s-agent --no-pager -l 2>&1 || rc-service k3s status 2>&1"},
+	{"journal", "journal.txt", "sudo journalctl -u k3s -u k3s-agent -n %[1]d --no-pager 2>&1 || sudo tail -n %[1]d /var/log/k3s.log 2>&1"},
+	{"containerd", "containerd.txt", "sudo k3s crictl ps -a 2>&1; sudo k3s crictl images 2>&1"},
+	{"disk", "disk.txt", "df -P / /var/lib/rancher 2>/dev/null; df -i / 2>&1"},
+	{"memory", "memory.txt", "free -m 2>&1; cat /proc/pressure/memory 2>/dev/null; dmesg 2>/dev/null | grep -i 'out of memory' | tail -n 5"},
+	{"nodes", "nodes.txt", "sudo k3s kubectl get nodes -o wide 2>&1"},
+	{"events", "events.txt", "sudo k3s kubectl get events -A --sort-by=.lastTimestamp 2>&1 | tail -n 100"},
+}
+
+// debugHeuristic is a likely cause of a failure and how to spot it in the
+// collected output. Weight ranks causes which explain more of a failure
+// above their symptoms.
+type debugHeuristic struct {
+	Weight  int
+	Cause   string
+	Hint    string
+	Files   []string
+	Pattern *regexp.Regexp
+}
+
+var debugHeuristics = []debugHeuristic{
+	{90, "the disk is full", "free space under /var/lib/rancher, or prune images with k3s crictl rmi --prune",
+		[]string{"journal.txt", "events.txt"}, regexp.MustCompile(`(?i)no space left on device`)},
+	{85, "the memory cgroup is disabled", "add cgroup_memory=1 cgroup_enable=memory to the kernel command line, /boot/cmdline.txt on a Raspberry Pi, and reboot",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)failed to find memory cgroup|cgroup.*memory.*not (found|enabled)`)},
+	{85, "another node already joined with the same hostname", "give each host a unique hostname, or remove the old node with kubectl delete node",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)node password rejected`)},
+	{80, "a port k3s needs is already in use", "stop the other process, such as an earlier k3s, kubelet or a web server on 6443 or 10250",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)address already in use`)},
+	{75, "TLS certificates don't verify", "check that the clock is in sync with timedatectl, and that the agent joins the right server",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)x509: certificate (has expired|is not yet valid|signed by unknown authority)`)},
+	{70, "the agent can't reach the server", "check that port 6443 on the server is open to the agent, and the --server-ip it joined with",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)(connection refused|i/o timeout|no route to host).*6443|6443.*(connection refused|i/o timeout|no route to host)`)},
+	{65, "the join token was rejected", "join again with the server's current node-token",
+		[]string{"journal.txt"}, regexp.MustCompile(`(?i)401 unauthorized|token.*(invalid|rejected)`)},
+	{60, "the node is under memory pressure", "give the node more memory or run fewer workloads on it, see k3sup install --service-memory-max",
+		[]string{"memory.txt", "events.txt"}, regexp.MustCompile(`(?i)out of memory|oom-?kill|MemoryPressure`)},
+	{55, "the node is under disk pressure", "free space on the node, pods are evicted until it drops",
+		[]string{"events.txt"}, regexp.MustCompile(`(?i)DiskPressure|Evicted`)},
+	{45, "images can't be pulled", "check the node's DNS and access to the registry, or configure a mirror in registries.yaml",
+		[]string{"events.txt", "journal.txt"}, regexp.MustCompile(`(?i)ErrImagePull|ImagePullBackOff|failed to pull image`)},
+	{40, "k3s exited with a fatal error", "the journal line above is the error k3s stopped on",
+		[]string{"journal.txt"}, regexp.MustCompile(`level=fatal.*`)},
+	{30, "a node isn't ready", "read the journal of that node, k3sup debug can be run on it too",
+		[]string{"nodes.txt"}, regexp.MustCompile(`\sNotReady\s`)},
+}
+
+// debugDiskFull is the use of a filesystem in df -P above which it is
+// reported even without an error in the journal
+const debugDiskFull = 90
+
+var dfUse = regexp.MustCompile(`\s([0-9]+)%\s+(\S+)$`)
+
+// debugFinding is a cause found on the node, with the line which shows it
+type debugFinding struct {
+	Weight   int
+	Cause    string
+	Hint     string
+	Evidence string
+}
+
+func MakeDebug() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "debug",
+		Short: "Collect the state of a failing node and suggest what is wrong",
+		Long: `Collect the state of a failing node over SSH: the k3s service status, the
+tail of its journal, the containers and images in containerd, disk and
+memory use, and the nodes and recent events from the API when the node is
+a server. Everything is saved to a tarball to attach to an issue, and the
+likely causes found in it are printed, most likely first.`,
+		Example:      `  k3sup debug --ip 192.168.0.101 --user pi`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", nil, "Public IP of the node to debug")
+	command.Flags().String("host", "", "DNS name of the node to debug, instead of --ip")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().StringP("output", "o", "k3sup-debug.tgz", "Path of the tarball to write")
+	command.Flags().Int("journal-lines", 500, "Number of lines of the k3s journal to collect")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		output, _ := command.Flags().GetString("output")
+		journalLines, _ := command.Flags().GetInt("journal-lines")
+
+		if journalLines <= 0 {
+			return fmt.Errorf("--journal-lines should be more than 0")
+		}
+
+		operator, closeOperator, err := connectOperator(host, port, user, expandPath(sshKey))
+		if err != nil {
+			return errors.Wrapf(err, "unable to connect to %s", host)
+		}
+		defer closeOperator()
+
+		collected := collectDebug(operator, journalLines)
+		findings := diagnose(collected)
+
+		report := debugReport(host, findings)
+		outputPath := expandPath(output)
+		if err := writeDebugBundle(outputPath, host, collected, report); err != nil {
+			return err
+		}
+
+		fmt.Println()
+		fmt.Print(report)
+		fmt.Printf("\nSaved %s\n", outputPath)
+		return nil
+	}
+
+	command.PreRunE = func(command *cobra.Command, args []string) error {
+		if !command.Flags().Changed("ip") && !command.Flags().Changed("host") {
+			return fmt.Errorf("give the node to debug with --ip or --host")
+		}
+		return nil
+	}
+
+	return command
+}
+
+// collectDebug runs each collector, keeping its output by file name
+func collectDebug(operator kssh.Operator, journalLines int) map[string]string {
+	collected := map[string]string{}
+	for _, c := range debugCollectors {
+		fmt.Printf("Collecting %s\n", c.Name)
+		command := c.Command
+		if strings.Contains(command, "%[1]d") {
+			command = fmt.Sprintf(command, journalLines)
+		}
+
+		res, err := operator.ExecuteSilent(command)
+		output := string(res.StdOut) + string(res.StdErr)
+		if err != nil && len(strings.TrimSpace(output)) == 0 {
+			output = fmt.Sprintf("(unable to collect %s: %s)\n", c.Name, err)
+		}
+		collected[c.File] = output
+	}
+	return collected
+}
+
+// diagnose matches the collected output against each heuristic and ranks
+// what it finds, most likely first
+func diagnose(collected map[string]string) []debugFinding {
+	findings := []debugFinding{}
+	for _, h := range debugHeuristics {
+		for _, file := range h.Files {
+			if evidence := h.Pattern.FindString(collected[file]); len(evidence) > 0 {
+				findings = append(findings, debugFinding{h.Weight, h.Cause, h.Hint, strings.TrimSpace(evidence)})
+				break
+			}
+		}
+	}
+
+	for _, line := range strings.Split(collected["disk.txt"], "\n") {
+		match := dfUse.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		if use, _ := strconv.Atoi(match[1]); use >= debugDiskFull {
+			findings = append(findings, debugFinding{50, fmt.Sprintf("%s is %d%% full", match[2], use),
+				"free space before k3s and its pods run out", strings.TrimSpace(line)})
+		}
+	}
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		return findings[i].Weight > findings[j].Weight
+	})
+	return findings
+}
+
+// debugReport lists the findings as numbered likely causes
+func debugReport(host string, findings []debugFinding) string {
+	if len(findings) == 0 {
+		return fmt.Sprintf("No known problems found on %s, read the collected files for more\n", host)
+	}
+
+	report := fmt.Sprintf("Likely causes on %s, most likely first:\n", host)
+	for i, f := range findings {
+		report += fmt.Sprintf("%d. %s\n   %s\n   Fix: %s\n", i+1, f.Cause, f.Evidence, f.Hint)
+	}
+	return report
+}
+
+// writeDebugBundle saves the collected files and the report to a tarball,
+// under a directory named after the host
+func writeDebugBundle(outputPath, host string, collected map[string]string, report string) error {
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %s", outputPath, err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	archive := tar.NewWriter(gz)
+
+	for _, c := range debugCollectors {
+		if err := addTarFile(archive, path.Join(host, c.File), []byte(collected[c.File])); err != nil {
+			return err
+		}
+	}
+	if err := addTarFile(archive, path.Join(host, "causes.txt"), []byte(report)); err != nil {
+		return err
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import "testing"
+
+func Test_diagnose(t *testing.T) {
+	collected := map[string]string{
+		"journal.txt": `Oct 15 10:00:01 pi k3s[812]: time="2020-10-15T10:00:01Z" level=info msg="Starting k3s v1.19.5+k3s2"
+Oct 15 10:00:02 pi k3s[812]: time="2020-10-15T10:00:02Z" level=fatal msg="failed to find memory cgroup, you may need to add \"cgroup_memory=1 cgroup_enable=memory\" to your linux cmdline"`,
+		"disk.txt": `Filesystem     1024-blocks     Used Available Capacity Mounted on
+/dev/root         30000000 28500000   1500000      95% /`,
+	}
+
+	findings := diagnose(collected)
+	if len(findings) != 3 {
+		t.Fatalf("want 3 findings, got %d: %v", len(findings), findings)
+	}
+
+	want := []string{"the memory cgroup is disabled", "/ is 95% full", "k3s exited with a fatal error"}
+	for i, cause := range want {
+		if findings[i].Cause != cause {
+			t.Errorf("finding %d: want %q, got %q", i+1, cause, findings[i].Cause)
+		}
+	}
+}
+
+func Test_diagnose_nothingFound(t *testing.T) {
+	if findings := diagnose(map[string]string{"journal.txt": "level=info msg=\"Running kubelet\""}); len(findings) != 0 {
+		t.Errorf("want no findings, got %v", findings)
+	}
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// bundledComponents are the add-ons k3s deploys on servers which --disable
+// can turn off
+var bundledComponents = []string{"traefik", "servicelb", "metrics-server", "local-storage"}
+
+// disableSince is the first k3s release which calls --no-deploy --disable
+var disableSince = k3sVersion{1, 17, 0}
+
+func addDisableFlag(command *cobra.Command) {
+	command.Flags().StringSlice("disable", nil, "Bundled components not to deploy, can be repeated: "+strings.Join(bundledComponents, ", "))
+}
+
+// getDisableArgs returns the k3s flags which turn off the components of
+// --disable, named for the release being installed
+func getDisableArgs(command *cobra.Command, opts installOptions) (string, error) {
+	components, _ := command.Flags().GetStringSlice("disable")
+
+	flag := "--disable"
+	if version, ok := parseK3sVersion(opts.Version); ok && len(opts.Commit) == 0 && len(opts.Binary) == 0 && !version.atLeast(disableSince) {
+		flag = "--no-deploy"
+	}
+
+	args := []string{}
+	seen := map[string]bool{}
+	for _, component := range components {
+		component = strings.TrimSpace(component)
+		if !isBundledComponent(component) {
+			return "", fmt.Errorf("unknown --disable %q, use %s", component, strings.Join(bundledComponents, ", "))
+		}
+		if !seen[component] {
+			seen[component] = true
+			args = append(args, flag+" "+component)
+		}
+	}
+	return strings.Join(args, " "), nil
+}
+
+func isBundledComponent(name string) bool {
+	for _, component := range bundledComponents {
+		if component == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,262 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// driftWatchedPaths are configuration files k3s reads which k3sup only
+// writes with --ca-registry, so finding one it didn't write is drift
+var driftWatchedPaths = []string{"/etc/rancher/k3s/config.yaml", "/etc/rancher/k3s/registries.yaml"}
+
+// driftChange is one difference between a node and its record
+type driftChange struct {
+	Host     string `json:"host"`
+	Action   string `json:"action"`
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Recorded string `json:"recorded,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+func MakeDiff() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "diff RECORD",
+		Short: "Compare nodes with the record of how they were built",
+		Long: `Compare each node in a record written by install and join with --record
+against how it is configured now: the k3s version, the flags its service
+runs with, the checksums of files k3sup uploaded and configuration files
+k3sup didn't write. Changes made out of band show up here before they
+surprise an upgrade. The command fails when anything changed.`,
+		Example:      `  k3sup diff cluster-record.json --user pi`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().StringP("output", "o", "table", "Output format: table or json")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		output, _ := command.Flags().GetString("output")
+
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", output)
+		}
+
+		record, err := readRecord(expandPath(args[0]))
+		if err != nil {
+			return err
+		}
+		if record.Signature != nil {
+			if _, err := record.verify(); err != nil {
+				return errors.Wrapf(err, "not comparing with %s", args[0])
+			}
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		changes := []driftChange{}
+		for _, node := range record.Nodes {
+			operator, closeOperator, err := connectOperator(node.Host, port, user, sshKeyPath)
+			if err != nil {
+				return errors.Wrapf(err, "unable to connect to %s", node.Host)
+			}
+			changes = append(changes, nodeDrift(operator, node)...)
+			closeOperator()
+		}
+
+		if output == "json" {
+			out, _ := json.MarshalIndent(struct {
+				Changes []driftChange `json:"changes"`
+			}{changes}, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printDrift(changes)
+		}
+
+		if len(changes) > 0 {
+			return fmt.Errorf("%d changes since %s was written", len(changes), args[0])
+		}
+		return nil
+	}
+
+	return command
+}
+
+// nodeDrift compares one node with its entry in the record. The service's
+// environment isn't compared, since the record leaves out the secrets
+// which are kept there.
+func nodeDrift(operator kssh.Operator, node recordNode) []driftChange {
+	changes := []driftChange{}
+	change := func(action, kind, name, recorded, actual string) {
+		changes = append(changes, driftChange{Host: node.Host, Action: action, Kind: kind, Name: name, Recorded: recorded, Actual: actual})
+	}
+
+	// Commits and custom binaries have no version to compare with
+	if strings.HasPrefix(node.K3s, "v") {
+		if actual := k3sVersionOn(operator); actual != node.K3s {
+			change(changeModified, "version", "k3s", node.K3s, actual)
+		}
+	}
+
+	service := "k3s"
+	if node.Role == "agent" {
+		service = "k3s-agent"
+	}
+	if running, ok := serviceArgs(operator, service); ok {
+		removed, added := diffArgs(splitArgs(node.Args), running)
+		for _, arg := range removed {
+			change(changeRemoved, "arg", arg, arg, "")
+		}
+		for _, arg := range added {
+			change(changeAdded, "arg", arg, "", arg)
+		}
+	}
+
+	paths := []string{}
+	for path := range node.Artifacts {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		recorded := node.Artifacts[path]
+		switch actual := remoteChecksum(operator, path, escalationSudo+" "); actual {
+		case recorded:
+		case "":
+			change(changeRemoved, "file", path, recorded, "")
+		default:
+			change(changeModified, "file", path, recorded, actual)
+		}
+	}
+
+	for _, path := range driftWatchedPaths {
+		if _, ok := node.Artifacts[path]; ok {
+			continue
+		}
+		if actual := remoteChecksum(operator, path, escalationSudo+" "); len(actual) > 0 {
+			change(changeAdded, "file", path, "", actual)
+		}
+	}
+
+	return changes
+}
+
+// k3sVersionOn returns the version k3s --version prints, such as
+// v1.19.1+k3s1, or unknown
+func k3sVersionOn(operator kssh.Operator) string {
+	res, err := operator.ExecuteSilent("k3s --version 2> /dev/null || /usr/local/bin/k3s --version")
+	if err != nil {
+		return "unknown"
+	}
+	fields := strings.Fields(string(res.StdOut))
+	if len(fields) < 3 || fields[1] != "version" {
+		return "unknown"
+	}
+	return fields[2]
+}
+
+// serviceArgs returns the k3s flags the systemd service runs with, after
+// the server or agent subcommand. --server and its URL are left out, since
+// join passes them rather than the user.
+func serviceArgs(operator kssh.Operator, service string) ([]string, bool) {
+	res, err := operator.ExecuteSilent("systemctl show -p ExecStart " + service)
+	if err != nil {
+		return nil, false
+	}
+
+	execStart := string(res.StdOut)
+	start := strings.Index(execStart, "argv[]=")
+	if start < 0 {
+		return nil, false
+	}
+	execStart = execStart[start+len("argv[]="):]
+	if end := strings.Index(execStart, " ;"); end >= 0 {
+		execStart = execStart[:end]
+	}
+
+	fields := strings.Fields(execStart)
+	if len(fields) < 2 {
+		return nil, false
+	}
+
+	args := []string{}
+	for i := 2; i < len(fields); i++ {
+		if fields[i] == "--server" {
+			i++
+			continue
+		}
+		args = append(args, fields[i])
+	}
+	return args, true
+}
+
+// splitArgs splits recorded flags the way the shell did when they were
+// passed to the installer, for flags without spaces in their values
+func splitArgs(args string) []string {
+	split := []string{}
+	for _, field := range strings.Fields(args) {
+		split = append(split, strings.Trim(field, `'"`))
+	}
+	return split
+}
+
+// diffArgs returns the recorded flags which are missing from running, and
+// the running flags which weren't recorded
+func diffArgs(recorded, running []string) ([]string, []string) {
+	count := map[string]int{}
+	for _, arg := range recorded {
+		count[arg]++
+	}
+
+	added := []string{}
+	for _, arg := range running {
+		if count[arg] > 0 {
+			count[arg]--
+			continue
+		}
+		added = append(added, arg)
+	}
+
+	removed := []string{}
+	for _, arg := range recorded {
+		if count[arg] > 0 {
+			count[arg]--
+			removed = append(removed, arg)
+		}
+	}
+	return removed, added
+}
+
+func printDrift(changes []driftChange) {
+	if len(changes) == 0 {
+		fmt.Println("No changes, every node matches the record")
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tCHANGE\tKIND\tNAME\tRECORDED\tACTUAL")
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", c.Host, c.Action, c.Kind, c.Name, orDash(c.Recorded), orDash(c.Actual))
+	}
+	w.Flush()
+}
+
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"reflect"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_diffArgs(t *testing.T) {
+	recorded := splitArgs("--tls-san 10.0.0.1 '--disable traefik' --node-label a=b")
+	running := []string{"--tls-san", "10.0.0.1", "--disable", "servicelb", "--node-label", "a=b", "--node-label", "c=d"}
+
+	removed, added := diffArgs(recorded, running)
+	if !reflect.DeepEqual(removed, []string{"traefik"}) {
+		t.Errorf("want traefik removed, got %v", removed)
+	}
+	if !reflect.DeepEqual(added, []string{"servicelb", "--node-label", "c=d"}) {
+		t.Errorf("want servicelb and the second label added, got %v", added)
+	}
+}
+
+func Test_serviceArgs(t *testing.T) {
+	operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+		out := "ExecStart={ path=/usr/local/bin/k3s ; argv[]=/usr/local/bin/k3s agent --server https://192.168.0.100:6443 --node-label zone=a ; ignore_errors=no }\n"
+		return kssh.CommandRes{StdOut: []byte(out)}, nil
+	})
+
+	got, ok := serviceArgs(operator, "k3s-agent")
+	if !ok {
+		t.Fatal("want the service's flags")
+	}
+	if want := []string{"--node-label", "zone=a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+	if commands := operator.Commands(); len(commands) != 1 || commands[0] != "systemctl show -p ExecStart k3s-agent" {
+		t.Errorf("want systemctl show to be run once, got %v", commands)
+	}
+}
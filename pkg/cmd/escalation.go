@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// --sudo modes, auto escalates only when the SSH user isn't root
+const (
+	sudoAuto   = "auto"
+	sudoAlways = "always"
+	sudoNever  = "never"
+)
+
+// --escalation tools, auto uses sudo and then doas, for minimal distros
+// such as Alpine which only ship doas
+const (
+	escalationAuto = "auto"
+	escalationSudo = "sudo"
+	escalationDoas = "doas"
+)
+
+// sudoPasswordEnv holds the sudo password when --sudo-password-file isn't
+// given and there is no terminal to ask on
+const sudoPasswordEnv = "K3SUP_SUDO_PASSWORD"
+
+// sudoOptions are the --sudo and --escalation flags
+type sudoOptions struct {
+	Mode         string
+	Tool         string
+	PasswordFile string
+}
+
+// defaultSudo is what commands without --sudo and --escalation flags
+// detect escalation with
+var defaultSudo = sudoOptions{Mode: sudoAuto, Tool: escalationAuto}
+
+// sudoPassword is kept once read, so that join asks only once for both
+// the server and the node
+var sudoPassword string
+
+// escalationPrefix finds whether commands which need root have to run
+// with sudo or doas on the host, and returns "sudo " or "doas " when they
+// do. A user who needs a password for sudo has every command run as root
+// with it instead, and the prefix is then empty. doas can't be given a
+// password over k3sup's session, so it needs a nopass rule.
+func escalationPrefix(operator kssh.Operator, opts sudoOptions) (string, error) {
+	res, err := operator.ExecuteSilent("id -u; id -un")
+	if err != nil {
+		return "", fmt.Errorf("unable to find the user on the host: %s", err)
+	}
+	id := strings.Fields(string(res.StdOut))
+	if len(id) != 2 {
+		return "", fmt.Errorf("unexpected output from id: %q", string(res.StdOut))
+	}
+	root, user := id[0] == "0", id[1]
+
+	switch {
+	case opts.Mode == sudoNever && !root:
+		return "", fmt.Errorf("%s isn't root and --sudo is never, log in as root or use --sudo auto", user)
+	case opts.Mode == sudoNever || (opts.Mode == sudoAuto && root):
+		return "", nil
+	}
+
+	tools := []string{escalationSudo, escalationDoas}
+	if opts.Tool != escalationAuto {
+		tools = []string{opts.Tool}
+	}
+
+	installed := []string{}
+	for _, tool := range tools {
+		if _, err := operator.ExecuteSilent(tool + " -n true"); err == nil {
+			return tool + " ", nil
+		}
+		if res, _ := operator.ExecuteSilent("command -v " + tool); len(res.StdOut) > 0 {
+			installed = append(installed, tool)
+		}
+	}
+
+	switch {
+	case root:
+		return "", fmt.Errorf("--sudo is always but %s isn't available on the host, use --sudo auto to run as root without it", strings.Join(tools, " or "))
+	case len(installed) == 0:
+		return "", fmt.Errorf("%s isn't root and %s isn't installed on the host, log in as root", user, strings.Join(tools, " or "))
+	case installed[0] == escalationDoas:
+		return "", fmt.Errorf("%s isn't root and doas needs a password, add \"permit nopass %s\" to /etc/doas.conf or log in as root", user, user)
+	}
+
+	password, err := readSudoPassword(user, opts)
+	if err != nil {
+		return "", err
+	}
+	if _, err := operator.ExecuteWithStdin("sudo -k -S -p '' true", strings.NewReader(password+"\n")); err != nil {
+		return "", fmt.Errorf("sudo didn't accept the password for %s", user)
+	}
+
+	operator.SetSudoPassword(password)
+	return "", nil
+}
+
+// installerPrefix runs the k3s installer with doas, since the installer
+// only knows how to use sudo. env carries the installer's variables
+// through doas, which clears the environment.
+func installerPrefix(prefix string) string {
+	if prefix == escalationDoas+" " {
+		return "doas env "
+	}
+	return ""
+}
+
+// readSudoPassword reads the password from --sudo-password-file or
+// $K3SUP_SUDO_PASSWORD, or asks for it
+func readSudoPassword(user string, opts sudoOptions) (string, error) {
+	if len(sudoPassword) > 0 {
+		return sudoPassword, nil
+	}
+
+	switch {
+	case len(opts.PasswordFile) > 0:
+		data, err := ioutil.ReadFile(opts.PasswordFile)
+		if err != nil {
+			return "", fmt.Errorf("unable to read --sudo-password-file: %s", err)
+		}
+		sudoPassword = strings.TrimRight(string(data), "\r\n")
+	case len(os.Getenv(sudoPasswordEnv)) > 0:
+		sudoPassword = os.Getenv(sudoPasswordEnv)
+	default:
+		password, err := prompter.Secret(fmt.Sprintf("[sudo] password for %s", user))
+		if err != nil && err != errNoTerminal {
+			return "", err
+		}
+		sudoPassword = password
+	}
+
+	if len(sudoPassword) == 0 {
+		return "", fmt.Errorf("%s needs a password for sudo, give it with --sudo-password-file or $%s, or allow passwordless sudo with a NOPASSWD rule in /etc/sudoers.d", user, sudoPasswordEnv)
+	}
+	if strings.Contains(sudoPassword, "\n") {
+		return "", fmt.Errorf("the sudo password can't contain a newline")
+	}
+	return sudoPassword, nil
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"fmt"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+type fakePrompter struct {
+	secret string
+	asked  []string
+}
+
+func (p *fakePrompter) Secret(prompt string) (string, error) {
+	p.asked = append(p.asked, prompt)
+	return p.secret, nil
+}
+
+func (p *fakePrompter) Confirm(question string) (bool, error) {
+	return false, nil
+}
+
+func Test_readSudoPassword_AsksThePrompterOnce(t *testing.T) {
+	fake := &fakePrompter{secret: "hunter2"}
+	defer SetPrompter(prompter)
+	SetPrompter(fake)
+	sudoPassword = ""
+	defer func() { sudoPassword = "" }()
+
+	for i := 0; i < 2; i++ {
+		password, err := readSudoPassword("pi", sudoOptions{Mode: sudoAuto})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if password != "hunter2" {
+			t.Errorf("want the prompter's password, got %q", password)
+		}
+	}
+	if len(fake.asked) != 1 || fake.asked[0] != "[sudo] password for pi" {
+		t.Errorf("want one prompt for pi, got %v", fake.asked)
+	}
+}
+
+func Test_installerPrefix_OnlyForDoas(t *testing.T) {
+	if prefix := installerPrefix("sudo "); prefix != "" {
+		t.Errorf("want the installer to use sudo itself, got %q", prefix)
+	}
+	if prefix := installerPrefix("doas "); prefix != "doas env " {
+		t.Errorf("want doas env, got %q", prefix)
+	}
+}
+
+func Test_escalationPrefix_FindsDoasWithoutSudo(t *testing.T) {
+	operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+		switch command {
+		case "id -u; id -un":
+			return kssh.CommandRes{StdOut: []byte("1000\nalex\n")}, nil
+		case "doas -n true":
+			return kssh.CommandRes{}, nil
+		}
+		return kssh.CommandRes{}, fmt.Errorf("Process exited with status 127")
+	})
+
+	got, err := escalationPrefix(operator, sudoOptions{Mode: sudoAuto, Tool: escalationAuto})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "doas " {
+		t.Errorf("want doas, got %q", got)
+	}
+	if len(operator.SudoPassword()) > 0 {
+		t.Errorf("want no sudo password to be set for doas")
+	}
+}
+
+func Test_escalationPrefix_ReappliesCachedPassword(t *testing.T) {
+	fake := &fakePrompter{secret: "hunter2"}
+	defer SetPrompter(prompter)
+	SetPrompter(fake)
+	sudoPassword = ""
+	defer func() { sudoPassword = "" }()
+
+	// A host which needs a password for sudo, connected to again after a
+	// reboot
+	for i := 0; i < 2; i++ {
+		operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+			switch command {
+			case "id -u; id -un":
+				return kssh.CommandRes{StdOut: []byte("1000\npi\n")}, nil
+			case "command -v sudo":
+				return kssh.CommandRes{StdOut: []byte("/usr/bin/sudo\n")}, nil
+			case "sudo -k -S -p '' true":
+				return kssh.CommandRes{}, nil
+			}
+			return kssh.CommandRes{}, fmt.Errorf("Process exited with status 1")
+		})
+
+		if _, err := escalationPrefix(operator, sudoOptions{Mode: sudoAuto, Tool: escalationAuto}); err != nil {
+			t.Fatal(err)
+		}
+		if operator.SudoPassword() != "hunter2" {
+			t.Errorf("connection %d: want the password set, got %q", i+1, operator.SudoPassword())
+		}
+	}
+	if len(fake.asked) != 1 {
+		t.Errorf("want the password asked for once, got %d prompts", len(fake.asked))
+	}
+}
@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// etcdctlScript runs etcdctl with its arguments against the embedded etcd
+// member on the server it runs on, using the client certificate k3s
+// generates for it
+var etcdctlScript = remoteScript{Name: "etcdctl", Version: 1, Body: `if ! command -v etcdctl > /dev/null 2>&1; then
+  echo "etcdctl: command not found" >&2
+  exit 127
+fi
+ETCDCTL_API=3 exec etcdctl --endpoints https://127.0.0.1:2379 \
+  --cacert /var/lib/rancher/k3s/server/tls/etcd/server-ca.crt \
+  --cert /var/lib/rancher/k3s/server/tls/etcd/client.crt \
+  --key /var/lib/rancher/k3s/server/tls/etcd/client.key "$@"`}
+
+func MakeEtcd() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "etcd",
+		Short: "Check and maintain the embedded etcd of k3s servers",
+		Long: `Check and maintain the embedded etcd of k3s servers over SSH. etcdctl
+must be installed on each server.`,
+		Example: `  k3sup etcd status --ip 192.168.0.100,192.168.0.101,192.168.0.102
+  k3sup etcd defrag --ip 192.168.0.100,192.168.0.101,192.168.0.102`,
+		SilenceUsage: true,
+	}
+
+	command.PersistentFlags().IPSlice("ip", nil, "Public IPs of the servers, can be repeated")
+	command.PersistentFlags().StringSlice("host", nil, "DNS names of the servers, can be repeated")
+	command.PersistentFlags().String("user", "root", "Username for SSH login")
+	command.PersistentFlags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.PersistentFlags().Int("ssh-port", 22, "The port on which to connect for ssh")
+
+	command.AddCommand(makeEtcdStatus())
+	command.AddCommand(makeEtcdDefrag())
+	command.AddCommand(makeEtcdCompact())
+
+	return command
+}
+
+// etcdMember is a connection to one server along with the status of its
+// etcd member
+type etcdMember struct {
+	Host     string
+	Operator kssh.Operator
+	Status   etcdStatus
+	Alarms   []string
+}
+
+// etcdStatus is the part of etcdctl endpoint status -w json which k3sup uses
+type etcdStatus struct {
+	Header struct {
+		MemberID uint64 `json:"member_id"`
+		Revision int64  `json:"revision"`
+	} `json:"header"`
+	Version     string `json:"version"`
+	DBSize      int64  `json:"dbSize"`
+	DBSizeInUse int64  `json:"dbSizeInUse"`
+	Leader      uint64 `json:"leader"`
+}
+
+func (s etcdStatus) isLeader() bool {
+	return s.Header.MemberID == s.Leader
+}
+
+// connectEtcdMembers connects to every --ip and --host and reads the status of its
+// member. The returned function closes every connection.
+func connectEtcdMembers(command *cobra.Command) ([]*etcdMember, func(), error) {
+	hosts, err := getHosts(command, "ip", "host")
+	if err != nil {
+		return nil, nil, err
+	}
+	user, _ := command.Flags().GetString("user")
+	sshKey, _ := command.Flags().GetString("ssh-key")
+	port, _ := command.Flags().GetInt("ssh-port")
+
+	if len(hosts) == 0 {
+		return nil, nil, fmt.Errorf("give the servers with --ip or --host")
+	}
+
+	members := []*etcdMember{}
+	closers := []func(){}
+	closeAll := func() {
+		for _, closeOperator := range closers {
+			closeOperator()
+		}
+	}
+
+	sshKeyPath := expandPath(sshKey)
+	for _, host := range hosts {
+		operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+		if err != nil {
+			closeAll()
+			return nil, nil, err
+		}
+		closers = append(closers, closeOperator)
+
+		member := &etcdMember{Host: host, Operator: operator}
+		if err := member.refresh(); err != nil {
+			closeAll()
+			return nil, nil, errors.Wrapf(err, "unable to read the etcd status of %s", member.Host)
+		}
+		members = append(members, member)
+	}
+
+	return members, closeAll, nil
+}
+
+func (m *etcdMember) etcdctl(args string) ([]byte, error) {
+	res, err := runScript(m.Operator, etcdctlScript, escalationSudo+" ", strings.Fields(args)...)
+	if err != nil {
+		stderr := strings.TrimSpace(string(res.StdErr))
+		if strings.Contains(stderr, "etcdctl: command not found") {
+			return nil, fmt.Errorf("etcdctl is not installed on %s", m.Host)
+		}
+		return nil, fmt.Errorf("etcdctl %s failed on %s: %s %s", args, m.Host, err, stderr)
+	}
+	return res.StdOut, nil
+}
+
+func (m *etcdMember) refresh() error {
+	out, err := m.etcdctl("endpoint status -w json")
+	if err != nil {
+		return err
+	}
+
+	statuses := []struct {
+		Status etcdStatus `json:"Status"`
+	}{}
+	if err := json.Unmarshal(out, &statuses); err != nil || len(statuses) == 0 {
+		return fmt.Errorf("unable to parse the etcd status of %s: %s", m.Host, strings.TrimSpace(string(out)))
+	}
+	m.Status = statuses[0].Status
+
+	out, err = m.etcdctl("alarm list")
+	if err != nil {
+		return err
+	}
+	m.Alarms = strings.Fields(strings.Replace(string(out), "\n", " ", -1))
+	return nil
+}
+
+// checkQuorum refuses maintenance unless every member is healthy, since
+// taking one out of service while another is down can lose quorum
+func checkQuorum(members []*etcdMember) error {
+	for _, member := range members {
+		if _, err := member.etcdctl("endpoint health"); err != nil {
+			return fmt.Errorf("%s is unhealthy, not continuing: %s", member.Host, err)
+		}
+	}
+	return nil
+}
+
+func makeEtcdStatus() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "status",
+		Short:        "Show the size, leader and alarms of each etcd member",
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		members, closeAll, err := connectEtcdMembers(command)
+		if err != nil {
+			return err
+		}
+		defer closeAll()
+
+		printEtcdStatus(members)
+		return nil
+	}
+
+	return command
+}
+
+func printEtcdStatus(members []*etcdMember) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tVERSION\tDB SIZE\tIN USE\tREVISION\tLEADER\tALARMS")
+	for _, member := range members {
+		alarms := "none"
+		if len(member.Alarms) > 0 {
+			alarms = strings.Join(member.Alarms, " ")
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%t\t%s\n", member.Host, member.Status.Version,
+			formatBytes(member.Status.DBSize), formatBytes(member.Status.DBSizeInUse),
+			member.Status.Header.Revision, member.Status.isLeader(), alarms)
+	}
+	w.Flush()
+}
+
+func makeEtcdDefrag() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "defrag",
+		Short: "Defragment each etcd member in turn to reclaim disk space",
+		Long: `Defragment each etcd member in turn to reclaim disk space. A member
+blocks while it is defragmented, so every member must be healthy first,
+followers go before the leader and health is checked after each one.
+A NOSPACE alarm is cleared once every member is done.`,
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		members, closeAll, err := connectEtcdMembers(command)
+		if err != nil {
+			return err
+		}
+		defer closeAll()
+
+		if err := checkQuorum(members); err != nil {
+			return err
+		}
+
+		ordered := []*etcdMember{}
+		for _, member := range members {
+			if !member.Status.isLeader() {
+				ordered = append(ordered, member)
+			}
+		}
+		for _, member := range members {
+			if member.Status.isLeader() {
+				ordered = append(ordered, member)
+			}
+		}
+
+		for _, member := range ordered {
+			fmt.Printf("Defragmenting %s (%s)\n", member.Host, formatBytes(member.Status.DBSize))
+			if _, err := member.etcdctl("defrag --command-timeout 60s"); err != nil {
+				return err
+			}
+			if err := checkQuorum(members); err != nil {
+				return err
+			}
+		}
+
+		for _, member := range members {
+			if len(member.Alarms) > 0 {
+				fmt.Printf("Clearing alarms on %s\n", member.Host)
+				if _, err := member.etcdctl("alarm disarm"); err != nil {
+					return err
+				}
+			}
+			if err := member.refresh(); err != nil {
+				return err
+			}
+		}
+
+		printEtcdStatus(members)
+		return nil
+	}
+
+	return command
+}
+
+func makeEtcdCompact() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "compact",
+		Short: "Compact the etcd keyspace to its current revision",
+		Long: `Compact the etcd keyspace to its current revision, dropping the history
+of every key. The API server compacts on its own every few minutes, so
+this is only needed to free space before a defrag.`,
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		members, closeAll, err := connectEtcdMembers(command)
+		if err != nil {
+			return err
+		}
+		defer closeAll()
+
+		if err := checkQuorum(members); err != nil {
+			return err
+		}
+
+		// Compaction is replicated, so running it on one member is enough
+		member := members[0]
+		revision := member.Status.Header.Revision
+		fmt.Printf("Compacting to revision %d\n", revision)
+		if _, err := member.etcdctl(fmt.Sprintf("compact %d", revision)); err != nil {
+			return err
+		}
+
+		fmt.Println("Run k3sup etcd defrag to return the freed space to the filesystem")
+		return nil
+	}
+
+	return command
+}
+
+func formatBytes(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,210 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// exportPaths are the files on a node which hold its k3s configuration
+var exportPaths = []string{
+	"/etc/rancher/k3s",
+	"/var/lib/rancher/k3s/server/manifests",
+	"/var/lib/rancher/k3s/agent/etc/containerd/config.toml.tmpl",
+	"/etc/systemd/system/k3s.service",
+	"/etc/systemd/system/k3s-agent.service",
+}
+
+// exportSecretPaths hold credentials and are only exported on request
+var exportSecretPaths = []string{
+	"/var/lib/rancher/k3s/server/node-token",
+	"/etc/systemd/system/k3s.service.env",
+	"/etc/systemd/system/k3s-agent.service.env",
+}
+
+// exportSecretExcludes are secrets inside the directories of exportPaths
+var exportSecretExcludes = []string{
+	"etc/rancher/k3s/k3s.yaml",
+}
+
+func MakeExport() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "export",
+		Short: "Save the k3s configuration of each node to a tarball",
+		Long: `Save the k3s configuration of each node to a tarball, for disaster
+recovery documentation or to move a cluster to another operator machine.
+The tarball holds a directory per node with /etc/rancher/k3s, the
+auto-deploy manifests, the containerd template, the service units and the
+k3sup node cache, along with the k3sup-info ConfigMap. Credentials are
+left out unless --include-secrets is given.`,
+		Example:      `  k3sup export --ip 192.168.0.100,192.168.0.101 --output cluster.tgz`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the nodes to export, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the nodes to export, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().StringP("output", "o", "k3sup-export.tgz", "Path of the tarball to write")
+	command.Flags().Bool("include-secrets", false, "Include the admin kubeconfig, the node token and service environment files")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		hosts, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		output, _ := command.Flags().GetString("output")
+		includeSecrets, _ := command.Flags().GetBool("include-secrets")
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give at least one node with --ip or --host")
+		}
+
+		outputPath := expandPath(output)
+		file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %s", outputPath, err)
+		}
+		defer file.Close()
+
+		gz := gzip.NewWriter(file)
+		archive := tar.NewWriter(gz)
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		for _, host := range hosts {
+			fmt.Printf("Exporting %s\n", host)
+			if err := exportNode(archive, host, port, user, sshKeyPath, includeSecrets); err != nil {
+				return errors.Wrapf(err, "unable to export %s", host)
+			}
+		}
+
+		manifest, _ := json.MarshalIndent(map[string]interface{}{
+			"k3supVersion":   k3supVersion(),
+			"exportedAt":     time.Now().UTC().Format(time.RFC3339),
+			"hosts":          hosts,
+			"includeSecrets": includeSecrets,
+		}, "", "  ")
+		if err := addTarFile(archive, "k3sup-export.json", manifest); err != nil {
+			return err
+		}
+
+		if err := archive.Close(); err != nil {
+			return err
+		}
+		if err := gz.Close(); err != nil {
+			return err
+		}
+
+		fmt.Printf("Saved %s\n", outputPath)
+		if includeSecrets {
+			warn(msgExportSecrets, outputPath)
+		}
+		return nil
+	}
+
+	return command
+}
+
+// exportNode copies the configuration of one node into archive under a
+// directory named after its IP
+func exportNode(archive *tar.Writer, host string, port int, user, sshKeyPath string, includeSecrets bool) error {
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+	defer closeOperator()
+
+	paths := exportPaths
+	excludes := exportSecretExcludes
+	if includeSecrets {
+		paths = append(append([]string{}, exportPaths...), exportSecretPaths...)
+		excludes = nil
+	}
+
+	res, err := operator.ExecuteSilent(remoteTarCommand(paths, excludes))
+	if err != nil {
+		return fmt.Errorf("unable to read the node's configuration: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	if err := copyTar(archive, tar.NewReader(bytes.NewReader(res.StdOut)), host); err != nil {
+		return err
+	}
+
+	if res, err := operator.ExecuteSilent("cat " + nodeCachePath + " 2>/dev/null"); err == nil && len(res.StdOut) > 0 {
+		if err := addTarFile(archive, path.Join(host, "k3sup-cache.json"), res.StdOut); err != nil {
+			return err
+		}
+	}
+
+	// Only servers can read the ConfigMap, so agents are skipped quietly
+	getInfo := fmt.Sprintf("sudo k3s kubectl get configmap %s -n kube-system -o yaml 2>/dev/null", clusterInfoConfigMap)
+	if res, err := operator.ExecuteSilent(getInfo); err == nil && len(res.StdOut) > 0 {
+		if err := addTarFile(archive, path.Join(host, clusterInfoConfigMap+".yaml"), res.StdOut); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// remoteTarCommand archives whichever of paths exist, relative to /, so that
+// nodes without some of them, such as agents, don't fail the export
+func remoteTarCommand(paths, excludes []string) string {
+	exclude := ""
+	for _, e := range excludes {
+		exclude += fmt.Sprintf(" --exclude '%s'", e)
+	}
+
+	return fmt.Sprintf("cd / && sudo tar cf -%s $(for p in %s; do [ -e \"$p\" ] && echo \"${p#/}\"; done)",
+		exclude, strings.Join(paths, " "))
+}
+
+// copyTar copies every entry of from into to, below prefix
+func copyTar(to *tar.Writer, from *tar.Reader, prefix string) error {
+	for {
+		header, err := from.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("unable to read the node's archive: %s", err)
+		}
+
+		header.Name = path.Join(prefix, header.Name)
+		if err := to.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(to, from); err != nil {
+			return err
+		}
+	}
+}
+
+func addTarFile(archive *tar.Writer, name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0600,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := archive.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := archive.Write(data)
+	return err
+}
@@ -0,0 +1,146 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// k3sVersion is the numeric part of a k3s release such as v1.17.4+k3s1
+type k3sVersion [3]int
+
+func parseK3sVersion(version string) (k3sVersion, bool) {
+	v := k3sVersion{}
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "+-"); i >= 0 {
+		version = version[:i]
+	}
+
+	parts := strings.Split(version, ".")
+	if len(parts) != 3 {
+		return v, false
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func (v k3sVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+func (v k3sVersion) atLeast(other k3sVersion) bool {
+	for i := range v {
+		if v[i] != other[i] {
+			return v[i] > other[i]
+		}
+	}
+	return true
+}
+
+// renamedArg is a k3s server flag which was renamed in a release, the old
+// name keeps working for a while but the new one is unknown before it
+type renamedArg struct {
+	old, new string
+	since    k3sVersion
+}
+
+var renamedArgs = []renamedArg{
+	{old: "--no-deploy", new: "--disable", since: k3sVersion{1, 17, 0}},
+}
+
+// removedArg is a k3s flag which was removed in a release, k3s refuses to
+// start when it is given
+type removedArg struct {
+	name  string
+	since k3sVersion
+	hint  string
+}
+
+var removedArgs = []removedArg{
+	{name: "--docker", since: k3sVersion{1, 24, 0}, hint: "k3s only runs containerd now, or install cri-dockerd and give --container-runtime-endpoint"},
+}
+
+// translateExtraArgs rewrites flags in --k3s-extra-args to the names
+// understood by the k3s version being installed, so that a flag copied from
+// older or newer docs doesn't make k3s exit at startup. Flags removed by
+// that version are an error. Only the flag names are replaced, so quoted
+// values and spacing are kept as they were given. Custom commits and
+// binaries have no version to compare against, so they are left alone.
+func translateExtraArgs(extraArgs string, opts installOptions) (string, error) {
+	if len(opts.Commit) > 0 || len(opts.Binary) > 0 {
+		return extraArgs, nil
+	}
+
+	version, ok := parseK3sVersion(opts.Version)
+	if !ok {
+		return extraArgs, nil
+	}
+
+	translated := extraArgs
+	spans := flagSpans(extraArgs)
+
+	// Replaced from the end, so the earlier spans still line up
+	for i := len(spans) - 1; i >= 0; i-- {
+		start, end := spans[i][0], spans[i][1]
+		name := extraArgs[start:end]
+
+		for _, removed := range removedArgs {
+			if name == removed.name && version.atLeast(removed.since) {
+				return "", fmt.Errorf("%s in --k3s-extra-args was removed in k3s %s, so %s won't start with it: %s", name, removed.since, opts.Version, removed.hint)
+			}
+		}
+
+		for _, rename := range renamedArgs {
+			from, to := rename.old, rename.new
+			if !version.atLeast(rename.since) {
+				from, to = rename.new, rename.old
+			}
+			if name != from {
+				continue
+			}
+
+			warn(msgExtraArgs, from, opts.Version, to)
+			translated = translated[:start] + to + translated[end:]
+		}
+	}
+
+	if translated == extraArgs {
+		return extraArgs, nil
+	}
+	fmt.Printf("Using --k3s-extra-args '%s'\n", translated)
+	return translated, nil
+}
+
+// flagSpans finds where the name of each flag in args starts and ends,
+// skipping anything in quotes
+func flagSpans(args string) [][2]int {
+	spans := [][2]int{}
+	quote := byte(0)
+
+	for i := 0; i < len(args); i++ {
+		c := args[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '-' && (i == 0 || unicode.IsSpace(rune(args[i-1]))):
+			end := i
+			for end < len(args) && args[end] != '=' && !unicode.IsSpace(rune(args[end])) {
+				end++
+			}
+			spans = append(spans, [2]int{i, end})
+			i = end - 1
+		}
+	}
+	return spans
+}
@@ -0,0 +1,104 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func Test_translateExtraArgs(t *testing.T) {
+	cases := []struct {
+		name      string
+		extraArgs string
+		opts      installOptions
+		want      string
+	}{
+		{
+			name:      "no-deploy becomes disable on v1.17",
+			extraArgs: "--no-deploy traefik --no-deploy=servicelb",
+			opts:      installOptions{Version: "v1.17.4+k3s1"},
+			want:      "--disable traefik --disable=servicelb",
+		},
+		{
+			name:      "disable becomes no-deploy before v1.17",
+			extraArgs: "--disable traefik",
+			opts:      installOptions{Version: "v0.8.1"},
+			want:      "--no-deploy traefik",
+		},
+		{
+			name:      "supported flags are left alone",
+			extraArgs: "--no-deploy  traefik",
+			opts:      installOptions{Version: "v0.8.1"},
+			want:      "--no-deploy  traefik",
+		},
+		{
+			name:      "quoted values are kept",
+			extraArgs: `--node-label 'team=a  b' --no-deploy traefik --kubelet-arg="eviction-hard=memory.available<100Mi --no-deploy"`,
+			opts:      installOptions{Version: "v1.17.4+k3s1"},
+			want:      `--node-label 'team=a  b' --disable traefik --kubelet-arg="eviction-hard=memory.available<100Mi --no-deploy"`,
+		},
+		{
+			name:      "commits are left alone",
+			extraArgs: "--no-deploy traefik",
+			opts:      installOptions{Version: "v1.17.4+k3s1", Commit: "abc123"},
+			want:      "--no-deploy traefik",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := translateExtraArgs(c.extraArgs, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+}
+
+func Test_translateExtraArgs_RemovedFlag(t *testing.T) {
+	_, err := translateExtraArgs("--node-name=pi --docker", installOptions{Version: "v1.24.3+k3s1"})
+	if err == nil || !strings.Contains(err.Error(), "--docker in --k3s-extra-args was removed in k3s v1.24.0") {
+		t.Errorf("want an error naming the release --docker was removed in, got %v", err)
+	}
+
+	if _, err := translateExtraArgs("--docker", installOptions{Version: "v1.23.9+k3s1"}); err != nil {
+		t.Errorf("want --docker accepted before v1.24, got %v", err)
+	}
+}
+
+func Test_getDisableArgs(t *testing.T) {
+	cases := []struct {
+		name    string
+		disable []string
+		opts    installOptions
+		want    string
+	}{
+		{"disable on v1.17", []string{"traefik", "servicelb", "traefik"}, installOptions{Version: "v1.17.4+k3s1"}, "--disable traefik --disable servicelb"},
+		{"no-deploy before v1.17", []string{"traefik"}, installOptions{Version: "v0.8.1"}, "--no-deploy traefik"},
+		{"nothing to disable", nil, installOptions{Version: "v1.17.4+k3s1"}, ""},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			command := MakeInstall()
+			for _, component := range c.disable {
+				command.Flags().Set("disable", component)
+			}
+			got, err := getDisableArgs(command, c.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != c.want {
+				t.Errorf("want %q, got %q", c.want, got)
+			}
+		})
+	}
+
+	command := MakeInstall()
+	command.Flags().Set("disable", "coredns")
+	if _, err := getDisableArgs(command, installOptions{}); err == nil {
+		t.Error("want an error for a component k3sup doesn't know")
+	}
+}
@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// hostLockPath is a directory on the host, since mkdir is atomic on every
+// filesystem and shell. It holds an owner file naming who took it.
+var hostLockPath = "/tmp/k3sup.lock"
+
+const (
+	// staleHostLockMinutes is the age after which a host lock is assumed
+	// to have been left by a k3sup which was killed, long enough for an
+	// install which retries downloads and reboots
+	staleHostLockMinutes = 30
+)
+
+// lockHost stops two k3sup runs from changing a host at once, announcing
+// who holds the lock when another run already does. Release it with
+// unlockHost.
+func lockHost(operator kssh.Operator, action string) error {
+	owner := hostLockOwner(action, time.Now())
+	take := fmt.Sprintf("mkdir %s 2> /dev/null && cat > %s/owner", hostLockPath, hostLockPath)
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if _, err := operator.ExecuteWithStdin(take, strings.NewReader(owner)); err == nil {
+			return nil
+		}
+
+		stale := fmt.Sprintf("find %s -maxdepth 0 -mmin +%d", hostLockPath, staleHostLockMinutes)
+		if res, _ := operator.ExecuteSilent(stale); attempt == 0 && len(strings.TrimSpace(string(res.StdOut))) > 0 {
+			fmt.Printf("Removing stale lock %s on the host\n", hostLockPath)
+			operator.ExecuteSilent("rm -rf " + hostLockPath)
+			continue
+		}
+		break
+	}
+
+	res, _ := operator.ExecuteSilent("cat " + hostLockPath + "/owner")
+	holder := strings.TrimSpace(string(res.StdOut))
+	if len(holder) == 0 {
+		holder = "an unknown k3sup"
+	}
+	return fmt.Errorf("another k3sup is already working on this host, locked by %s. Try again once it finishes, or remove %s on the host if it was interrupted", holder, hostLockPath)
+}
+
+// unlockHost releases the lock taken by lockHost. operator is nil when the
+// host didn't come back from a reboot, which clears /tmp and the lock with
+// it on most hosts, otherwise the lock goes stale.
+func unlockHost(operator kssh.Operator) {
+	if operator == nil {
+		return
+	}
+	operator.ExecuteSilent("rm -rf " + hostLockPath)
+}
+
+// hostLockOwner describes this run for whoever finds the host locked
+func hostLockOwner(action string, now time.Time) string {
+	user := os.Getenv("USER")
+	if len(user) == 0 {
+		user = os.Getenv("USERNAME")
+	}
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s (k3sup %s, pid %d) since %s", user, hostname, action, os.Getpid(), now.UTC().Format(time.RFC3339))
+}
@@ -0,0 +1,42 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_lockHost(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-host-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(path string) { hostLockPath = path }(hostLockPath)
+	hostLockPath = filepath.Join(dir, "k3sup.lock")
+
+	operator := kssh.NewLocalOperator()
+	if err := lockHost(operator, "install"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = lockHost(operator, "join")
+	if err == nil || !strings.Contains(err.Error(), "k3sup install") {
+		t.Errorf("want an error naming the install which holds the lock, got %v", err)
+	}
+
+	unlockHost(operator)
+	if err := lockHost(operator, "join"); err != nil {
+		t.Errorf("want the lock once released, got %s", err)
+	}
+}
+
+func Test_unlockHost_AfterFailedReboot(t *testing.T) {
+	// handleReboot returns no operator when the host doesn't come back
+	unlockHost(nil)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// apiServerPort is where k3s serves the Kubernetes API
+const apiServerPort = "6443"
+
+// sanName is a DNS name which can go in a certificate, including a wildcard
+var sanName = regexp.MustCompile(`^(\*\.)?[a-zA-Z0-9]([-a-zA-Z0-9]*[a-zA-Z0-9])?(\.[a-zA-Z0-9]([-a-zA-Z0-9]*[a-zA-Z0-9])?)*$`)
+
+// getHost returns the address given with ipFlag, or the DNS name given with
+// hostFlag, and an empty string when neither is set. A name is resolved
+// here so that a typo is reported before anything is done, but the name
+// itself is returned, so that it is what the SSH dial, the TLS SAN and the
+// kubeconfig use. An IPv6 address is returned without brackets.
+func getHost(command *cobra.Command, ipFlag, hostFlag string) (string, error) {
+	host, _ := command.Flags().GetString(hostFlag)
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+
+	if command.Flags().Changed(ipFlag) {
+		if len(host) > 0 {
+			return "", fmt.Errorf("give only one of --%s or --%s", ipFlag, hostFlag)
+		}
+		ip, err := command.Flags().GetIP(ipFlag)
+		if err != nil {
+			return "", err
+		}
+		return ip.String(), nil
+	}
+
+	if len(host) == 0 {
+		return "", nil
+	}
+	return host, resolveHost(hostFlag, host)
+}
+
+// getSSHTarget returns the address given with flag to reach a node over
+// SSH, such as a private or bastion-reachable address, and host when it
+// isn't set, so that host is only what clients of the API server use
+func getSSHTarget(command *cobra.Command, flag, host string) (string, error) {
+	target, _ := command.Flags().GetString(flag)
+	target = strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
+	if len(target) == 0 {
+		return host, nil
+	}
+	return target, resolveHost(flag, target)
+}
+
+// getHosts returns the addresses given with ipFlag followed by the DNS names
+// given with hostFlag, both of which can be repeated
+func getHosts(command *cobra.Command, ipFlag, hostFlag string) ([]string, error) {
+	ips, _ := command.Flags().GetIPSlice(ipFlag)
+	names, _ := command.Flags().GetStringSlice(hostFlag)
+
+	hosts := []string{}
+	for _, ip := range ips {
+		hosts = append(hosts, ip.String())
+	}
+	for _, name := range names {
+		if err := resolveHost(hostFlag, name); err != nil {
+			return nil, err
+		}
+		hosts = append(hosts, name)
+	}
+	return hosts, nil
+}
+
+func resolveHost(flag, host string) error {
+	if _, err := net.LookupHost(host); err != nil {
+		return fmt.Errorf("unable to resolve --%s %s: %s", flag, host, err)
+	}
+	return nil
+}
+
+// serverURL is the address of the API server on host, with an IPv6 address
+// in brackets
+func serverURL(host string) string {
+	return "https://" + net.JoinHostPort(host, apiServerPort)
+}
+
+// loopbackServers are the addresses k3s writes into its own kubeconfig
+var loopbackServers = []string{"https://127.0.0.1:", "https://localhost:", "https://[::1]:"}
+
+// rewriteServer points a kubeconfig fetched from a server at host instead of
+// the loopback address
+func rewriteServer(kubeconfig []byte, host string) []byte {
+	address := "https://" + net.JoinHostPort(host, "")
+	pairs := []string{}
+	for _, loopback := range loopbackServers {
+		pairs = append(pairs, loopback, address)
+	}
+	return []byte(strings.NewReplacer(pairs...).Replace(string(kubeconfig)))
+}
+
+// tlsSANArgs returns a --tls-san flag for host followed by one for each of
+// sans, such as a load balancer or VIP, so that the server certificate is
+// valid for every address clients use
+func tlsSANArgs(host string, sans []string) (string, error) {
+	args := []string{"--tls-san " + host}
+	seen := map[string]bool{host: true}
+	for _, san := range sans {
+		san = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(san), "["), "]")
+		if net.ParseIP(san) == nil && !sanName.MatchString(san) {
+			return "", fmt.Errorf("--tls-san %q is neither an IP address nor a DNS name", san)
+		}
+		if !seen[san] {
+			seen[san] = true
+			args = append(args, "--tls-san "+san)
+		}
+	}
+	return strings.Join(args, " "), nil
+}
@@ -0,0 +1,36 @@
+package cmd
+
+import "testing"
+
+func Test_rewriteServer(t *testing.T) {
+	cases := []struct {
+		server, host, want string
+	}{
+		{"https://127.0.0.1:6443", "192.168.0.100", "https://192.168.0.100:6443"},
+		{"https://127.0.0.1:6443", "fd00::10", "https://[fd00::10]:6443"},
+		{"https://[::1]:6443", "fd00::10", "https://[fd00::10]:6443"},
+		{"https://localhost:6443", "k3s.example.com", "https://k3s.example.com:6443"},
+	}
+
+	for _, c := range cases {
+		got := string(rewriteServer([]byte("    server: "+c.server+"\n"), c.host))
+		if got != "    server: "+c.want+"\n" {
+			t.Errorf("%s on %s: want %s, got %q", c.server, c.host, c.want, got)
+		}
+	}
+}
+
+func Test_tlsSANArgs(t *testing.T) {
+	got, err := tlsSANArgs("192.168.0.100", []string{"k3s.example.com", "192.168.0.100", "[fd00::10]", "*.k3s.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "--tls-san 192.168.0.100 --tls-san k3s.example.com --tls-san fd00::10 --tls-san *.k3s.example.com"
+	if got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	if _, err := tlsSANArgs("192.168.0.100", []string{"lb' && reboot"}); err == nil {
+		t.Errorf("want an error for a SAN which isn't a name or address")
+	}
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -9,112 +10,428 @@ import (
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
-	config "github.com/alexellis/k3sup/pkg/config"
 	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/alexellis/k3sup/pkg/verify"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"golang.org/x/crypto/ssh"
 	"golang.org/x/crypto/ssh/agent"
-	"golang.org/x/crypto/ssh/terminal"
 )
 
 var kubeconfig []byte
 
+const (
+	// adminCertName and adminGroup identify the client certificate used
+	// for --kubeconfig-ttl, system:masters is bound to cluster-admin
+	adminCertName = "k3sup-admin"
+	adminGroup    = "system:masters"
+)
+
+var installSteps = []string{"connect", "install", "fetch", "verify"}
+
 func MakeInstall() *cobra.Command {
 	var command = &cobra.Command{
-		Use:          "install",
-		Short:        "Install k3s on a server via SSH",
-		Long:         `Install k3s on a server via SSH.`,
-		Example:      `  k3sup install --ip 192.168.0.100 --user root`,
+		Use:   "install",
+		Short: "Install k3s on a server via SSH",
+		Long:  `Install k3s on a server via SSH.`,
+		Example: `  k3sup install --ip 192.168.0.100 --user root
+  k3sup install --host k3s.example.com --user root`,
 		SilenceUsage: true,
 	}
 
 	command.Flags().IP("ip", nil, "Public IP of node")
+	command.Flags().String("host", "", "DNS name of node, instead of --ip")
+	command.Flags().Bool("local", false, "Install on this machine by running commands locally instead of over SSH, e.g. from cloud-init")
+	command.Flags().Bool("rootless", false, "Run k3s in rootless mode as the SSH user, from a systemd user service, without sudo")
+	command.Flags().String("ssh-target", "", "Address to connect to over SSH when it differs from --ip or --host, which the TLS SAN and kubeconfig use")
 	command.Flags().String("user", "root", "Username for SSH login")
 
 	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
 	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
 	command.Flags().Bool("skip-install", false, "Skip the k3s installer")
+	command.Flags().Bool("cluster", false, "Start the server with embedded etcd so that more servers can join it for an HA control plane")
+	addServerRoleFlag(command, "With --cluster, etcd runs only etcd on the server, for control-plane servers to be joined to, or all")
 	command.Flags().String("local-path", "kubeconfig", "Local path to save the kubeconfig file")
 	command.Flags().String("k3s-extra-args", "", "Optional extra arguments to pass to k3s installer, wrapped in quotes (e.g. --k3s-extra-args '--no-deploy servicelb')")
 	command.Flags().Bool("merge", false, "Merge the config with existing kubeconfig if it already exists.\nProvide the --local-path flag with --merge if a kubeconfig already exists in some other directory")
-	command.Flags().String("k3s-version", config.K3sVersion, "Optional version to install, pinned at a default")
+	command.Flags().Bool("switch-context", false, "With --merge, make the new cluster's context the current-context")
+	command.Flags().Bool("diff", false, "Show the clusters, contexts and users which the new kubeconfig changes and ask before writing it")
+	command.Flags().String("local-storage-path", "", "Directory on each node for volumes of the local-path StorageClass, e.g. /mnt/ssd/storage")
+	command.Flags().String("default-storage-class", "", "StorageClass to mark as the default instead of local-path")
+	command.Flags().Duration("kubeconfig-ttl", 0, "Save a kubeconfig with a client certificate which expires after this long, e.g. 24h, instead of the permanent admin credentials")
+	command.Flags().String("context", "default", "Name for the cluster, context and user in the kubeconfig")
+	command.Flags().String("context-namespace", "", "Default namespace for the context in the kubeconfig")
+	command.Flags().String("kubeconfig-dir", "", "Save the kubeconfig as <context>.yaml in this directory instead of using --local-path, e.g. ~/.kube/clusters")
+	command.Flags().Bool("kubeconfig-export", false, "With --kubeconfig-dir, keep a kubeconfig.sh in the directory which exports KUBECONFIG for every cluster in it")
+	command.Flags().StringSlice("tls-san", []string{}, "Extra DNS name or IP for the server certificate, such as a load balancer or VIP, can be repeated")
+	addInstallFlags(command)
+	addTokenFlags(command, "Token for the cluster instead of one generated by k3s, so that installs can be repeated and nodes joined with a known token")
+	command.Flags().String("token-out", "", "Save the token agents join with to this local file")
+	command.Flags().Bool("print-join", false, "Print the k3sup join command which adds an agent to this server")
+	addDatastoreFlags(command)
+	addPresetFlags(command)
+	addK3sConfigFlags(command)
+	addDisableFlag(command)
+	addClusterNetworkFlags(command)
+	addClusterIdentityFlags(command)
+	addRecordFlags(command)
+	command.Flags().StringArray("verify", []string{}, "Verifier to run after install, either a built-in ("+strings.Join(verify.Names(), ", ")+") or the path to an executable, can be repeated")
+	command.Flags().Duration("verify-timeout", time.Minute*2, "Time allowed for all verifiers to complete")
+	addTimeoutFlags(command, installSteps...)
 
 	command.RunE = func(command *cobra.Command, args []string) error {
 
+		if showPreset, _ := command.Flags().GetBool("show-preset"); showPreset {
+			preset, _ := command.Flags().GetString("preset")
+			if len(preset) > 0 {
+				return printPreset(preset)
+			}
+			for _, name := range presetNames() {
+				if err := printPreset(name); err != nil {
+					return err
+				}
+				fmt.Println()
+			}
+			return nil
+		}
+
+		presetManifests, err := applyPreset(command)
+		if err != nil {
+			return err
+		}
+
 		localKubeconfig, _ := command.Flags().GetString("local-path")
 
 		skipInstall, _ := command.Flags().GetBool("skip-install")
 
 		port, _ := command.Flags().GetInt("ssh-port")
 
-		ip, _ := command.Flags().GetIP("ip")
-		fmt.Println("Public IP: " + ip.String())
+		local, _ := command.Flags().GetBool("local")
+		if local && command.Flags().Changed("ssh-target") {
+			return fmt.Errorf("--ssh-target can't be used with --local")
+		}
+
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		// Without an address, the kubeconfig is for use on this machine
+		if local && len(host) == 0 {
+			host = "127.0.0.1"
+		}
+		fmt.Println("Host: " + host)
+
+		sshTarget, err := getSSHTarget(command, "ssh-target", host)
+		if err != nil {
+			return err
+		}
 
 		user, _ := command.Flags().GetString("user")
 		sshKey, _ := command.Flags().GetString("ssh-key")
 		merge, _ := command.Flags().GetBool("merge")
+		diff, _ := command.Flags().GetBool("diff")
+		switchContext, _ := command.Flags().GetBool("switch-context")
 		k3sExtraArgs, _ := command.Flags().GetString("k3s-extra-args")
+		cluster, _ := command.Flags().GetBool("cluster")
+		contextName, _ := command.Flags().GetString("context")
+		contextNamespace, _ := command.Flags().GetString("context-namespace")
+		kubeconfigDir, _ := command.Flags().GetString("kubeconfig-dir")
+		kubeconfigExport, _ := command.Flags().GetBool("kubeconfig-export")
+		kubeconfigTTL, _ := command.Flags().GetDuration("kubeconfig-ttl")
+		localStoragePath, _ := command.Flags().GetString("local-storage-path")
+		defaultStorageClass, _ := command.Flags().GetString("default-storage-class")
+		tokenOut, _ := command.Flags().GetString("token-out")
+		printJoin, _ := command.Flags().GetBool("print-join")
+		recordPath, _ := command.Flags().GetString("record")
+		recordInCluster, _ := command.Flags().GetBool("record-in-cluster")
+
+		if recordInCluster && len(recordPath) == 0 {
+			return fmt.Errorf("--record-in-cluster needs the local record given with --record")
+		}
+		token, err := getToken(command)
+		if err != nil {
+			return err
+		}
 
-		k3sVersion, _ := command.Flags().GetString("k3s-version")
+		tlsSANs, _ := command.Flags().GetStringSlice("tls-san")
+		sanArgs, err := tlsSANArgs(host, tlsSANs)
+		if err != nil {
+			return err
+		}
 
-		sshKeyPath := expandPath(sshKey)
-		fmt.Printf("ssh -i %s %s@%s\n", sshKeyPath, user, ip.String())
+		if len(contextNamespace) > 0 && !namespaceName.MatchString(contextNamespace) {
+			return fmt.Errorf("--context-namespace %q is not a valid namespace name", contextNamespace)
+		}
+
+		if len(kubeconfigDir) > 0 {
+			if merge {
+				return fmt.Errorf("--merge and --kubeconfig-dir can't be used together")
+			}
+			if !command.Flags().Changed("context") {
+				contextName = clusterContextName(host)
+			}
+		}
+
+		identity, err := getClusterIdentity(command, expandPath(recordPath))
+		if err != nil {
+			return err
+		}
+		if len(identity.Name) > 0 && !command.Flags().Changed("context") {
+			contextName = identity.Name
+		}
 
-		authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+		installOpts, err := getInstallOptions(command)
 		if err != nil {
-			return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+			return err
+		}
+		rootless, _ := command.Flags().GetBool("rootless")
+		if rootless {
+			if err := checkRootless(command, installOpts); err != nil {
+				return err
+			}
+		}
+		if k3sExtraArgs, err = translateExtraArgs(k3sExtraArgs, installOpts); err != nil {
+			return err
+		}
+		if local && installOpts.RebootIfRequired {
+			return fmt.Errorf("--reboot-if-required can't be used with --local, since k3sup would reboot the machine it runs on")
 		}
 
-		defer closeSSHAgent()
+		clusterArgs, err := clusterInitArgs(cluster, installOpts)
+		if err != nil {
+			return err
+		}
 
-		config := &ssh.ClientConfig{
-			User: user,
-			Auth: []ssh.AuthMethod{
-				authMethod,
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		networkArgs, err := getClusterNetworkArgs(command, host)
+		if err != nil {
+			return err
 		}
 
-		address := fmt.Sprintf("%s:%d", ip.String(), port)
-		operator, err := kssh.NewSSHOperator(address, config)
+		disableArgs, err := getDisableArgs(command, installOpts)
+		if err != nil {
+			return err
+		}
 
+		useK3sConfig, configSets, err := getK3sConfigSets(command, installOpts)
 		if err != nil {
-			return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+			return err
 		}
 
-		defer operator.Close()
+		serverRole, roleArgs, err := getServerRoleArgs(command, installOpts)
+		if err != nil {
+			return err
+		}
+		if err := checkEtcdServer(command, serverRole, cluster); err != nil {
+			return err
+		}
 
-		if !skipInstall {
-			installK3scommand := fmt.Sprintf("curl -sLS https://get.k3s.io | INSTALL_K3S_EXEC='server --tls-san %s %s' INSTALL_K3S_VERSION='%s' sh -\n", ip, strings.TrimSpace(k3sExtraArgs), k3sVersion)
+		datastore, err := getDatastoreOptions(command, installOpts)
+		if err != nil {
+			return err
+		}
+		if cluster && len(datastore.Endpoint) > 0 {
+			return fmt.Errorf("--cluster starts embedded etcd, so it can't be used with --datastore-endpoint")
+		}
+
+		verifySpecs, _ := command.Flags().GetStringArray("verify")
+		verifyTimeout, _ := command.Flags().GetDuration("verify-timeout")
 
-			fmt.Printf("ssh: %s\n", installK3scommand)
-			res, err := operator.Execute(installK3scommand)
+		timer, err := newStepTimer(command, installSteps...)
+		if err != nil {
+			return err
+		}
+		defer printWarnings(os.Stdout)
+		defer timer.printSummary()
+
+		sshKeyPath := expandPath(sshKey)
 
+		var operator kssh.Operator
+		closeOperator := func() {}
+
+		// The connection is replaced if the host is rebooted
+		defer func() { closeOperator() }()
+
+		if local {
+			operator = kssh.NewLocalOperator()
+		} else {
+			fmt.Printf("ssh -i %s %s@%s\n", sshKeyPath, user, sshTarget)
+			err = timer.run("connect", func(context.Context) error {
+				var err error
+				operator, closeOperator, err = connectOperator(sshTarget, port, user, sshKeyPath)
+				return err
+			})
 			if err != nil {
-				return fmt.Errorf("Error received processing command: %s", err)
+				return err
 			}
+		}
 
-			fmt.Printf("Result: %s %s\n", string(res.StdOut), string(res.StdErr))
+		// Rootless k3s runs as the user, so nothing needs root
+		escalation := ""
+		if !rootless {
+			if escalation, err = escalationPrefix(operator, installOpts.Sudo); err != nil {
+				return err
+			}
 		}
+		installOpts.Escalation = escalation
 
-		getConfigcommand := fmt.Sprintf("sudo cat /etc/rancher/k3s/k3s.yaml\n")
-		fmt.Printf("ssh: %s\n", getConfigcommand)
+		if !skipInstall {
+			if err := lockHost(operator, "install"); err != nil {
+				return err
+			}
+			// The operator is read when releasing, since a reboot replaces it
+			defer func() { unlockHost(operator) }()
+
+			serverArgs := strings.TrimSpace(strings.Join([]string{sanArgs, clusterArgs, networkArgs, roleArgs, datastore.args(), disableArgs, identity.nodeLabelArgs(), k3sExtraArgs, localStorageArgs(localStoragePath, installOpts)}, " "))
+			if useK3sConfig {
+				if installOpts.Config, err = renderK3sConfig(serverArgs, configSets); err != nil {
+					return err
+				}
+				serverArgs = ""
+			}
+
+			err = timer.run("install", func(ctx context.Context) error {
+				if rootless {
+					return installRootless(ctx, operator, installOpts, serverArgs, token)
+				}
+
+				if err := installOpts.prepare(ctx, operator); err != nil {
+					return err
+				}
+				if err := datastore.upload(ctx, operator, escalation); err != nil {
+					return err
+				}
+				if err := uploadK3sConfig(ctx, operator, installOpts.Config, escalation); err != nil {
+					return err
+				}
+				if err := uploadPresetManifests(ctx, operator, presetManifests, escalation); err != nil {
+					return err
+				}
+
+				installK3scommand := fmt.Sprintf("%s | %sINSTALL_K3S_EXEC='server %s' %s %s %s sh -\n", installOpts.scriptCommand(), installerPrefix(escalation), serverArgs, tokenEnvironment(token), datastore.env(), installOpts.env())
+
+				res, err := installOpts.install(ctx, operator, installK3scommand, "k3s")
+				if err != nil {
+					return err
+				}
+
+				fmt.Printf("Result: %s %s\n", string(res.StdOut), string(res.StdErr))
+
+				// Without an apiserver there is nothing to keep there yet
+				if serverRole == serverRoleEtcd {
+					fmt.Println(message(msgEtcdOnly, host))
+				} else {
+					if err := writeClusterInfo(operator, host, installOpts.describe(), identity.Name, escalation); err != nil {
+						warn(msgClusterInfo, err)
+					}
+
+					if err := configureLocalStorage(operator, localStoragePath, installOpts); err != nil {
+						warn(msgLocalPath, err)
+					}
+				}
+
+				if len(defaultStorageClass) > 0 {
+					if err := setDefaultStorageClass(operator, defaultStorageClass, escalation); err != nil {
+						warn(msgStorageClass, err)
+					}
+				}
+
+				operator, closeOperator, err = handleReboot(ctx, operator, closeOperator, sshTarget, port, user, sshKeyPath, "k3s", installOpts)
+				return err
+			})
+			if err != nil {
+				return err
+			}
 
-		res, err := operator.Execute(getConfigcommand)
+			if len(recordPath) > 0 {
+				record, err := updateRecord(expandPath(recordPath), sshKeyPath, recordNode{
+					Host:        host,
+					Role:        "server",
+					K3s:         installOpts.describe(),
+					Args:        serverArgs,
+					InstalledAt: time.Now().UTC(),
+					Artifacts:   readNodeCache(operator).Artifacts,
+				}, identity)
+				if err != nil {
+					return err
+				}
+				if recordInCluster {
+					if err := storeRecord(operator, record, escalation); err != nil {
+						return errors.Wrap(err, "unable to store the record in the cluster")
+					}
+				}
+			}
+		}
 
+		if len(tokenOut) > 0 {
+			nodeToken, err := fetchNodeToken(operator, escalation)
+			if err != nil {
+				return err
+			}
+			if err := writeConfig(expandPath(tokenOut), []byte(nodeToken+"\n"), true); err != nil {
+				return err
+			}
+			fmt.Printf("Saved the join token to %s\n", tokenOut)
+		}
+		if printJoin {
+			fmt.Printf("Join an agent with: %s\n", joinCommand(host, user, sshKey, tokenOut))
+		}
+
+		var res kssh.CommandRes
+		err = timer.run("fetch", func(ctx context.Context) error {
+			kubeconfigPath := "/etc/rancher/k3s/k3s.yaml"
+			if rootless {
+				kubeconfigPath = rootlessKubeconfigPath
+			}
+			fmt.Printf("Reading %s\n", kubeconfigPath)
+
+			kubeconfig := bytes.Buffer{}
+			if err := operator.Download(ctx, kubeconfigPath, &kubeconfig, escalation); err != nil {
+				return err
+			}
+			res.StdOut = kubeconfig.Bytes()
+
+			if kubeconfigTTL > 0 {
+				var err error
+				res.StdOut, err = newClientKubeconfig(operator, host, adminCertName, "default", []string{adminGroup}, kubeconfigTTL, escalation)
+				if err != nil {
+					return errors.Wrap(err, "unable to create a time-limited kubeconfig")
+				}
+				fmt.Println(message(msgKubeconfigExpires, time.Now().Add(kubeconfigTTL).Format(time.RFC3339)))
+			}
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("Error received processing command: %s", err)
+			return err
 		}
 
 		fmt.Printf("Result: %s %s\n", string(res.StdOut), string(res.StdErr))
 
 		absPath, _ := filepath.Abs(localKubeconfig)
 
-		kubeconfig := []byte(strings.NewReplacer("localhost", ip.String(), "127.0.0.1", ip.String()).Replace(string(res.StdOut)))
+		kubeconfig := rewriteServer(res.StdOut, host)
+		clusterKubeconfig := kubeconfig
+		kubeconfig = setContextNamespace(kubeconfig, contextNamespace)
+
+		if len(kubeconfigDir) > 0 {
+			if err := writeClusterKubeconfig(expandPath(kubeconfigDir), contextName, kubeconfig, kubeconfigExport); err != nil {
+				return err
+			}
+			return runVerifiers(timer, verifySpecs, verifyTimeout, clusterKubeconfig, operator, escalation)
+		}
+
+		kubeconfig = renameContext(kubeconfig, contextName)
+
+		// Hold the lock from reading the existing kubeconfig until the merged
+		// one is written, so parallel runs don't drop each other's clusters
+		unlock, err := lockFile(absPath, lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
 
 		if merge {
 			// Create a merged kubeconfig
@@ -124,18 +441,43 @@ func MakeInstall() *cobra.Command {
 			}
 		}
 
+		if diff {
+			ok, err := confirmKubeconfigChanges(absPath, kubeconfig, os.Stdout)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				fmt.Printf("Not saving the kubeconfig to %s\n", absPath)
+				unlock()
+				return runVerifiers(timer, verifySpecs, verifyTimeout, clusterKubeconfig, operator, escalation)
+			}
+		}
+
 		// Create a new kubeconfig
 		if writeErr := writeConfig(absPath, []byte(kubeconfig), false); writeErr != nil {
 			return writeErr
 		}
 
-		return nil
+		if merge {
+			if switchContext {
+				if err := useContext(absPath, contextName); err != nil {
+					return err
+				}
+				fmt.Printf("Switched to context %s\n", contextName)
+			} else {
+				fmt.Printf("Switch to the new cluster with: kubectl config use-context %s --kubeconfig %s\n", contextName, absPath)
+			}
+		}
+		unlock()
+
+		return runVerifiers(timer, verifySpecs, verifyTimeout, clusterKubeconfig, operator, escalation)
 	}
 
 	command.PreRunE = func(command *cobra.Command, args []string) error {
-		_, ipErr := command.Flags().GetIP("ip")
-		if ipErr != nil {
-			return ipErr
+		local, _ := command.Flags().GetBool("local")
+		showPreset, _ := command.Flags().GetBool("show-preset")
+		if !local && !showPreset && !command.Flags().Changed("ip") && !command.Flags().Changed("host") {
+			return fmt.Errorf("give the node to install k3s on with --ip or --host, or use --local")
 		}
 
 		_, sshPortErr := command.Flags().GetInt("ssh-port")
@@ -147,17 +489,45 @@ func MakeInstall() *cobra.Command {
 	return command
 }
 
-// Generates config files give the path to file: string and the data: []byte
+func runVerifiers(timer *stepTimer, specs []string, timeout time.Duration, kubeconfig []byte, operator kssh.Operator, escalation string) error {
+	if len(specs) == 0 {
+		return nil
+	}
+
+	return timer.run("verify", func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		defer cancel()
+
+		return verify.RunAll(ctx, verify.Resolve(specs), kubeconfig, operator, escalation)
+	})
+}
+
+// Generates config files give the path to file: string and the data: []byte.
+// The file is written next to path and renamed into place, so readers never
+// see a partially written file.
 func writeConfig(path string, data []byte, suppressMessage bool) error {
 	absPath, _ := filepath.Abs(path)
 	if !suppressMessage {
-		fmt.Printf("Saving file to: %s\n", absPath)
+		fmt.Println(message(msgKubeconfigSaved, absPath))
+	}
+
+	file, err := ioutil.TempFile(filepath.Dir(absPath), "."+filepath.Base(absPath)+"-*")
+	if err != nil {
+		return err
 	}
-	writeErr := ioutil.WriteFile(absPath, []byte(data), 0600)
-	if writeErr != nil {
-		return writeErr
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
 	}
-	return nil
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(file.Name(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), absPath)
 }
 
 func mergeConfigs(localKubeconfigPath string, k3sconfig []byte) ([]byte, error) {
@@ -172,7 +542,7 @@ func mergeConfigs(localKubeconfigPath string, k3sconfig []byte) ([]byte, error)
 		return nil, writeErr
 	}
 
-	fmt.Printf("Merging with existing kubeconfig at %s\n", localKubeconfigPath)
+	fmt.Println(message(msgKubeconfigMerge, localKubeconfigPath))
 
 	// Append KUBECONFIGS in ENV Vars
 	appendKubeConfigENV := fmt.Sprintf("KUBECONFIG=%s:%s", localKubeconfigPath, file.Name())
@@ -194,6 +564,16 @@ func mergeConfigs(localKubeconfigPath string, k3sconfig []byte) ([]byte, error)
 	return data, nil
 }
 
+// useContext sets the current-context of the kubeconfig at path, which
+// kubectl config view --merge leaves as that of the existing kubeconfig
+func useContext(path, name string) error {
+	cmd := exec.Command("kubectl", "config", "use-context", name, "--kubeconfig", path)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Could not switch to context %s: %s %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
 func expandPath(path string) string {
 	res, _ := homedir.Expand(path)
 	return res
@@ -249,11 +629,14 @@ func loadPublickey(path string) (ssh.AuthMethod, func() error, error) {
 
 		defer close()
 
-		fmt.Printf("Enter passphrase for '%s': ", path)
-		bytePassword, err := terminal.ReadPassword(int(os.Stdin.Fd()))
-		fmt.Println()
+		// Without a terminal the empty passphrase is tried, so that the
+		// error is about the key
+		passphrase, err := prompter.Secret(fmt.Sprintf("Enter passphrase for '%s'", path))
+		if err != nil && err != errNoTerminal {
+			return nil, noopCloseFunc, errors.Wrap(err, "unable to ask for the passphrase")
+		}
 
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, bytePassword)
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
 		if err != nil {
 			return nil, noopCloseFunc, err
 		}
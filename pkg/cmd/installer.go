@@ -0,0 +1,564 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	config "github.com/alexellis/k3sup/pkg/config"
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+const (
+	k3sBinaryPath    = "/usr/local/bin/k3s"
+	installScriptURL = "https://get.k3s.io"
+
+	// airgapImagesDir is where k3s imports image tarballs from when it
+	// starts, so that it doesn't need to pull its own images
+	airgapImagesDir = "/var/lib/rancher/k3s/agent/images"
+
+	// installMirrorEnv picks the download mirror of the install script,
+	// such as cn for the mirror in China, and is read locally as the
+	// default of --install-mirror
+	installMirrorEnv = "INSTALL_K3S_MIRROR"
+)
+
+var mirrorName = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// downloadCommand writes the contents of url to stdout using curl, or
+// wget on hosts such as Alpine and busybox images where curl is missing.
+// Only flags which busybox wget understands are used.
+func downloadCommand(url string) string {
+	return fmt.Sprintf("if command -v curl > /dev/null 2>&1; then curl -sfLS '%s'; else wget -q -O - '%s'; fi", url, url)
+}
+
+// installOptions controls which k3s the install script sets up and how the
+// script is run
+type installOptions struct {
+	Version string
+	Commit  string
+	Binary  string
+	Retries int
+
+	AirgapImages string
+
+	// Config is the config.yaml k3s is installed with, when the server's
+	// flags are written there rather than passed to the installer
+	Config string
+
+	// ChecksumVersion is the release whose published checksum the k3s
+	// binary is verified against, or empty to skip the check
+	ChecksumVersion string
+
+	InstallURL string
+	Mirror     string
+
+	// Script is the install script when it is fetched by k3sup and
+	// streamed to the host, rather than downloaded there
+	Script []byte
+
+	// IgnoreCache runs the installer even when the node cache shows the
+	// same install was already done
+	IgnoreCache bool
+
+	CABundle     string
+	CARegistries []string
+
+	RebootIfRequired bool
+
+	Sudo sudoOptions
+
+	// Escalation is the prefix escalationPrefix found for the host, which
+	// commands needing root are run with
+	Escalation string
+
+	Limits serviceLimits
+}
+
+// addInstallFlags registers the flags shared by every command which runs
+// the k3s installer
+func addInstallFlags(command *cobra.Command) {
+	command.Flags().String("k3s-version", config.K3sVersion, "Optional version to install, pinned at a default")
+	command.Flags().Bool("skip-release-check", false, "Don't check with GitHub that --k3s-version is a k3s release, for installs without internet access")
+	command.Flags().String("k3s-channel", "", "Install the release a k3s channel points at, such as stable, latest or v1.19, instead of --k3s-version")
+	command.Flags().String("k3s-commit", "", "Install a pre-release build of k3s from this commit SHA instead of a version")
+	command.Flags().String("k3s-binary", "", "Path to a k3s binary to upload and install instead of downloading one, checked against the release --k3s-version or --k3s-channel names")
+	command.Flags().String("install-url", installScriptURL, "URL of the k3s install script, for a mirror or a copy on an internal server")
+	command.Flags().Bool("local-install-script", false, "Download the install script where k3sup runs and stream it to the host over SSH, for hosts which can't reach --install-url")
+	command.Flags().String("install-mirror", os.Getenv(installMirrorEnv), "Mirror for the install script to download k3s from, such as cn, passed as $"+installMirrorEnv)
+	command.Flags().Bool("skip-checksum", false, "Don't verify the k3s binary against the checksum published for --k3s-version")
+	command.Flags().String("airgap-images", "", "Path to a k3s airgap images tarball, such as k3s-airgap-images-amd64.tar, to upload so that k3s doesn't pull its images")
+	command.Flags().Int("install-retries", 2, "Number of times to re-run the k3s installer when a download fails")
+	command.Flags().Bool("ignore-node-cache", false, "Run the k3s installer even when the node records that the same install was already done")
+	command.Flags().String("ca-bundle", "", "PEM file with a CA to add to the host's trust store, for hosts behind a TLS-intercepting proxy")
+	command.Flags().StringSlice("ca-registry", []string{}, "Registry to configure in registries.yaml to trust --ca-bundle, can be repeated")
+	command.Flags().Bool("reboot-if-required", false, "Reboot the host after installing when it reports that a reboot is required")
+	command.Flags().String("sudo", sudoAuto, "Run commands which need root with sudo: auto when the user isn't root, always or never")
+	command.Flags().String("escalation", escalationAuto, "Tool to run commands as root with: auto finds sudo or doas, or name one of them")
+	command.Flags().String("sudo-password-file", "", "File holding the sudo password, for users who need one, also read from $"+sudoPasswordEnv)
+	command.Flags().String("service-cpu-quota", "", "Limit the k3s service's CPU time as a percentage of one CPU, e.g. 150%")
+	command.Flags().String("service-memory-max", "", "Limit the k3s service's memory, e.g. 1G, beyond which it is reclaimed or killed")
+	command.Flags().Int("service-nice", 0, "Nice level for the k3s service from -20 to 19, e.g. 10 to favour other workloads")
+}
+
+func getInstallOptions(command *cobra.Command) (installOptions, error) {
+	opts := installOptions{}
+	opts.Version, _ = command.Flags().GetString("k3s-version")
+	opts.Commit, _ = command.Flags().GetString("k3s-commit")
+	opts.Binary, _ = command.Flags().GetString("k3s-binary")
+	opts.Retries, _ = command.Flags().GetInt("install-retries")
+	opts.AirgapImages, _ = command.Flags().GetString("airgap-images")
+	opts.InstallURL, _ = command.Flags().GetString("install-url")
+	opts.Mirror, _ = command.Flags().GetString("install-mirror")
+	opts.IgnoreCache, _ = command.Flags().GetBool("ignore-node-cache")
+	opts.CABundle, _ = command.Flags().GetString("ca-bundle")
+	opts.CARegistries, _ = command.Flags().GetStringSlice("ca-registry")
+	opts.RebootIfRequired, _ = command.Flags().GetBool("reboot-if-required")
+	opts.Sudo.Mode, _ = command.Flags().GetString("sudo")
+	opts.Sudo.Tool, _ = command.Flags().GetString("escalation")
+	opts.Sudo.PasswordFile, _ = command.Flags().GetString("sudo-password-file")
+
+	// --k3s-version or --k3s-channel may name the release of a --k3s-binary
+	// so that it can be checked against the published checksum
+	sources := 0
+	for _, name := range []string{"k3s-version", "k3s-channel", "k3s-commit"} {
+		if command.Flags().Changed(name) {
+			sources++
+		}
+	}
+	if sources > 1 {
+		return opts, fmt.Errorf("give only one of --k3s-version, --k3s-channel or --k3s-commit")
+	}
+	if command.Flags().Changed("k3s-binary") && command.Flags().Changed("k3s-commit") {
+		return opts, fmt.Errorf("--k3s-binary can't be given with --k3s-commit, use --k3s-version or --k3s-channel to name its release")
+	}
+
+	skipReleaseCheck, _ := command.Flags().GetBool("skip-release-check")
+	if command.Flags().Changed("k3s-version") && !skipReleaseCheck {
+		if err := checkK3sRelease(opts.Version); err != nil {
+			return opts, err
+		}
+	}
+
+	channel, _ := command.Flags().GetString("k3s-channel")
+	if len(channel) > 0 {
+		version, err := resolveK3sChannel(channel)
+		if err != nil {
+			return opts, err
+		}
+		fmt.Println(message(msgChannel, channel, version))
+		opts.Version = version
+	}
+
+	// Commits have no published checksums, and a --k3s-binary is only
+	// checked when it is said to be a release
+	skipChecksum, _ := command.Flags().GetBool("skip-checksum")
+	switch {
+	case skipChecksum || len(opts.Commit) > 0:
+	case len(opts.Binary) > 0:
+		if command.Flags().Changed("k3s-version") || len(channel) > 0 {
+			opts.ChecksumVersion = opts.Version
+		}
+	default:
+		opts.ChecksumVersion = opts.Version
+	}
+
+	if u, err := url.Parse(opts.InstallURL); err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 || strings.Contains(opts.InstallURL, "'") {
+		return opts, fmt.Errorf("--install-url %q should be an http or https URL", opts.InstallURL)
+	}
+	if len(opts.Mirror) > 0 && !mirrorName.MatchString(opts.Mirror) {
+		return opts, fmt.Errorf("--install-mirror %q should be the name of a mirror, such as cn", opts.Mirror)
+	}
+	if localScript, _ := command.Flags().GetBool("local-install-script"); localScript {
+		script, err := fetchInstallScript(opts.InstallURL)
+		if err != nil {
+			return opts, err
+		}
+		opts.Script = script
+	}
+
+	if opts.Sudo.Mode != sudoAuto && opts.Sudo.Mode != sudoAlways && opts.Sudo.Mode != sudoNever {
+		return opts, fmt.Errorf("unknown --sudo %q, use auto, always or never", opts.Sudo.Mode)
+	}
+	if opts.Sudo.Tool != escalationAuto && opts.Sudo.Tool != escalationSudo && opts.Sudo.Tool != escalationDoas {
+		return opts, fmt.Errorf("unknown --escalation %q, use auto, sudo or doas", opts.Sudo.Tool)
+	}
+	if len(opts.Sudo.PasswordFile) > 0 {
+		opts.Sudo.PasswordFile = expandPath(opts.Sudo.PasswordFile)
+	}
+
+	limits, err := getServiceLimits(command)
+	if err != nil {
+		return opts, err
+	}
+	opts.Limits = limits
+
+	if len(opts.CARegistries) > 0 && len(opts.CABundle) == 0 {
+		return opts, fmt.Errorf("--ca-registry needs a --ca-bundle")
+	}
+	if len(opts.CABundle) > 0 {
+		opts.CABundle = expandPath(opts.CABundle)
+	}
+
+	if len(opts.AirgapImages) > 0 {
+		opts.AirgapImages = expandPath(opts.AirgapImages)
+		if !airgapImagesFile(opts.AirgapImages) {
+			return opts, fmt.Errorf("--airgap-images should be a tarball ending in .tar, .tar.gz, .tar.bz2, .tar.zst or .tar.lz4, as k3s only imports those")
+		}
+		if _, err := os.Stat(opts.AirgapImages); err != nil {
+			return opts, fmt.Errorf("unable to read --airgap-images: %s", err)
+		}
+	}
+
+	if len(opts.Binary) > 0 {
+		opts.Binary = expandPath(opts.Binary)
+		if _, err := os.Stat(opts.Binary); err != nil {
+			return opts, fmt.Errorf("unable to read --k3s-binary: %s", err)
+		}
+	}
+
+	return opts, nil
+}
+
+// airgapImagesPath is where the --airgap-images tarball is uploaded to
+func (o installOptions) airgapImagesPath() string {
+	return path.Join(airgapImagesDir, filepath.Base(o.AirgapImages))
+}
+
+// airgapImagesFile reports whether k3s imports a tarball with this name
+func airgapImagesFile(name string) bool {
+	for _, suffix := range []string{".tar", ".tar.gz", ".tar.bz2", ".tar.zst", ".tar.lz4"} {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// scriptCommand returns the command which writes the install script to
+// stdout on the host, to be piped into sh
+func (o installOptions) scriptCommand() string {
+	if o.Script != nil {
+		return "cat"
+	}
+	return downloadCommand(o.InstallURL)
+}
+
+// fetchInstallScript downloads the install script where k3sup runs
+func fetchInstallScript(url string) ([]byte, error) {
+	client := &http.Client{Timeout: time.Second * 30}
+	res, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download the install script: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to download the install script from %s: %s", url, res.Status)
+	}
+	script, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to download the install script: %s", err)
+	}
+	if !bytes.HasPrefix(script, []byte("#!")) {
+		return nil, fmt.Errorf("%s is not a shell script", url)
+	}
+	fmt.Println(message(msgInstallScript, url, len(script)))
+	return script, nil
+}
+
+// env returns the variables which tell the install script what to install
+// and where to download it from
+func (o installOptions) env() string {
+	env := ""
+	switch {
+	case len(o.Binary) > 0:
+		return "INSTALL_K3S_SKIP_DOWNLOAD='true'"
+	case len(o.Commit) > 0:
+		env = fmt.Sprintf("INSTALL_K3S_COMMIT='%s'", o.Commit)
+	default:
+		env = fmt.Sprintf("INSTALL_K3S_VERSION='%s'", o.Version)
+	}
+
+	if len(o.Mirror) > 0 {
+		env += fmt.Sprintf(" %s='%s'", installMirrorEnv, o.Mirror)
+	}
+	return env
+}
+
+// describe names what is being installed, for messages and metadata
+func (o installOptions) describe() string {
+	switch {
+	case len(o.Binary) > 0:
+		return "custom binary"
+	case len(o.Commit) > 0:
+		return "commit " + o.Commit
+	default:
+		return o.Version
+	}
+}
+
+// prepare checks that the host can download the installer, and uploads a
+// custom k3s binary ahead of the installer, which then only has to set up
+// the service around it. A CA bundle is trusted first, since the download
+// may go through the proxy which needs it.
+func (o installOptions) prepare(ctx context.Context, operator kssh.Operator) error {
+	if len(o.CABundle) > 0 {
+		if err := installCABundle(ctx, operator, o.CABundle, o.CARegistries, o.Escalation); err != nil {
+			return err
+		}
+	}
+
+	// Nothing is downloaded on the host when k3sup sends the script and
+	// the binary
+	if o.Script == nil || len(o.Binary) == 0 {
+		res, _ := operator.ExecuteSilent("command -v curl || command -v wget")
+		if len(strings.TrimSpace(string(res.StdOut))) == 0 {
+			return fmt.Errorf("neither curl nor wget is installed on the host, one of them is needed to run the k3s installer")
+		}
+	}
+
+	if len(o.AirgapImages) > 0 {
+		images, err := os.Open(o.AirgapImages)
+		if err != nil {
+			return err
+		}
+		defer images.Close()
+
+		imagesPath := o.airgapImagesPath()
+		fmt.Println(message(msgUploading, o.AirgapImages, imagesPath))
+		if _, err := uploadIfChanged(ctx, operator, images, imagesPath, "0644", o.Escalation); err != nil {
+			return err
+		}
+	}
+
+	if len(o.Binary) == 0 {
+		return nil
+	}
+
+	if err := o.verifyK3sBinary(operator); err != nil {
+		return err
+	}
+
+	binary, err := os.Open(o.Binary)
+	if err != nil {
+		return err
+	}
+	defer binary.Close()
+
+	fmt.Println(message(msgUploading, o.Binary, k3sBinaryPath))
+	_, err = uploadIfChanged(ctx, operator, binary, k3sBinaryPath, "0755", o.Escalation)
+	return err
+}
+
+// install runs the installer for service unless the node cache records an
+// identical install and the service is still running
+func (o installOptions) install(ctx context.Context, operator kssh.Operator, command, service string) (kssh.CommandRes, error) {
+	limitsChanged, err := applyServiceLimits(ctx, operator, service, o.Limits, o.Escalation)
+	if err != nil {
+		return kssh.CommandRes{}, err
+	}
+
+	cache := readNodeCache(operator)
+
+	binarySum := ""
+	if len(o.Binary) > 0 {
+		binarySum = cache.Artifacts[k3sBinaryPath]
+	}
+	uploadSums := []string{binarySum}
+	if len(o.AirgapImages) > 0 {
+		uploadSums = append(uploadSums, cache.Artifacts[o.airgapImagesPath()])
+	}
+	if len(o.Config) > 0 {
+		uploadSums = append(uploadSums, cache.Artifacts[k3sConfigPath])
+	}
+	fingerprint := installFingerprint(command, uploadSums...)
+
+	if !o.IgnoreCache && cache.Installs[service] == fingerprint {
+		if serviceState(operator, service) == "active" {
+			fmt.Println(message(msgInstallSkipped, service))
+			if limitsChanged {
+				return kssh.CommandRes{}, restartForLimits(operator, service, o.Escalation)
+			}
+			return kssh.CommandRes{}, nil
+		}
+	}
+
+	res, err := runInstaller(ctx, operator, command, service, o.Retries, o.Script, o.Escalation)
+	if err != nil {
+		return res, err
+	}
+	if len(o.Binary) == 0 {
+		if err := o.verifyK3sBinary(operator); err != nil {
+			return res, err
+		}
+	}
+
+	updateNodeCache(operator, func(cache *nodeCache) { cache.Installs[service] = fingerprint })
+	return res, nil
+}
+
+type installFailure int
+
+const (
+	installOK installFailure = iota
+	installFailed
+	installDownloadFailed
+	installServiceFailed
+)
+
+// downloadFailures are output signatures of curl, wget and the k3s install
+// script which show that fetching the script or the k3s binary failed
+var downloadFailures = []string{
+	"curl: (",
+	"wget: bad address",
+	"wget: server returned error",
+	"wget: download timed out",
+	"wget: can't connect to remote host",
+	"wget: unable to resolve host address",
+	"Download failed",
+	"Download sha256 does not match",
+	"Failed to download",
+}
+
+// serviceFailures are output signatures of systemd and openrc which show
+// that k3s was installed but did not start
+var serviceFailures = []string{
+	"Job for k3s",
+	"k3s.service failed",
+	"k3s-agent.service failed",
+	"ERROR: k3s failed to start",
+}
+
+// classifyInstall decides how the installer failed from its error and
+// output. The install script is piped into sh, so a failed download can
+// still exit zero and must be detected from the output.
+func classifyInstall(res kssh.CommandRes, err error) installFailure {
+	output := string(res.StdOut) + string(res.StdErr)
+
+	for _, signature := range downloadFailures {
+		if strings.Contains(output, signature) {
+			return installDownloadFailed
+		}
+	}
+
+	if err == nil {
+		return installOK
+	}
+
+	for _, signature := range serviceFailures {
+		if strings.Contains(output, signature) {
+			return installServiceFailed
+		}
+	}
+
+	return installFailed
+}
+
+// runInstaller runs the k3s installer, running it again up to retries
+// times when a download fails. This is safe because the installer is
+// idempotent. Once the installer exits, the service is checked so that a
+// k3s which never started is reported along with its journal. A script
+// fetched by k3sup is streamed to the command's stdin.
+func runInstaller(ctx context.Context, operator kssh.Operator, command, service string, retries int, script []byte, escalation string) (kssh.CommandRes, error) {
+	attempts := retries + 1
+
+	for attempt := 1; ; attempt++ {
+		fmt.Printf("ssh: %s\n", command)
+		var stdin io.Reader
+		if script != nil {
+			stdin = bytes.NewReader(script)
+		}
+		res, err := operator.ExecuteContext(ctx, command, stdin, true)
+		if ctx.Err() != nil {
+			return res, ctx.Err()
+		}
+
+		switch classifyInstall(res, err) {
+		case installOK:
+			return res, waitForService(ctx, operator, service, serviceStartTimeout, escalation)
+		case installDownloadFailed:
+			if attempt < attempts {
+				wait := time.Second * 5 * time.Duration(attempt)
+				fmt.Println(message(msgDownloadRetry, wait, attempt, attempts))
+				select {
+				case <-ctx.Done():
+					return res, ctx.Err()
+				case <-time.After(wait):
+				}
+				continue
+			}
+			return res, fmt.Errorf("k3s installer could not download k3s after %d attempt(s): %s", attempts, lastLines(res.StdErr, 5))
+		case installServiceFailed:
+			return res, fmt.Errorf("k3s was installed, but the %s service failed to start, last %d lines of its journal:\n%s", service, journalLines, serviceJournal(operator, service, escalation))
+		default:
+			return res, fmt.Errorf("Error received processing command: %s", err)
+		}
+	}
+}
+
+const (
+	serviceStartTimeout = time.Second * 30
+	journalLines        = 100
+)
+
+// waitForService polls the state of a systemd unit until it is active.
+// Hosts without systemd, such as those using openrc, are not checked.
+func waitForService(ctx context.Context, operator kssh.Operator, service string, timeout time.Duration, escalation string) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		state := serviceState(operator, service)
+
+		if state == "active" || state == "unknown" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the installer completed, but the %s service is %q, last %d lines of its journal:\n%s", service, state, journalLines, serviceJournal(operator, service, escalation))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second * 2):
+		}
+	}
+}
+
+// serviceState returns the state systemd reports for a unit, or "unknown"
+// on hosts without systemd
+func serviceState(operator kssh.Operator, service string) string {
+	command := fmt.Sprintf("if command -v systemctl > /dev/null 2>&1; then systemctl is-active %s; else echo unknown; fi", service)
+	res, _ := operator.ExecuteSilent(command)
+	return strings.TrimSpace(string(res.StdOut))
+}
+
+// serviceJournal fetches the tail of the journal for a systemd unit
+func serviceJournal(operator kssh.Operator, service, escalation string) string {
+	res, err := operator.ExecuteSilent(fmt.Sprintf("%sjournalctl -u %s -n %d --no-pager", escalation, service, journalLines))
+	if err != nil {
+		return fmt.Sprintf("(unable to read journal: %s %s)", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return strings.TrimSpace(string(res.StdOut))
+}
+
+// lastLines returns at most n trailing lines of output
+func lastLines(output []byte, n int) string {
+	lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
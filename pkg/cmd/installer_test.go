@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_classifyInstall(t *testing.T) {
+	cases := []struct {
+		name   string
+		stderr string
+		err    error
+		want   installFailure
+	}{
+		{"success", "[INFO]  systemd: Starting k3s\n", nil, installOK},
+		{"script download failed with zero exit", "curl: (6) Could not resolve host: get.k3s.io\n", nil, installDownloadFailed},
+		{"binary download failed", "[ERROR]  Download failed\n", errors.New("Process exited with status 1"), installDownloadFailed},
+		{"wget download failed", "wget: server returned error: HTTP/1.1 404 Not Found\n", errors.New("Process exited with status 1"), installDownloadFailed},
+		{"wget notice", "wget: note: TLS certificate validation not implemented\n[INFO]  systemd: Starting k3s\n", nil, installOK},
+		{"service failed", "Job for k3s.service failed because the control process exited with error code.\n", errors.New("Process exited with status 1"), installServiceFailed},
+		{"unknown failure", "sh: 1: bad\n", errors.New("Process exited with status 2"), installFailed},
+	}
+
+	for _, c := range cases {
+		got := classifyInstall(kssh.CommandRes{StdErr: []byte(c.stderr)}, c.err)
+		if got != c.want {
+			t.Errorf("%s: want %d, got %d", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_installOptions_envMirror(t *testing.T) {
+	opts := installOptions{Version: "v1.19.5+k3s2", Mirror: "cn"}
+	want := "INSTALL_K3S_VERSION='v1.19.5+k3s2' INSTALL_K3S_MIRROR='cn'"
+	if got := opts.env(); got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	opts.Mirror = ""
+	want = "INSTALL_K3S_VERSION='v1.19.5+k3s2'"
+	if got := opts.env(); got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
+
+func Test_installOptions_airgapImagesPath(t *testing.T) {
+	opts := installOptions{AirgapImages: "/home/alex/Downloads/k3s-airgap-images-arm64.tar"}
+	want := "/var/lib/rancher/k3s/agent/images/k3s-airgap-images-arm64.tar"
+	if got := opts.airgapImagesPath(); got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+
+	if airgapImagesFile("images.zip") {
+		t.Errorf("want a zip file to be refused, k3s doesn't import it")
+	}
+}
+
+func Test_install_WithoutSudo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-prepare")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	binary, ca := filepath.Join(dir, "k3s"), filepath.Join(dir, "ca.crt")
+	ioutil.WriteFile(binary, []byte("k3s"), 0755)
+	ioutil.WriteFile(ca, []byte("-----BEGIN CERTIFICATE-----\n"), 0644)
+
+	cases := []struct {
+		name       string
+		escalation string
+	}{
+		{"root", ""},
+		{"doas", "doas "},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			operator := kssh.NewFakeOperator(func(command string, stdin []byte) (kssh.CommandRes, error) {
+				switch {
+				case command == "command -v curl || command -v wget":
+					return kssh.CommandRes{StdOut: []byte("/usr/bin/curl\n")}, nil
+				case command == "command -v systemctl":
+					return kssh.CommandRes{StdOut: []byte("/usr/bin/systemctl\n")}, nil
+				case strings.Contains(command, "systemctl is-active"):
+					return kssh.CommandRes{StdOut: []byte("active\n")}, nil
+				}
+				return kssh.CommandRes{}, nil
+			})
+
+			opts := installOptions{
+				Binary:       binary,
+				CABundle:     ca,
+				CARegistries: []string{"registry.local"},
+				Limits:       serviceLimits{Nice: "5"},
+				Escalation:   tc.escalation,
+			}
+			if err := opts.prepare(context.Background(), operator); err != nil {
+				t.Fatal(err)
+			}
+			installer := fmt.Sprintf("%s | %sK3S_TOKEN='token' %s sh -", opts.scriptCommand(), installerPrefix(tc.escalation), opts.env())
+			if _, err := opts.install(context.Background(), operator, installer, "k3s"); err != nil {
+				t.Fatal(err)
+			}
+			if err := restartForLimits(operator, "k3s", opts.Escalation); err != nil {
+				t.Fatal(err)
+			}
+			serviceJournal(operator, "k3s", opts.Escalation)
+
+			escalated := 0
+			for _, command := range operator.Commands() {
+				if strings.Contains(command, "sudo") {
+					t.Errorf("want no sudo, got %q", command)
+				}
+				if len(tc.escalation) > 0 && strings.Contains(command, tc.escalation) {
+					escalated++
+				}
+			}
+			if len(tc.escalation) > 0 && escalated == 0 {
+				t.Errorf("want commands run with %q", tc.escalation)
+			}
+		})
+	}
+}
@@ -1,44 +1,76 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
-	"net"
+	"os"
 	"strings"
+	"time"
 
-	config "github.com/alexellis/k3sup/pkg/config"
 	kssh "github.com/alexellis/k3sup/pkg/ssh"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"golang.org/x/crypto/ssh"
 )
 
+var joinSteps = []string{"connect", "fetch", "install"}
+
 func MakeJoin() *cobra.Command {
 	var command = &cobra.Command{
-		Use:          "join",
-		Short:        "Install the k3s agent on a remote host and join it to an existing server",
-		Long:         `Install the k3s agent on a remote host and join it to an existing server`,
-		Example:      `  k3sup join --user root --server-ip 192.168.0.100 --ip 192.168.0.101`,
+		Use:   "join",
+		Short: "Install the k3s agent on a remote host and join it to an existing server",
+		Long: `Install the k3s agent on a remote host and join it to an existing server.
+With --server the host joins as another server instead, for an HA control
+plane started with k3sup install --cluster.`,
+		Example: `  k3sup join --user root --server-ip 192.168.0.100 --ip 192.168.0.101
+  k3sup join --server --server-ip 192.168.0.100 --ip 192.168.0.102 --k3s-version v1.19.1+k3s1`,
 		SilenceUsage: true,
 	}
 
 	command.Flags().IP("server-ip", nil, "Public IP of existing k3s server")
 	command.Flags().IP("ip", nil, "Public IP of node on which to install agent")
+	command.Flags().String("server-host", "", "DNS name of existing k3s server, instead of --server-ip")
+	command.Flags().String("host", "", "DNS name of node on which to install agent, instead of --ip")
+	command.Flags().String("ssh-target", "", "Address to connect to the node over SSH when it differs from --ip or --host")
+	command.Flags().String("server-ssh-target", "", "Address to connect to the server over SSH when it differs from --server-ip or --server-host, which the agent uses to join")
 
 	command.Flags().String("user", "root", "Username for SSH login")
 
 	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
 	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
 	command.Flags().Bool("skip-install", false, "Skip the k3s installer")
+	command.Flags().Bool("server", false, "Join as a server of an HA control plane, the existing server must have been installed with --cluster")
+	addServerRoleFlag(command, "With --server, run only etcd or only the control plane on the server, or all")
+	command.Flags().StringSlice("tls-san", []string{}, "With --server, extra DNS name or IP for the server certificate, such as a load balancer or VIP, can be repeated")
 	command.Flags().String("k3s-extra-args", "", "Optional extra arguments to pass to k3s installer, wrapped in quotes (e.g. --k3s-extra-args '--node-taint key=value:NoExecute')")
-	command.Flags().String("k3s-version", config.K3sVersion, "Optional version to install, pinned at a default")
+	addClusterNetworkFlags(command)
+	addClusterIdentityFlags(command)
+	addInstallFlags(command)
+	addTokenFlags(command, "Token to join with instead of reading the node-token from the server over SSH")
+	addRecordFlags(command)
+	addTimeoutFlags(command, joinSteps...)
 
 	command.RunE = func(command *cobra.Command, args []string) error {
 
-		ip, _ := command.Flags().GetIP("ip")
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
 
-		serverIP, _ := command.Flags().GetIP("server-ip")
+		serverHost, err := getHost(command, "server-ip", "server-host")
+		if err != nil {
+			return err
+		}
 
-		fmt.Println("Server IP: " + serverIP.String())
+		fmt.Println("Server: " + serverHost)
+
+		sshTarget, err := getSSHTarget(command, "ssh-target", host)
+		if err != nil {
+			return err
+		}
+		serverSSHTarget, err := getSSHTarget(command, "server-ssh-target", serverHost)
+		if err != nil {
+			return err
+		}
 
 		user, _ := command.Flags().GetString("user")
 		sshKey, _ := command.Flags().GetString("ssh-key")
@@ -46,65 +78,171 @@ func MakeJoin() *cobra.Command {
 		port, _ := command.Flags().GetInt("ssh-port")
 
 		k3sExtraArgs, _ := command.Flags().GetString("k3s-extra-args")
+		server, _ := command.Flags().GetBool("server")
+		recordPath, _ := command.Flags().GetString("record")
+		recordInCluster, _ := command.Flags().GetBool("record-in-cluster")
 
-		k3sVersion, _ := command.Flags().GetString("k3s-version")
+		if recordInCluster && len(recordPath) == 0 {
+			return fmt.Errorf("--record-in-cluster needs the local record given with --record")
+		}
 
-		sshKeyPath := expandPath(sshKey)
-		fmt.Printf("ssh -i %s %s@%s\n", sshKeyPath, user, serverIP.String())
+		identity, err := getClusterIdentity(command, expandPath(recordPath))
+		if err != nil {
+			return err
+		}
+		k3sExtraArgs = strings.TrimSpace(identity.nodeLabelArgs() + " " + k3sExtraArgs)
 
-		authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+		installOpts, err := getInstallOptions(command)
 		if err != nil {
-			return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+			return err
+		}
+		if k3sExtraArgs, err = translateExtraArgs(k3sExtraArgs, installOpts); err != nil {
+			return err
 		}
 
-		defer closeSSHAgent()
+		joinToken, err := getToken(command)
+		if err != nil {
+			return err
+		}
 
-		config := &ssh.ClientConfig{
-			User: user,
-			Auth: []ssh.AuthMethod{
-				authMethod,
-			},
-			HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		tlsSANs, _ := command.Flags().GetStringSlice("tls-san")
+		if len(tlsSANs) > 0 && !server {
+			return fmt.Errorf("--tls-san is only used when joining with --server")
+		}
+		sanArgs, err := tlsSANArgs(host, tlsSANs)
+		if err != nil {
+			return err
 		}
 
-		address := fmt.Sprintf("%s:%d", serverIP.String(), port)
-		operator, err := kssh.NewSSHOperator(address, config)
+		serverRole, roleArgs, err := getServerRoleArgs(command, installOpts)
+		if err != nil {
+			return err
+		}
+		if serverRole != serverRoleAll && !server {
+			return fmt.Errorf("--server-role is only used when joining with --server")
+		}
 
+		// Every server has to be given the networks the cluster was
+		// installed with
+		networkArgs, err := getClusterNetworkArgs(command, host)
 		if err != nil {
-			return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+			return err
 		}
+		if len(networkArgs) > 0 && !server {
+			return fmt.Errorf("--cluster-cidr, --service-cidr and --cluster-dns are only used when joining with --server")
+		}
+		serverArgs := strings.TrimSpace(strings.Join([]string{sanArgs, networkArgs, roleArgs}, " "))
 
-		defer operator.Close()
+		if server && !supportsEmbeddedEtcd(installOpts) {
+			return fmt.Errorf("--server needs k3s %s or newer for embedded etcd, choose one with --k3s-version", embeddedEtcdSince)
+		}
 
-		getTokenCommand := fmt.Sprintf("sudo cat /var/lib/rancher/k3s/server/node-token\n")
-		fmt.Printf("ssh: %s\n", getTokenCommand)
+		timer, err := newStepTimer(command, joinSteps...)
+		if err != nil {
+			return err
+		}
+		defer printWarnings(os.Stdout)
+		defer timer.printSummary()
 
-		res, err := operator.Execute(getTokenCommand)
+		sshKeyPath := expandPath(sshKey)
 
-		if err != nil {
-			return errors.Wrap(err, "unable to get join-token from server")
+		var operator kssh.Operator
+		closeOperator := func() {}
+		defer func() { closeOperator() }()
+
+		// escalation is the server's, the node finds its own in joinNode
+		escalation := ""
+
+		// With --token the server is only needed to keep the record there
+		if len(joinToken) == 0 || recordInCluster {
+			fmt.Printf("ssh -i %s %s@%s\n", sshKeyPath, user, serverSSHTarget)
+			err = timer.run("connect", func(context.Context) error {
+				var err error
+				operator, closeOperator, err = connectOperator(serverSSHTarget, port, user, sshKeyPath)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+
+			if escalation, err = escalationPrefix(operator, installOpts.Sudo); err != nil {
+				return errors.Wrapf(err, "unable to run commands as root on %s", serverHost)
+			}
 		}
 
-		if len(res.StdErr) > 0 {
-			fmt.Printf("Logs: %s", res.StdErr)
+		if len(joinToken) == 0 {
+			err = timer.run("fetch", func(context.Context) error {
+				fmt.Printf("ssh: %ssh %s\n", escalation, nodeTokenScript.fileName())
+
+				res, err := runScript(operator, nodeTokenScript, escalation)
+
+				if err != nil {
+					return errors.Wrap(err, "unable to get join-token from server")
+				}
+
+				if len(res.StdErr) > 0 {
+					fmt.Printf("Logs: %s", res.StdErr)
+				}
+
+				joinToken = strings.TrimSpace(string(res.StdOut))
+				if len(joinToken) == 0 {
+					return fmt.Errorf("%s has no node-token, is k3s installed there as a server?", serverHost)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
 		}
 
-		joinToken := string(res.StdOut)
+		var artifacts map[string]string
+		err = timer.run("install", func(ctx context.Context) error {
+			var err error
+			artifacts, err = joinNode(ctx, serverHost, sshTarget, port, user, sshKeyPath, joinToken, k3sExtraArgs, serverArgs, installOpts, server)
+			return err
+		})
+		if err != nil {
+			return err
+		}
 
-		setupAgent(serverIP, ip, port, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion)
+		role, recordArgs := "agent", k3sExtraArgs
+		if server {
+			role, recordArgs = "server", strings.TrimSpace(serverArgs+" "+k3sExtraArgs)
+		}
+		if operator != nil {
+			if err := recordClusterNode(operator, host, role, escalation); err != nil {
+				warn(msgClusterInfo, err)
+			}
+		}
+
+		if len(recordPath) > 0 {
+			record, err := updateRecord(expandPath(recordPath), sshKeyPath, recordNode{
+				Host:        host,
+				Role:        role,
+				K3s:         installOpts.describe(),
+				Args:        recordArgs,
+				InstalledAt: time.Now().UTC(),
+				Artifacts:   artifacts,
+			}, identity)
+			if err != nil {
+				return err
+			}
+			if recordInCluster {
+				if err := storeRecord(operator, record, escalation); err != nil {
+					return errors.Wrap(err, "unable to store the record in the cluster")
+				}
+			}
+		}
 
 		return nil
 	}
 
 	command.PreRunE = func(command *cobra.Command, args []string) error {
-		_, ipErr := command.Flags().GetIP("ip")
-		if ipErr != nil {
-			return ipErr
+		if !command.Flags().Changed("ip") && !command.Flags().Changed("host") {
+			return fmt.Errorf("give the agent to join with --ip or --host")
 		}
-
-		_, ipErr = command.Flags().GetIP("server-ip")
-		if ipErr != nil {
-			return ipErr
+		if !command.Flags().Changed("server-ip") && !command.Flags().Changed("server-host") {
+			return fmt.Errorf("give the server to join with --server-ip or --server-host")
 		}
 
 		_, sshPortErr := command.Flags().GetInt("ssh-port")
@@ -117,39 +255,43 @@ func MakeJoin() *cobra.Command {
 	return command
 }
 
-func setupAgent(serverIP, ip net.IP, port int, user, sshKeyPath, joinToken, k3sExtraArgs, k3sVersion string) error {
+// joinNode installs k3s on the node reached over SSH at sshTarget and joins
+// it to serverHost, as an agent or as another server of an HA control plane,
+// and returns the checksums of the files k3sup uploaded to it
+func joinNode(ctx context.Context, serverHost, sshTarget string, port int, user, sshKeyPath, joinToken, k3sExtraArgs, serverArgs string, installOpts installOptions, server bool) (map[string]string, error) {
 
-	authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+	operator, closeOperator, err := connectOperator(sshTarget, port, user, sshKeyPath)
 	if err != nil {
-		return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+		return nil, err
 	}
 
-	defer closeSSHAgent()
+	// The connection is replaced if the host is rebooted
+	defer func() { closeOperator() }()
 
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			authMethod,
-		},
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	if err := lockHost(operator, "join"); err != nil {
+		return nil, err
 	}
+	defer func() { unlockHost(operator) }()
 
-	address := fmt.Sprintf("%s:%d", ip.String(), port)
-	operator, err := kssh.NewSSHOperator(address, config)
-
+	escalation, err := escalationPrefix(operator, installOpts.Sudo)
 	if err != nil {
-		return errors.Wrapf(err, "unable to connect to %s over ssh", address)
+		return nil, err
+	}
+	installOpts.Escalation = escalation
+	if err := installOpts.prepare(ctx, operator); err != nil {
+		return nil, err
 	}
 
-	defer operator.Close()
-
-	getTokenCommand := fmt.Sprintf("curl -sfL https://get.k3s.io/ | K3S_URL='https://%s:6443' K3S_TOKEN='%s' INSTALL_K3S_VERSION='%s' sh -s - %s", serverIP.String(), strings.TrimSpace(joinToken), k3sVersion, k3sExtraArgs)
-	fmt.Printf("ssh: %s\n", getTokenCommand)
-
-	res, err := operator.Execute(getTokenCommand)
+	service := "k3s-agent"
+	getTokenCommand := fmt.Sprintf("%s | %sK3S_URL='%s' K3S_TOKEN='%s' %s sh -s - %s", installOpts.scriptCommand(), installerPrefix(escalation), serverURL(serverHost), strings.TrimSpace(joinToken), installOpts.env(), k3sExtraArgs)
+	if server {
+		service = "k3s"
+		getTokenCommand = fmt.Sprintf("%s | %sK3S_TOKEN='%s' %s sh -s - server --server '%s' %s %s", installOpts.scriptCommand(), installerPrefix(escalation), strings.TrimSpace(joinToken), installOpts.env(), serverURL(serverHost), serverArgs, k3sExtraArgs)
+	}
 
+	res, err := installOpts.install(ctx, operator, getTokenCommand, service)
 	if err != nil {
-		return errors.Wrap(err, "unable to setup agent")
+		return nil, errors.Wrapf(err, "unable to setup %s", service)
 	}
 
 	if len(res.StdErr) > 0 {
@@ -159,5 +301,10 @@ func setupAgent(serverIP, ip net.IP, port int, user, sshKeyPath, joinToken, k3sE
 	joinRes := string(res.StdOut)
 	fmt.Printf("Output: %s", string(joinRes))
 
-	return nil
+	operator, closeOperator, err = handleReboot(ctx, operator, closeOperator, sshTarget, port, user, sshKeyPath, service, installOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	return readNodeCache(operator).Artifacts, nil
 }
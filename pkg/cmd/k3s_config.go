@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// k3sConfigPath is where k3s reads its flags from before its command line
+const k3sConfigPath = "/etc/rancher/k3s/config.yaml"
+
+// configFileSince is the first k3s release which reads k3sConfigPath
+var configFileSince = k3sVersion{1, 19, 1}
+
+var configKey = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// k3sListOptions are the k3s server flags which may be given more than once,
+// they are always written as lists. Any flag ending in -arg is one too.
+var k3sListOptions = map[string]bool{
+	"tls-san":    true,
+	"disable":    true,
+	"no-deploy":  true,
+	"node-label": true,
+	"node-taint": true,
+}
+
+func addK3sConfigFlags(command *cobra.Command) {
+	command.Flags().Bool("k3s-config", false, "Write the server's flags to "+k3sConfigPath+" instead of passing them to the installer, needs k3s "+configFileSince.String()+" or newer")
+	command.Flags().StringArray("k3s-config-set", nil, "Set a k3s server option in "+k3sConfigPath+" as key=value, can be repeated and implies --k3s-config (e.g. --k3s-config-set kubelet-arg=max-pods=200)")
+}
+
+// getK3sConfigSets returns the options of --k3s-config-set, and whether the
+// server's flags go to config.yaml at all
+func getK3sConfigSets(command *cobra.Command, opts installOptions) (bool, []string, error) {
+	useConfig, _ := command.Flags().GetBool("k3s-config")
+	sets, _ := command.Flags().GetStringArray("k3s-config-set")
+	if !useConfig && len(sets) == 0 {
+		return false, nil, nil
+	}
+
+	for _, set := range sets {
+		eq := strings.Index(set, "=")
+		if eq < 0 || !configKey.MatchString(set[:eq]) {
+			return false, nil, fmt.Errorf("--k3s-config-set %q should be an option and its value, such as write-kubeconfig-mode=0644", set)
+		}
+	}
+
+	version, parsed := parseK3sVersion(opts.Version)
+	if parsed && len(opts.Commit) == 0 && len(opts.Binary) == 0 && !version.atLeast(configFileSince) {
+		return false, nil, fmt.Errorf("k3s reads %s from %s, choose a newer release with --k3s-version", k3sConfigPath, configFileSince)
+	}
+	return true, sets, nil
+}
+
+// k3sConfigOption is one key of config.yaml, with no values for a flag
+// which is only switched on
+type k3sConfigOption struct {
+	Key    string
+	Values []string
+}
+
+// parseServerArgs splits k3s server flags such as those of --k3s-extra-args
+// into options, in the order they were first given
+func parseServerArgs(args string) ([]k3sConfigOption, error) {
+	options := []k3sConfigOption{}
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if !strings.HasPrefix(field, "-") {
+			return nil, fmt.Errorf("unable to write %q to %s, it doesn't follow a flag", field, k3sConfigPath)
+		}
+
+		key, values := strings.TrimLeft(field, "-"), []string(nil)
+		if eq := strings.Index(key, "="); eq >= 0 {
+			key, values = key[:eq], []string{unquote(key[eq+1:])}
+		} else if i+1 < len(fields) && !strings.HasPrefix(fields[i+1], "-") {
+			values = []string{unquote(fields[i+1])}
+			i++
+		}
+		options = addConfigOption(options, key, values)
+	}
+	return options, nil
+}
+
+// addConfigOption appends to the values of a list option, and replaces the
+// value of any other as the last flag on a command line would
+func addConfigOption(options []k3sConfigOption, key string, values []string) []k3sConfigOption {
+	for i, option := range options {
+		if option.Key != key {
+			continue
+		}
+		if isListOption(key) {
+			options[i].Values = append(options[i].Values, values...)
+		} else {
+			options[i].Values = values
+		}
+		return options
+	}
+	return append(options, k3sConfigOption{Key: key, Values: values})
+}
+
+func isListOption(key string) bool {
+	return k3sListOptions[key] || strings.HasSuffix(key, "-arg")
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && (value[0] == '\'' || value[0] == '"') && value[len(value)-1] == value[0] {
+		return value[1 : len(value)-1]
+	}
+	return value
+}
+
+// renderK3sConfig writes the server's flags and the options of
+// --k3s-config-set as config.yaml. Values are quoted, so that YAML doesn't
+// read a mode such as 0644 as a number.
+func renderK3sConfig(serverArgs string, sets []string) (string, error) {
+	options, err := parseServerArgs(serverArgs)
+	if err != nil {
+		return "", err
+	}
+	for _, set := range sets {
+		eq := strings.Index(set, "=")
+		options = addConfigOption(options, set[:eq], []string{set[eq+1:]})
+	}
+
+	config := "# Managed by k3sup\n"
+	for _, option := range options {
+		switch {
+		case len(option.Values) == 0:
+			config += fmt.Sprintf("%s: true\n", option.Key)
+		case isListOption(option.Key):
+			config += option.Key + ":\n"
+			for _, value := range option.Values {
+				config += fmt.Sprintf("  - %s\n", strconv.Quote(value))
+			}
+		case option.Values[0] == "true" || option.Values[0] == "false":
+			config += fmt.Sprintf("%s: %s\n", option.Key, option.Values[0])
+		default:
+			config += fmt.Sprintf("%s: %s\n", option.Key, strconv.Quote(option.Values[0]))
+		}
+	}
+	return config, nil
+}
+
+// uploadK3sConfig writes config.yaml ahead of the installer, which starts
+// k3s with it
+func uploadK3sConfig(ctx context.Context, operator kssh.Operator, config, escalation string) error {
+	if len(config) == 0 {
+		return nil
+	}
+	fmt.Printf("Writing the server's flags to %s\n", k3sConfigPath)
+	_, err := uploadIfChanged(ctx, operator, strings.NewReader(config), k3sConfigPath, "0600", escalation)
+	return err
+}
@@ -0,0 +1,38 @@
+package cmd
+
+import "testing"
+
+func Test_renderK3sConfig(t *testing.T) {
+	args := "--tls-san 192.168.0.100 --tls-san k3s.example.com --cluster-init --disable traefik --kubelet-arg=max-pods=200 --node-label 'zone=a' --write-kubeconfig-mode 0600"
+	sets := []string{"write-kubeconfig-mode=0644", "disable=servicelb", "secrets-encryption=true"}
+
+	got, err := renderK3sConfig(args, sets)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# Managed by k3sup
+tls-san:
+  - "192.168.0.100"
+  - "k3s.example.com"
+cluster-init: true
+disable:
+  - "traefik"
+  - "servicelb"
+kubelet-arg:
+  - "max-pods=200"
+node-label:
+  - "zone=a"
+write-kubeconfig-mode: "0644"
+secrets-encryption: true
+`
+	if got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_renderK3sConfig_StrayValue(t *testing.T) {
+	if _, err := renderK3sConfig("traefik --cluster-init", nil); err == nil {
+		t.Error("want an error for a value which doesn't follow a flag")
+	}
+}
@@ -0,0 +1,209 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+
+	"github.com/morikuni/aec"
+)
+
+// kubeconfigView is the part of kubectl config view -o json which is
+// compared, credentials are compared but never printed
+type kubeconfigView struct {
+	CurrentContext string `json:"current-context"`
+	Clusters       []struct {
+		Name    string `json:"name"`
+		Cluster struct {
+			Server string `json:"server"`
+		} `json:"cluster"`
+	} `json:"clusters"`
+	Contexts []struct {
+		Name    string `json:"name"`
+		Context struct {
+			Cluster string `json:"cluster"`
+			User    string `json:"user"`
+		} `json:"context"`
+	} `json:"contexts"`
+	Users []struct {
+		Name string                 `json:"name"`
+		User map[string]interface{} `json:"user"`
+	} `json:"users"`
+}
+
+// kubeconfigChange is one cluster, context or user which is added, removed
+// or modified
+type kubeconfigChange struct {
+	Action string
+	Kind   string
+	Name   string
+	Detail string
+}
+
+const (
+	changeAdded    = "+"
+	changeRemoved  = "-"
+	changeModified = "~"
+)
+
+// confirmKubeconfigChanges prints what writing data to path would change
+// and asks whether to go ahead. Nothing is asked when nothing changes.
+func confirmKubeconfigChanges(path string, data []byte, out io.Writer) (bool, error) {
+	before := kubeconfigView{}
+	if _, err := os.Stat(path); err == nil {
+		current, err := ioutil.ReadFile(path)
+		if err != nil {
+			return false, err
+		}
+		if before, err = viewKubeconfig(current); err != nil {
+			return false, err
+		}
+	}
+
+	after, err := viewKubeconfig(data)
+	if err != nil {
+		return false, err
+	}
+
+	changes := diffKubeconfigs(before, after)
+	if len(changes) == 0 {
+		fmt.Fprintf(out, "No changes to %s\n", path)
+		return true, nil
+	}
+
+	fmt.Fprintf(out, "Changes to %s:\n", path)
+	for _, change := range changes {
+		line := fmt.Sprintf("  %s %s", change.Action, change.Kind)
+		if len(change.Name) > 0 {
+			line += " " + change.Name
+		}
+		if len(change.Detail) > 0 {
+			line += ": " + change.Detail
+		}
+		fmt.Fprintln(out, colorChange(change.Action, line))
+	}
+
+	return prompter.Confirm("Write these changes?")
+}
+
+func colorChange(action, line string) string {
+	if !colorOutput() {
+		return line
+	}
+
+	switch action {
+	case changeAdded:
+		return aec.GreenF.Apply(line)
+	case changeRemoved:
+		return aec.RedF.Apply(line)
+	}
+	return aec.YellowF.Apply(line)
+}
+
+// viewKubeconfig has kubectl parse a kubeconfig, since there is no YAML
+// parser in k3sup
+func viewKubeconfig(data []byte) (kubeconfigView, error) {
+	view := kubeconfigView{}
+
+	file, err := ioutil.TempFile(os.TempDir(), "k3s-diff-*")
+	if err != nil {
+		return view, err
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return view, err
+	}
+	file.Close()
+
+	cmd := exec.Command("kubectl", "config", "view", "--raw", "-o", "json", "--kubeconfig", file.Name())
+	out, err := cmd.Output()
+	if err != nil {
+		return view, fmt.Errorf("Could not read kubeconfig: %s", err)
+	}
+
+	if err := json.Unmarshal(out, &view); err != nil {
+		return view, fmt.Errorf("Could not parse kubeconfig: %s", err)
+	}
+	return view, nil
+}
+
+// diffKubeconfigs lists the clusters, contexts and users which differ, in
+// the order they appear
+func diffKubeconfigs(before, after kubeconfigView) []kubeconfigChange {
+	changes := []kubeconfigChange{}
+
+	beforeClusters := map[string]string{}
+	for _, cluster := range before.Clusters {
+		beforeClusters[cluster.Name] = cluster.Cluster.Server
+	}
+	afterClusters := map[string]string{}
+	for _, cluster := range after.Clusters {
+		afterClusters[cluster.Name] = cluster.Cluster.Server
+		server, ok := beforeClusters[cluster.Name]
+		switch {
+		case !ok:
+			changes = append(changes, kubeconfigChange{changeAdded, "cluster", cluster.Name, cluster.Cluster.Server})
+		case server != cluster.Cluster.Server:
+			changes = append(changes, kubeconfigChange{changeModified, "cluster", cluster.Name, server + " -> " + cluster.Cluster.Server})
+		}
+	}
+	for _, cluster := range before.Clusters {
+		if _, ok := afterClusters[cluster.Name]; !ok {
+			changes = append(changes, kubeconfigChange{changeRemoved, "cluster", cluster.Name, cluster.Cluster.Server})
+		}
+	}
+
+	beforeContexts := map[string]string{}
+	for _, context := range before.Contexts {
+		beforeContexts[context.Name] = context.Context.Cluster + "/" + context.Context.User
+	}
+	afterContexts := map[string]string{}
+	for _, context := range after.Contexts {
+		target := context.Context.Cluster + "/" + context.Context.User
+		afterContexts[context.Name] = target
+		previous, ok := beforeContexts[context.Name]
+		switch {
+		case !ok:
+			changes = append(changes, kubeconfigChange{changeAdded, "context", context.Name, "cluster/user " + target})
+		case previous != target:
+			changes = append(changes, kubeconfigChange{changeModified, "context", context.Name, "cluster/user " + previous + " -> " + target})
+		}
+	}
+	for _, context := range before.Contexts {
+		if _, ok := afterContexts[context.Name]; !ok {
+			changes = append(changes, kubeconfigChange{changeRemoved, "context", context.Name, ""})
+		}
+	}
+
+	beforeUsers := map[string]map[string]interface{}{}
+	for _, user := range before.Users {
+		beforeUsers[user.Name] = user.User
+	}
+	afterUsers := map[string]bool{}
+	for _, user := range after.Users {
+		afterUsers[user.Name] = true
+		credentials, ok := beforeUsers[user.Name]
+		switch {
+		case !ok:
+			changes = append(changes, kubeconfigChange{changeAdded, "user", user.Name, ""})
+		case !reflect.DeepEqual(credentials, user.User):
+			changes = append(changes, kubeconfigChange{changeModified, "user", user.Name, "credentials changed"})
+		}
+	}
+	for _, user := range before.Users {
+		if !afterUsers[user.Name] {
+			changes = append(changes, kubeconfigChange{changeRemoved, "user", user.Name, ""})
+		}
+	}
+
+	if before.CurrentContext != after.CurrentContext {
+		changes = append(changes, kubeconfigChange{changeModified, "current-context", "", before.CurrentContext + " -> " + after.CurrentContext})
+	}
+	return changes
+}
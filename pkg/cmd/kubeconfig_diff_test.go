@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func Test_diffKubeconfigs(t *testing.T) {
+	before := kubeconfigView{}
+	json.Unmarshal([]byte(`{
+  "current-context": "home",
+  "clusters": [
+    {"name": "home", "cluster": {"server": "https://192.168.0.100:6443"}},
+    {"name": "old", "cluster": {"server": "https://192.168.0.50:6443"}}
+  ],
+  "contexts": [{"name": "home", "context": {"cluster": "home", "user": "home"}}],
+  "users": [{"name": "home", "user": {"token": "a"}}]
+}`), &before)
+
+	after := kubeconfigView{}
+	json.Unmarshal([]byte(`{
+  "current-context": "home",
+  "clusters": [
+    {"name": "home", "cluster": {"server": "https://192.168.0.101:6443"}},
+    {"name": "lab", "cluster": {"server": "https://10.0.0.1:6443"}}
+  ],
+  "contexts": [
+    {"name": "home", "context": {"cluster": "home", "user": "home"}},
+    {"name": "lab", "context": {"cluster": "lab", "user": "lab"}}
+  ],
+  "users": [{"name": "home", "user": {"token": "b"}}, {"name": "lab", "user": {"token": "c"}}]
+}`), &after)
+
+	want := []kubeconfigChange{
+		{changeModified, "cluster", "home", "https://192.168.0.100:6443 -> https://192.168.0.101:6443"},
+		{changeAdded, "cluster", "lab", "https://10.0.0.1:6443"},
+		{changeRemoved, "cluster", "old", "https://192.168.0.50:6443"},
+		{changeAdded, "context", "lab", "cluster/user lab/lab"},
+		{changeModified, "user", "home", "credentials changed"},
+		{changeAdded, "user", "lab", ""},
+	}
+
+	got := diffKubeconfigs(before, after)
+	if len(got) != len(want) {
+		t.Fatalf("want %d changes, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("change %d, want %v, got %v", i, want[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// kubeconfigExportFile is the shell snippet kept alongside the per-cluster
+// files in --kubeconfig-dir mode
+const kubeconfigExportFile = "kubeconfig.sh"
+
+// k3sNames matches the cluster, context and user entries in the kubeconfig
+// written by k3s, which are all called "default"
+var k3sNames = regexp.MustCompile(`(?m)^(\s*(?:-\s+)?(?:name|cluster|user|current-context):\s+)default\s*$`)
+
+// renameContext renames the cluster, context and user in a k3s kubeconfig
+// so that it can sit alongside other clusters
+func renameContext(kubeconfig []byte, name string) []byte {
+	if name == "default" {
+		return kubeconfig
+	}
+	return k3sNames.ReplaceAll(kubeconfig, []byte("${1}"+name))
+}
+
+// contextCluster matches the cluster of a context, the only "cluster" key
+// with a value in a kubeconfig
+var contextCluster = regexp.MustCompile(`(?m)^([ \t]+)cluster:[ \t]+\S+[ \t]*$`)
+
+// namespaceName is a DNS-1123 label, which Kubernetes requires of namespaces
+var namespaceName = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// setContextNamespace gives the contexts in a k3s kubeconfig a default
+// namespace
+func setContextNamespace(kubeconfig []byte, namespace string) []byte {
+	if len(namespace) == 0 {
+		return kubeconfig
+	}
+	return contextCluster.ReplaceAll(kubeconfig, []byte("${0}\n${1}namespace: "+namespace))
+}
+
+// writeClusterKubeconfig saves kubeconfig to dir/<context>.yaml and, when
+// export is set, regenerates a snippet which puts every file in dir on
+// KUBECONFIG
+func writeClusterKubeconfig(dir, context string, kubeconfig []byte, export bool) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("unable to create kubeconfig directory: %s", err)
+	}
+
+	path := filepath.Join(dir, context+".yaml")
+	unlock, err := lockFile(path, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := writeConfig(path, renameContext(kubeconfig, context), false); err != nil {
+		return err
+	}
+	unlock()
+
+	if !export {
+		return nil
+	}
+	return writeKubeconfigExport(dir)
+}
+
+func writeKubeconfigExport(dir string) error {
+	exportPath := filepath.Join(dir, kubeconfigExportFile)
+	unlock, err := lockFile(exportPath, lockTimeout)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	snippet := fmt.Sprintf("# Generated by k3sup, load with: source %s\nexport KUBECONFIG=\"%s\"\n", exportPath, strings.Join(files, ":"))
+	if err := ioutil.WriteFile(exportPath, []byte(snippet), 0600); err != nil {
+		return fmt.Errorf("unable to write %s: %s", exportPath, err)
+	}
+
+	fmt.Printf("Run: source %s\n", exportPath)
+	return nil
+}
+
+// clusterContextName names a cluster after its IP when no --context is given
+func clusterContextName(ip string) string {
+	return "k3s-" + strings.NewReplacer(".", "-", ":", "-").Replace(ip)
+}
@@ -0,0 +1,72 @@
+package cmd
+
+import "testing"
+
+func Test_renameContext(t *testing.T) {
+	kubeconfig := `apiVersion: v1
+clusters:
+- cluster:
+    server: https://192.168.0.100:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+current-context: default
+kind: Config
+users:
+- name: default
+  user:
+    username: default
+`
+	want := `apiVersion: v1
+clusters:
+- cluster:
+    server: https://192.168.0.100:6443
+  name: edge
+contexts:
+- context:
+    cluster: edge
+    user: edge
+  name: edge
+current-context: edge
+kind: Config
+users:
+- name: edge
+  user:
+    username: default
+`
+	got := string(renameContext([]byte(kubeconfig), "edge"))
+	if got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_setContextNamespace(t *testing.T) {
+	kubeconfig := `clusters:
+- cluster:
+    server: https://192.168.0.100:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: default
+  name: default
+`
+	want := `clusters:
+- cluster:
+    server: https://192.168.0.100:6443
+  name: default
+contexts:
+- context:
+    cluster: default
+    namespace: team-a
+    user: default
+  name: default
+`
+	got := string(setContextNamespace([]byte(kubeconfig), "team-a"))
+	if got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
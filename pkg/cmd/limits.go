@@ -0,0 +1,96 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cpuQuota  = regexp.MustCompile(`^[1-9][0-9]*%$`)
+	memoryMax = regexp.MustCompile(`^[1-9][0-9]*[KMGT]?$`)
+)
+
+// serviceLimits are the resources the k3s service may use, for hosts it
+// shares with other workloads. Empty fields are left to systemd.
+type serviceLimits struct {
+	CPUQuota  string
+	MemoryMax string
+	Nice      string
+}
+
+func getServiceLimits(command *cobra.Command) (serviceLimits, error) {
+	limits := serviceLimits{}
+	limits.CPUQuota, _ = command.Flags().GetString("service-cpu-quota")
+	limits.MemoryMax, _ = command.Flags().GetString("service-memory-max")
+
+	if len(limits.CPUQuota) > 0 && !cpuQuota.MatchString(limits.CPUQuota) {
+		return limits, fmt.Errorf("--service-cpu-quota %q should be a percentage, e.g. 150%%", limits.CPUQuota)
+	}
+	limits.MemoryMax = strings.ToUpper(limits.MemoryMax)
+	if len(limits.MemoryMax) > 0 && !memoryMax.MatchString(limits.MemoryMax) {
+		return limits, fmt.Errorf("--service-memory-max %q should be a size in bytes with an optional K, M, G or T suffix, e.g. 1G", limits.MemoryMax)
+	}
+	if command.Flags().Changed("service-nice") {
+		nice, _ := command.Flags().GetInt("service-nice")
+		if nice < -20 || nice > 19 {
+			return limits, fmt.Errorf("--service-nice should be from -20 to 19")
+		}
+		limits.Nice = strconv.Itoa(nice)
+	}
+	return limits, nil
+}
+
+func (l serviceLimits) empty() bool {
+	return l == serviceLimits{}
+}
+
+// dropIn is the systemd drop-in which applies the limits
+func (l serviceLimits) dropIn() string {
+	dropIn := "# Managed by k3sup\n[Service]\n"
+	if len(l.CPUQuota) > 0 {
+		dropIn += "CPUAccounting=yes\nCPUQuota=" + l.CPUQuota + "\n"
+	}
+	if len(l.MemoryMax) > 0 {
+		dropIn += "MemoryAccounting=yes\nMemoryMax=" + l.MemoryMax + "\n"
+	}
+	if len(l.Nice) > 0 {
+		dropIn += "Nice=" + l.Nice + "\n"
+	}
+	return dropIn
+}
+
+func serviceLimitsPath(service string) string {
+	return fmt.Sprintf("/etc/systemd/system/%s.service.d/k3sup-limits.conf", service)
+}
+
+// applyServiceLimits writes the drop-in for service ahead of the installer,
+// whose daemon-reload and restart then pick it up. It reports whether the
+// drop-in changed, so that a skipped installer can restart the service
+// itself.
+func applyServiceLimits(ctx context.Context, operator kssh.Operator, service string, limits serviceLimits, escalation string) (bool, error) {
+	if limits.empty() {
+		return false, nil
+	}
+	if res, err := operator.ExecuteSilent("command -v systemctl"); err != nil || len(res.StdOut) == 0 {
+		return false, fmt.Errorf("--service-cpu-quota, --service-memory-max and --service-nice need systemd on the host")
+	}
+
+	path := serviceLimitsPath(service)
+	fmt.Printf("Limiting %s with %s\n", service, path)
+	return uploadIfChanged(ctx, operator, strings.NewReader(limits.dropIn()), path, "0644", escalation)
+}
+
+// restartForLimits applies a changed drop-in when the installer didn't run
+func restartForLimits(operator kssh.Operator, service, escalation string) error {
+	command := fmt.Sprintf("%[1]ssystemctl daemon-reload && %[1]ssystemctl restart %[2]s", escalation, service)
+	if res, err := operator.ExecuteSilent(command); err != nil {
+		return fmt.Errorf("unable to restart %s with the new limits: %s %s", service, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import "testing"
+
+func Test_serviceLimits_dropIn(t *testing.T) {
+	limits := serviceLimits{CPUQuota: "150%", MemoryMax: "1G", Nice: "10"}
+	want := `# Managed by k3sup
+[Service]
+CPUAccounting=yes
+CPUQuota=150%
+MemoryAccounting=yes
+MemoryMax=1G
+Nice=10
+`
+	if got := limits.dropIn(); got != want {
+		t.Errorf("want:\n%s\ngot:\n%s", want, got)
+	}
+}
+
+func Test_serviceLimits_dropInNiceOnly(t *testing.T) {
+	limits := serviceLimits{Nice: "-5"}
+	want := "# Managed by k3sup\n[Service]\nNice=-5\n"
+	if got := limits.dropIn(); got != want {
+		t.Errorf("want: %q, got: %q", want, got)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// lockTimeout is how long to wait for another k3sup process to finish
+	// writing a local file
+	lockTimeout = time.Minute * 2
+
+	// staleLockAge is the age after which a lock is assumed to have been
+	// left behind by a process which was killed
+	staleLockAge = time.Minute * 10
+
+	lockPollInterval = time.Millisecond * 250
+)
+
+// lockFile takes an advisory lock on path by creating path.lock exclusively,
+// which works the same on every OS and filesystem. The returned func releases
+// the lock and is safe to call more than once.
+func lockFile(path string, timeout time.Duration) (func(), error) {
+	lockPath := path + ".lock"
+	deadline := time.Now().Add(timeout)
+	waiting := false
+
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+		if err == nil {
+			file.WriteString(strconv.Itoa(os.Getpid()))
+			file.Close()
+
+			once := sync.Once{}
+			return func() { once.Do(func() { os.Remove(lockPath) }) }, nil
+		}
+
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("unable to lock %s: %s", path, err)
+		}
+
+		if info, statErr := os.Stat(lockPath); statErr == nil && time.Since(info.ModTime()) > staleLockAge {
+			fmt.Printf("Removing stale lock %s\n", lockPath)
+			os.Remove(lockPath)
+			continue
+		}
+
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timed out after %s waiting for the lock on %s, remove %s if no other k3sup is running", timeout, path, lockPath)
+		}
+
+		if !waiting {
+			fmt.Printf("Waiting for another k3sup to finish writing %s\n", path)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
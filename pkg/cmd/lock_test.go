@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_lockFile_WaitsForRelease(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "kubeconfig")
+
+	unlock, err := lockFile(path, time.Second)
+	if err != nil {
+		t.Fatalf("want lock, got error: %s", err)
+	}
+
+	if _, err := lockFile(path, time.Millisecond*300); err == nil {
+		t.Fatalf("want timeout while the lock is held")
+	}
+
+	go func() {
+		time.Sleep(time.Millisecond * 300)
+		unlock()
+	}()
+
+	unlockAgain, err := lockFile(path, time.Second*5)
+	if err != nil {
+		t.Fatalf("want lock after release, got error: %s", err)
+	}
+	unlock()
+	if _, err := os.Stat(path + ".lock"); err != nil {
+		t.Fatalf("a second release of the first lock removed the new lock")
+	}
+	unlockAgain()
+}
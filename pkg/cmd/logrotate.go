@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	logrotateConfigPath = "/etc/logrotate.d/k3sup"
+	journaldConfigPath  = "/etc/systemd/journald.conf.d/k3sup.conf"
+	kubeletLogsPath     = "/etc/rancher/k3s/config.yaml.d/k3sup-logs.yaml"
+)
+
+// journaldRestartScript makes journald pick up a new size limit
+var journaldRestartScript = remoteScript{Name: "journald-restart", Version: 1, Body: `systemctl restart systemd-journald`}
+
+// rotateLogsScript rotates the logs k3sup manages straight away, and trims
+// the journal to the size given as its argument, if any
+var rotateLogsScript = remoteScript{Name: "rotate-logs", Version: 1, Body: `if ! command -v logrotate > /dev/null 2>&1; then
+  echo "logrotate is not installed" >&2
+  exit 1
+fi
+logrotate -f ` + logrotateConfigPath + ` || exit 1
+[ -z "$1" ] || journalctl --vacuum-size="$1"`}
+
+// k3sLogFiles are written by k3s when it isn't logging to the journal, such
+// as under openrc, along with containerd's own log
+var k3sLogFiles = []string{
+	"/var/log/k3s.log",
+	"/var/log/k3s-agent.log",
+	"/var/lib/rancher/k3s/agent/containerd/containerd.log",
+}
+
+// logLimits are the size caps placed on each kind of log
+type logLimits struct {
+	MaxSize        string
+	Rotate         int
+	JournalMaxUse  string
+	PodLogMaxSize  string
+	PodLogMaxFiles int
+}
+
+func MakeLogrotate() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "logrotate",
+		Short: "Cap the size of k3s, containerd and pod logs on nodes",
+		Long: `Cap the size of k3s, containerd and pod logs on nodes, so that devices
+with small disks don't fill up. A logrotate rule covers the k3s and
+containerd log files, journald is limited for hosts where k3s logs to the
+journal, and the kubelet is told to rotate container logs.`,
+		Example:      `  k3sup logrotate --ip 192.168.0.100,192.168.0.101 --max-size 20M --run`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the nodes, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the nodes, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().String("max-size", "50M", "Rotate k3s and containerd logs when they reach this size")
+	command.Flags().Int("rotate", 3, "Number of rotated logs to keep")
+	command.Flags().String("journal-max-use", "200M", "Disk space the journal may use")
+	command.Flags().String("pod-log-max-size", "10Mi", "Rotate container logs when they reach this size")
+	command.Flags().Int("pod-log-max-files", 3, "Number of container log files to keep")
+	command.Flags().Bool("run", false, "Rotate the logs and trim the journal now")
+	command.Flags().Bool("restart", true, "Restart k3s when the container log settings change, which is needed to apply them")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		hosts, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		run, _ := command.Flags().GetBool("run")
+		restart, _ := command.Flags().GetBool("restart")
+
+		limits := logLimits{}
+		limits.MaxSize, _ = command.Flags().GetString("max-size")
+		limits.Rotate, _ = command.Flags().GetInt("rotate")
+		limits.JournalMaxUse, _ = command.Flags().GetString("journal-max-use")
+		limits.PodLogMaxSize, _ = command.Flags().GetString("pod-log-max-size")
+		limits.PodLogMaxFiles, _ = command.Flags().GetInt("pod-log-max-files")
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give at least one node with --ip or --host")
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		for _, host := range hosts {
+			fmt.Printf("Configuring log rotation on %s\n", host)
+			if err := configureLogRotation(host, port, user, sshKeyPath, limits, run, restart); err != nil {
+				return errors.Wrapf(err, "unable to configure log rotation on %s", host)
+			}
+		}
+		return nil
+	}
+
+	return command
+}
+
+func configureLogRotation(host string, port int, user, sshKeyPath string, limits logLimits, run, restart bool) error {
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+	defer closeOperator()
+
+	if _, err := uploadIfChanged(context.Background(), operator, strings.NewReader(limits.logrotate()), logrotateConfigPath, "0644", escalationSudo+" "); err != nil {
+		return err
+	}
+
+	hasJournald := serviceState(operator, "systemd-journald") == "active"
+	if hasJournald {
+		changed, err := uploadIfChanged(context.Background(), operator, strings.NewReader(limits.journald()), journaldConfigPath, "0644", escalationSudo+" ")
+		if err != nil {
+			return err
+		}
+		if changed {
+			if res, err := runScript(operator, journaldRestartScript, escalationSudo+" "); err != nil {
+				return fmt.Errorf("unable to restart journald: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+			}
+		}
+	}
+
+	changed, err := uploadIfChanged(context.Background(), operator, strings.NewReader(limits.kubelet()), kubeletLogsPath, "0600", escalationSudo+" ")
+	if err != nil {
+		return err
+	}
+	k3sRunning := serviceState(operator, "k3s") == "active" || serviceState(operator, "k3s-agent") == "active"
+	if changed && k3sRunning {
+		if restart {
+			if err := restartK3s(operator); err != nil {
+				return err
+			}
+		} else {
+			warn(msgLogrotate, host)
+		}
+	}
+
+	if run {
+		return rotateLogsNow(operator, hasJournald, limits)
+	}
+	return nil
+}
+
+func rotateLogsNow(operator kssh.Operator, hasJournald bool, limits logLimits) error {
+	journalSize := ""
+	if hasJournald {
+		journalSize = limits.JournalMaxUse
+	}
+	if res, err := runScript(operator, rotateLogsScript, escalationSudo+" ", journalSize); err != nil {
+		return fmt.Errorf("unable to rotate logs: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// logrotate uses copytruncate, since k3s and containerd keep their log
+// files open and don't reopen them on a signal
+func (l logLimits) logrotate() string {
+	return fmt.Sprintf(`# Managed by k3sup
+%s {
+    size %s
+    rotate %d
+    compress
+    missingok
+    notifempty
+    copytruncate
+}
+`, strings.Join(k3sLogFiles, " "), l.MaxSize, l.Rotate)
+}
+
+func (l logLimits) journald() string {
+	return fmt.Sprintf("# Managed by k3sup\n[Journal]\nSystemMaxUse=%s\n", l.JournalMaxUse)
+}
+
+func (l logLimits) kubelet() string {
+	return fmt.Sprintf(`# Managed by k3sup
+kubelet-arg:
+  - "container-log-max-size=%s"
+  - "container-log-max-files=%d"
+`, l.PodLogMaxSize, l.PodLogMaxFiles)
+}
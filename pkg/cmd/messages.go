@@ -0,0 +1,144 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// messagesEnv names a JSON file of message IDs to format strings, which
+// replace the English text so that distributions can localize or re-brand
+// the output
+const messagesEnv = "K3SUP_MESSAGES"
+
+// Message IDs name each catalogued message. Warnings use the ID as their
+// code, so tests and scripts can match on it instead of the English text.
+const (
+	msgWarningsHeader    = "warnings-header"
+	msgHostKey           = "host-key"
+	msgRebootRequired    = "reboot-required"
+	msgRebooting         = "rebooting"
+	msgRebootBack        = "reboot-back"
+	msgInstallSkipped    = "install-skipped"
+	msgDownloadRetry     = "download-retry"
+	msgKubeconfigSaved   = "kubeconfig-saved"
+	msgKubeconfigMerge   = "kubeconfig-merge"
+	msgKubeconfigExpires = "kubeconfig-expires"
+	msgClusterInfo       = "cluster-info"
+	msgLocalPath         = "local-path"
+	msgLocalPathConfig   = "local-path-config"
+	msgStorageClass      = "storage-class"
+	msgNodeCache         = "node-cache"
+	msgExtraArgs         = "extra-args"
+	msgCABundle          = "ca-bundle"
+	msgHostname          = "hostname"
+	msgLogrotate         = "logrotate"
+	msgExportSecrets     = "export-secrets"
+	msgRootlessLinger    = "rootless-linger"
+	msgReleaseCheck      = "release-check"
+	msgChecksum          = "checksum"
+	msgChecksumVerified  = "checksum-verified"
+	msgEtcdOnly          = "etcd-only"
+	msgChannel           = "channel"
+	msgInstallScript     = "install-script"
+	msgUploading         = "uploading"
+	msgUploadProgress    = "upload-progress"
+	msgUploadResumed     = "upload-resumed"
+	msgUploadUnchanged   = "upload-unchanged"
+)
+
+// defaultMessages is the English format string of each message
+var defaultMessages = map[string]string{
+	msgWarningsHeader:    "Warnings (%d):",
+	msgHostKey:           "ssh host keys are not verified, so the connection could be intercepted",
+	msgRebootRequired:    "%s requires a reboot to finish applying updates, reboot it or re-run with --reboot-if-required",
+	msgRebooting:         "%s requires a reboot, rebooting",
+	msgRebootBack:        "%s is back, waiting for %s",
+	msgInstallSkipped:    "%s is already installed with the same options, skipping the installer (use --ignore-node-cache to run it anyway)",
+	msgDownloadRetry:     "Download failed during install, retrying in %s (attempt %d/%d)",
+	msgKubeconfigSaved:   "Saving file to: %s",
+	msgKubeconfigMerge:   "Merging with existing kubeconfig at %s",
+	msgKubeconfigExpires: "The kubeconfig expires at %s",
+	msgClusterInfo:       "%s",
+	msgLocalPath:         "%s",
+	msgLocalPathConfig:   "k3s %s has no --default-local-storage-path, the local-path-config ConfigMap was edited instead and may be reset when k3s restarts",
+	msgStorageClass:      "%s",
+	msgNodeCache:         "%s",
+	msgExtraArgs:         "%s is not supported by k3s %s, using %s instead",
+	msgCABundle:          "%s already exists and was not changed, add ca_file: %s for %s to it yourself",
+	msgHostname:          "%s was renamed while k3s is running, it will join as a new node when k3s restarts",
+	msgLogrotate:         "restart k3s on %s to apply the container log settings",
+	msgExportSecrets:     "%s contains credentials for the cluster, store it somewhere safe",
+	msgRootlessLinger:    "lingering is off for the user, so k3s stops at logout and doesn't start at boot, run sudo loginctl enable-linger once to keep it running",
+	msgReleaseCheck:      "unable to check that k3s %s is a release, installing it anyway: %s",
+	msgChecksum:          "unable to fetch the published checksums of k3s %s, the binary wasn't verified: %s",
+	msgChecksumVerified:  "Verified the sha256 of k3s %s against k3s %s",
+	msgEtcdOnly:          "%s runs only etcd, join servers to it with --server-role control-plane to run the apiserver",
+	msgChannel:           "The k3s %s channel is at %s",
+	msgInstallScript:     "Downloaded the install script from %s (%d bytes)",
+	msgUploading:         "Uploading %s to %s",
+	msgUploadProgress:    "Uploading %s: %s of %s (%d%%) %s/s",
+	msgUploadResumed:     "Resuming upload of %s from %s",
+	msgUploadUnchanged:   "%s is unchanged, skipping upload",
+}
+
+var catalog = struct {
+	sync.Once
+	messages map[string]string
+}{}
+
+// message formats the message with the given ID, from the catalog in
+// K3SUP_MESSAGES when it has one and from the English text otherwise
+func message(id string, args ...interface{}) string {
+	catalog.Do(func() {
+		catalog.messages = loadCatalog(os.Getenv(messagesEnv))
+	})
+
+	format, ok := catalog.messages[id]
+	if !ok {
+		format, ok = defaultMessages[id]
+	}
+	if !ok {
+		return id
+	}
+	return fmt.Sprintf(format, args...)
+}
+
+// loadCatalog reads a catalog file. An unreadable file, or an entry which
+// takes different arguments to the English text, is reported and the English
+// text is used instead, since a bad translation shouldn't stop an install.
+func loadCatalog(path string) map[string]string {
+	messages := map[string]string{}
+	if len(path) == 0 {
+		return messages
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err == nil {
+		err = json.Unmarshal(data, &messages)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to load %s from %s, using the default messages: %s\n", messagesEnv, path, err)
+		return map[string]string{}
+	}
+
+	for id, format := range messages {
+		if want, ok := defaultMessages[id]; !ok || formatVerbs(format) != formatVerbs(want) {
+			fmt.Fprintf(os.Stderr, "ignoring message %q in %s, it is unknown or takes different arguments\n", id, path)
+			delete(messages, id)
+		}
+	}
+	return messages
+}
+
+var verbPattern = regexp.MustCompile(`%(\[\d+\])?[-+# 0-9.]*(\[\d+\])?[a-zA-Z]`)
+
+// formatVerbs counts the verbs of a format string. Only the count is
+// compared, so a translation can reorder the arguments with %[2]s.
+func formatVerbs(format string) int {
+	return len(verbPattern.FindAllString(strings.Replace(format, "%%", "", -1), -1))
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func Test_loadCatalog_IgnoresMismatchedArguments(t *testing.T) {
+	file, err := ioutil.TempFile("", "k3sup-messages-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+
+	file.WriteString(`{
+  "rebooting": "Neustart von %s",
+  "reboot-back": "%[2]s auf %[1]s",
+  "host-key": "%s",
+  "no-such-message": "x"
+}`)
+	file.Close()
+
+	messages := loadCatalog(file.Name())
+
+	if got := messages[msgRebooting]; got != "Neustart von %s" {
+		t.Errorf("want the translation of %s, got %q", msgRebooting, got)
+	}
+	if _, ok := messages[msgRebootBack]; !ok {
+		t.Errorf("want %s kept, reordered arguments are allowed", msgRebootBack)
+	}
+	for _, id := range []string{msgHostKey, "no-such-message"} {
+		if _, ok := messages[id]; ok {
+			t.Errorf("want %s ignored", id)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func MakeNetwork() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "network",
+		Short:        "Configure the network of a host before installing k3s",
+		Example:      `  k3sup network static --help`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeNetworkStatic())
+	command.AddCommand(makeNetworkHosts())
+
+	return command
+}
+
+func makeNetworkStatic() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "static",
+		Short: "Give a host which booted with DHCP a static IP address",
+		Long: `Give a host which booted with DHCP a static IP address, then wait for it
+to accept SSH on the new address. netplan, NetworkManager and dhcpcd are
+supported, whichever the host uses is configured.`,
+		Example: `  k3sup network static --ip 192.168.0.23 \
+    --address 192.168.0.100/24 --gateway 192.168.0.1 --dns 192.168.0.1 && \
+  k3sup install --ip 192.168.0.100`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", nil, "Current IP of the host")
+	command.Flags().String("host", "", "Current DNS name of the host, instead of --ip")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().String("address", "", "Static address with its prefix length, e.g. 192.168.0.100/24")
+	command.Flags().IP("gateway", nil, "Default gateway")
+	command.Flags().IPSlice("dns", nil, "DNS servers, can be repeated")
+	command.Flags().String("interface", "", "Interface to configure, defaults to the one with the default route")
+	command.Flags().Bool("reboot", false, "Reboot to apply the change instead of applying it live")
+	command.Flags().Duration("timeout", time.Minute*5, "How long to wait for the host on its new address")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		address, _ := command.Flags().GetString("address")
+		gateway, _ := command.Flags().GetIP("gateway")
+		dns, _ := command.Flags().GetIPSlice("dns")
+		iface, _ := command.Flags().GetString("interface")
+		reboot, _ := command.Flags().GetBool("reboot")
+		timeout, _ := command.Flags().GetDuration("timeout")
+
+		if len(host) == 0 {
+			return fmt.Errorf("give the current address of the host with --ip or --host")
+		}
+
+		newIP, _, err := net.ParseCIDR(address)
+		if err != nil {
+			return fmt.Errorf("give --address as an IP with its prefix length, e.g. 192.168.0.100/24")
+		}
+		if gateway == nil {
+			return fmt.Errorf("give the default gateway with --gateway")
+		}
+
+		config := staticNetwork{Interface: iface, Address: address, Gateway: gateway.String()}
+		for _, server := range dns {
+			config.DNS = append(config.DNS, server.String())
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+		if err != nil {
+			return err
+		}
+
+		res, err := operator.ExecuteSilent(config.script(reboot))
+		closeOperator()
+		if err != nil {
+			return fmt.Errorf("unable to configure a static address: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+		}
+		fmt.Printf("Configured %s with %s\n", host, strings.TrimSpace(string(res.StdOut)))
+
+		// The connection drops as the address changes, so wait before dialing
+		time.Sleep(time.Second * 10)
+
+		fmt.Printf("Waiting for ssh %s@%s\n", user, newIP)
+		operator, closeOperator, err = waitForSSH(context.Background(), newIP.String(), port, user, sshKeyPath, timeout)
+		if err != nil {
+			return err
+		}
+		defer closeOperator()
+
+		fmt.Printf("%s is reachable at %s\n", host, newIP)
+		return nil
+	}
+
+	return command
+}
+
+// staticNetwork is the address to give the host's interface
+type staticNetwork struct {
+	Interface string
+	Address   string
+	Gateway   string
+	DNS       []string
+}
+
+// script renders a shell script which finds out how the host manages its
+// network and writes the static configuration for it. The change is
+// applied in the background, or by a reboot, so that the SSH session can
+// return before the old address goes away.
+func (n staticNetwork) script(reboot bool) string {
+	iface := n.Interface
+	if len(iface) == 0 {
+		iface = "$(ip route show default | awk '{ print $5; exit }')"
+	}
+
+	apply := map[string]string{
+		"netplan":        "netplan apply",
+		"NetworkManager": `nmcli connection up \"$con\"`,
+		"dhcpcd":         "systemctl restart dhcpcd",
+	}
+	for backend := range apply {
+		if reboot {
+			apply[backend] = "reboot"
+		}
+		apply[backend] = fmt.Sprintf(`sudo nohup sh -c "sleep 2; %s" < /dev/null > /dev/null 2>&1 &`, apply[backend])
+	}
+
+	return fmt.Sprintf(`set -e
+iface=%s
+if [ -z "$iface" ]; then echo 'unable to find the interface with the default route' >&2; exit 1; fi
+if [ -d /etc/netplan ] && command -v netplan > /dev/null 2>&1; then
+  echo "$iface using netplan"
+  sudo tee /etc/netplan/99-k3sup-static.yaml > /dev/null <<EOF
+%s
+EOF
+  sudo chmod 600 /etc/netplan/99-k3sup-static.yaml
+  %s
+elif command -v nmcli > /dev/null 2>&1 && [ -n "$(nmcli -t -f DEVICE,NAME connection show --active | grep "^$iface:")" ]; then
+  con=$(nmcli -t -f DEVICE,NAME connection show --active | grep "^$iface:" | cut -d: -f2-)
+  echo "$iface using NetworkManager connection $con"
+  sudo nmcli connection modify "$con" ipv4.method manual ipv4.addresses %s ipv4.gateway %s ipv4.dns '%s'
+  %s
+elif [ -f /etc/dhcpcd.conf ]; then
+  echo "$iface using dhcpcd"
+  sudo sed -i '/^# k3sup static begin/,/^# k3sup static end/d' /etc/dhcpcd.conf
+  sudo tee -a /etc/dhcpcd.conf > /dev/null <<EOF
+%s
+EOF
+  %s
+else
+  echo 'netplan, NetworkManager or dhcpcd is needed to configure a static address' >&2
+  exit 1
+fi`,
+		iface,
+		n.netplan(), apply["netplan"],
+		n.Address, n.Gateway, strings.Join(n.DNS, ","), apply["NetworkManager"],
+		n.dhcpcd(), apply["dhcpcd"])
+}
+
+// netplan configures the interface in "$iface", which is expanded by the
+// heredoc it is written with
+func (n staticNetwork) netplan() string {
+	out := fmt.Sprintf(`network:
+  version: 2
+  ethernets:
+    $iface:
+      dhcp4: false
+      addresses: [%s]
+      gateway4: %s`, n.Address, n.Gateway)
+
+	if len(n.DNS) > 0 {
+		out += fmt.Sprintf("\n      nameservers:\n        addresses: [%s]", strings.Join(n.DNS, ", "))
+	}
+	return out
+}
+
+func (n staticNetwork) dhcpcd() string {
+	out := fmt.Sprintf(`# k3sup static begin
+interface $iface
+static ip_address=%s
+static routers=%s`, n.Address, n.Gateway)
+
+	if len(n.DNS) > 0 {
+		out += "\nstatic domain_name_servers=" + strings.Join(n.DNS, " ")
+	}
+	return out + "\n# k3sup static end"
+}
@@ -0,0 +1,140 @@
+package cmd
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// clusterHost is a node's name along with the address it is reached on
+type clusterHost struct {
+	Name string
+	IP   string
+}
+
+func makeNetworkHosts() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "hosts",
+		Short: "Add every node of a cluster to /etc/hosts on each node",
+		Long: `Add every node of a cluster to /etc/hosts on each node, for networks
+without reliable internal DNS. The entries are kept in a block managed by
+k3sup, which is replaced each time. With --set-hostname each node is also
+renamed to the name given for it.`,
+		Example: `  k3sup network hosts --set-hostname \
+    --node server-1=192.168.0.100 \
+    --node agent-1=192.168.0.101`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().StringArray("node", []string{}, "A node as NAME=IP, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().Bool("set-hostname", false, "Set the hostname of each node to its name")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		nodes, _ := command.Flags().GetStringArray("node")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		setHostname, _ := command.Flags().GetBool("set-hostname")
+
+		hosts, err := parseClusterHosts(nodes)
+		if err != nil {
+			return err
+		}
+		if len(hosts) == 0 {
+			return fmt.Errorf("give the nodes with --node NAME=IP")
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		block := hostsBlock(hosts)
+		defer printWarnings(os.Stdout)
+
+		for _, host := range hosts {
+			fmt.Printf("Updating %s (%s)\n", host.Name, host.IP)
+			if err := updateHosts(host, port, user, sshKeyPath, block, setHostname); err != nil {
+				return errors.Wrapf(err, "unable to update %s", host.Name)
+			}
+		}
+		return nil
+	}
+
+	return command
+}
+
+// hostnamePattern accepts names made of DNS labels, which is what both
+// hostnames and Kubernetes node names allow
+var hostnamePattern = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]*[a-z0-9])?(\.[a-z0-9]([a-z0-9-]*[a-z0-9])?)*$`)
+
+func parseClusterHosts(nodes []string) ([]clusterHost, error) {
+	hosts := []clusterHost{}
+	for _, node := range nodes {
+		parts := strings.SplitN(node, "=", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || net.ParseIP(parts[1]) == nil {
+			return nil, fmt.Errorf("give --node as NAME=IP, not %q", node)
+		}
+		if !hostnamePattern.MatchString(parts[0]) {
+			return nil, fmt.Errorf("%q is not a valid hostname, use lowercase letters, digits, - and .", parts[0])
+		}
+		hosts = append(hosts, clusterHost{Name: parts[0], IP: parts[1]})
+	}
+	return hosts, nil
+}
+
+// hostsScript replaces the block k3sup manages in /etc/hosts with the one
+// given as its argument
+var hostsScript = remoteScript{Name: "hosts", Version: 1, Body: `sed -i '/^# k3sup hosts begin/,/^# k3sup hosts end/d' /etc/hosts &&
+printf '%s' "$1" >> /etc/hosts`}
+
+// hostnameScript sets the hostname given as its argument
+var hostnameScript = remoteScript{Name: "hostname", Version: 1, Body: `if command -v hostnamectl > /dev/null 2>&1; then
+  hostnamectl set-hostname "$1"
+else
+  echo "$1" > /etc/hostname && hostname "$1"
+fi`}
+
+func hostsBlock(hosts []clusterHost) string {
+	block := "# k3sup hosts begin\n"
+	for _, host := range hosts {
+		block += fmt.Sprintf("%s %s\n", host.IP, host.Name)
+	}
+	return block + "# k3sup hosts end\n"
+}
+
+func updateHosts(host clusterHost, port int, user, sshKeyPath, block string, setHostname bool) error {
+	operator, closeOperator, err := connectOperator(host.IP, port, user, sshKeyPath)
+	if err != nil {
+		return err
+	}
+	defer closeOperator()
+
+	if res, err := runScript(operator, hostsScript, escalationSudo+" ", block); err != nil {
+		return fmt.Errorf("unable to write /etc/hosts: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	if !setHostname {
+		return nil
+	}
+
+	res, _ := operator.ExecuteSilent("hostname")
+	if strings.TrimSpace(string(res.StdOut)) == host.Name {
+		return nil
+	}
+
+	// k3s registers a node under the hostname it had when k3s started, so a
+	// rename shows up as a new node
+	if serviceState(operator, "k3s") == "active" || serviceState(operator, "k3s-agent") == "active" {
+		warn(msgHostname, host.Name)
+	}
+
+	if res, err := runScript(operator, hostnameScript, escalationSudo+" ", host.Name); err != nil {
+		return fmt.Errorf("unable to set the hostname: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
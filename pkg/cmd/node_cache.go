@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// nodeCachePath is kept in the home directory of the SSH user, so that it
+// can be read and written without sudo
+const nodeCachePath = "~/.k3sup-cache.json"
+
+// nodeCache records what k3sup has already placed on a node, so that
+// repeated runs across a fleet can skip uploads and installs which would
+// not change anything
+type nodeCache struct {
+	// Artifacts maps remote paths to the sha256 of the file k3sup put there
+	Artifacts map[string]string `json:"artifacts"`
+
+	// Installs maps a service to a fingerprint of the installer run for it
+	Installs map[string]string `json:"installs"`
+
+	Updated time.Time `json:"updated"`
+}
+
+// readNodeCache returns an empty cache when the file is missing or can't be
+// parsed, which only costs a redundant upload or install
+func readNodeCache(operator kssh.Operator) nodeCache {
+	cache := nodeCache{}
+
+	res, err := operator.ExecuteSilent("cat " + nodeCachePath + " 2>/dev/null")
+	if err == nil {
+		json.Unmarshal(res.StdOut, &cache)
+	}
+
+	if cache.Artifacts == nil {
+		cache.Artifacts = map[string]string{}
+	}
+	if cache.Installs == nil {
+		cache.Installs = map[string]string{}
+	}
+	return cache
+}
+
+func writeNodeCache(operator kssh.Operator, cache nodeCache) error {
+	cache.Updated = time.Now().UTC()
+	data, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	res, err := operator.ExecuteWithStdin("cat > "+nodeCachePath, strings.NewReader(string(data)))
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %s %s", nodeCachePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// updateNodeCache applies update to the cache on the node. Failing to save
+// the cache only means the next run does more work, so it is a warning.
+func updateNodeCache(operator kssh.Operator, update func(cache *nodeCache)) {
+	cache := readNodeCache(operator)
+	update(&cache)
+	if err := writeNodeCache(operator, cache); err != nil {
+		warn(msgNodeCache, err)
+	}
+}
+
+// installFingerprint identifies an installer run by its command and, for
+// custom binaries and airgap images, the files which were uploaded
+func installFingerprint(command string, uploadSums ...string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(command+"\n"+strings.Join(uploadSums, "\n"))))
+}
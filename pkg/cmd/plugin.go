@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+const pluginPrefix = "k3sup-"
+
+// Plugin is an external executable named k3sup-<name> found on the PATH
+type Plugin struct {
+	Name string
+	Path string
+}
+
+// DiscoverPlugins walks each directory in PATH looking for executables
+// named k3sup-<name>. When the same name appears more than once, the first
+// match on the PATH wins, as it would in a shell.
+func DiscoverPlugins() []Plugin {
+	seen := map[string]bool{}
+	plugins := []Plugin{}
+
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if len(dir) == 0 {
+			continue
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+
+		for _, file := range files {
+			name, ok := pluginName(file)
+			if !ok || seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			plugins = append(plugins, Plugin{
+				Name: name,
+				Path: filepath.Join(dir, file.Name()),
+			})
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return plugins[i].Name < plugins[j].Name
+	})
+
+	return plugins
+}
+
+func pluginName(file os.FileInfo) (string, bool) {
+	if file.IsDir() || !strings.HasPrefix(file.Name(), pluginPrefix) {
+		return "", false
+	}
+
+	name := strings.TrimPrefix(file.Name(), pluginPrefix)
+	if runtime.GOOS == "windows" {
+		if !strings.EqualFold(filepath.Ext(name), ".exe") {
+			return "", false
+		}
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+	} else if file.Mode()&0111 == 0 {
+		return "", false
+	}
+
+	return name, len(name) > 0
+}
+
+// MakePlugin creates a sub-command which hands all of its arguments and
+// flags through to the plugin executable untouched.
+func MakePlugin(plugin Plugin) *cobra.Command {
+	var command = &cobra.Command{
+		Use:                plugin.Name,
+		Short:              fmt.Sprintf("Run the %s plugin (%s)", plugin.Name, plugin.Path),
+		DisableFlagParsing: true,
+		SilenceUsage:       true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		pluginCmd := exec.Command(plugin.Path, args...)
+		pluginCmd.Stdin = os.Stdin
+		pluginCmd.Stdout = os.Stdout
+		pluginCmd.Stderr = os.Stderr
+		pluginCmd.Env = append(os.Environ(), pluginEnv()...)
+
+		if err := pluginCmd.Run(); err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				os.Exit(exitErr.ExitCode())
+			}
+			return err
+		}
+		return nil
+	}
+
+	return command
+}
+
+// pluginEnv tells plugins which k3sup binary invoked them, so that they can
+// call back into it with the same version.
+func pluginEnv() []string {
+	env := []string{}
+	if self, err := os.Executable(); err == nil {
+		env = append(env, "K3SUP_BINARY="+self)
+	}
+	if len(Version) > 0 {
+		env = append(env, "K3SUP_VERSION="+Version)
+	}
+	return env
+}
@@ -0,0 +1,278 @@
+package cmd
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+
+	"github.com/alexellis/k3sup/pkg/preflight"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+func MakePreflight() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "preflight",
+		Short: "Check that hosts are ready for k3s",
+		Long: `Check that hosts are ready for k3s by running the preflight suite over
+SSH. Hosts can be given with --ip or listed in a file, one per line as
+[user@]host[:port], and are checked in parallel.`,
+		Example: `  k3sup preflight --ip 192.168.0.100
+  k3sup preflight --hosts-file hosts.txt -o json`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the hosts to check, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the hosts to check, can be repeated")
+	command.Flags().String("hosts-file", "", "File listing hosts to check, one [user@]host[:port] per line")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().Int("parallel", 10, "Number of hosts to check at once")
+	command.Flags().StringP("output", "o", "table", "Output format: table or json")
+	command.Flags().Bool("clean-conflicts", false, "Remove kubeadm, microk8s, docker swarm and CNI leftovers found on a host, then check it again")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		addresses, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		hostsFile, _ := command.Flags().GetString("hosts-file")
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		parallel, _ := command.Flags().GetInt("parallel")
+		output, _ := command.Flags().GetString("output")
+		cleanConflicts, _ := command.Flags().GetBool("clean-conflicts")
+
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", output)
+		}
+
+		hosts := []sshHost{}
+		for _, address := range addresses {
+			hosts = append(hosts, sshHost{Host: address, User: user, Port: port})
+		}
+
+		if len(hostsFile) > 0 {
+			fromFile, err := readHostsFile(expandPath(hostsFile), user, port)
+			if err != nil {
+				return err
+			}
+			hosts = append(hosts, fromFile...)
+		}
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give the hosts to check with --ip, --host or --hosts-file")
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		authMethod, closeSSHAgent, err := loadPublickey(sshKeyPath)
+		if err != nil {
+			return errors.Wrapf(err, "unable to load the ssh key with path %q", sshKeyPath)
+		}
+		defer closeSSHAgent()
+
+		reports := runPreflight(hosts, authMethod, parallel, cleanConflicts)
+
+		if output == "json" {
+			out, _ := json.MarshalIndent(struct {
+				Hosts    []preflightReport `json:"hosts"`
+				Warnings []warning         `json:"warnings"`
+			}{reports, recordedWarnings()}, "", "  ")
+			fmt.Println(string(out))
+		} else {
+			printPreflightTable(reports)
+			printWarnings(os.Stdout)
+		}
+
+		for _, report := range reports {
+			if report.Status == preflight.Fail {
+				return fmt.Errorf("preflight failed on one or more hosts")
+			}
+		}
+		return nil
+	}
+
+	return command
+}
+
+// sshHost is a host to connect to along with its SSH login
+type sshHost struct {
+	Host string `json:"host"`
+	User string `json:"user"`
+	Port int    `json:"port"`
+}
+
+type preflightReport struct {
+	sshHost
+	Status  preflight.Status   `json:"status"`
+	Results []preflight.Result `json:"results"`
+	Cleaned []string           `json:"cleaned,omitempty"`
+}
+
+// readHostsFile parses one [user@]host[:port] per line, skipping blank
+// lines and # comments
+func readHostsFile(path, defaultUser string, defaultPort int) ([]sshHost, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read hosts file: %s", err)
+	}
+	defer file.Close()
+
+	hosts := []sshHost{}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		host, err := parseSSHHost(text, defaultUser, defaultPort)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, line, err)
+		}
+		hosts = append(hosts, host)
+	}
+
+	return hosts, scanner.Err()
+}
+
+func parseSSHHost(text, defaultUser string, defaultPort int) (sshHost, error) {
+	host := sshHost{Host: text, User: defaultUser, Port: defaultPort}
+
+	if i := strings.Index(host.Host, "@"); i >= 0 {
+		host.User = host.Host[:i]
+		host.Host = host.Host[i+1:]
+	}
+
+	// A bare IPv6 address has more than one colon and no port, an IPv6
+	// address with a port is in brackets
+	if strings.HasPrefix(host.Host, "[") || strings.Count(host.Host, ":") == 1 {
+		if name, port, err := net.SplitHostPort(host.Host); err == nil {
+			n, err := strconv.Atoi(port)
+			if err != nil {
+				return host, fmt.Errorf("invalid port in %q", text)
+			}
+			host.Host, host.Port = name, n
+		} else if strings.HasPrefix(host.Host, "[") && strings.HasSuffix(host.Host, "]") {
+			host.Host = host.Host[1 : len(host.Host)-1]
+		} else {
+			return host, fmt.Errorf("invalid host in %q", text)
+		}
+	}
+
+	if len(host.Host) == 0 {
+		return host, fmt.Errorf("no host in %q", text)
+	}
+	return host, nil
+}
+
+// runPreflight checks up to parallel hosts at once. The key is loaded by the
+// caller so that a passphrase is only asked for once.
+func runPreflight(hosts []sshHost, authMethod ssh.AuthMethod, parallel int, cleanConflicts bool) []preflightReport {
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	reports := make([]preflightReport, len(hosts))
+	limit := make(chan struct{}, parallel)
+	wg := sync.WaitGroup{}
+
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host sshHost) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			reports[i] = preflightHost(host, authMethod, cleanConflicts)
+		}(i, host)
+	}
+
+	wg.Wait()
+	return reports
+}
+
+// preflightHost runs the suite on host. With cleanConflicts, leftovers of
+// other Kubernetes distributions are removed first, so that the results
+// show whether the host is ready afterwards.
+func preflightHost(host sshHost, authMethod ssh.AuthMethod, cleanConflicts bool) preflightReport {
+	report := preflightReport{sshHost: host}
+
+	operator, err := dialOperator(host.Host, host.Port, host.User, authMethod)
+	if err != nil {
+		report.Status = preflight.Fail
+		report.Results = []preflight.Result{{Check: "ssh", Status: preflight.Fail, Message: err.Error()}}
+		return report
+	}
+	defer operator.Close()
+
+	if cleanConflicts {
+		escalation, err := escalationPrefix(operator, defaultSudo)
+		if err != nil {
+			report.Status = preflight.Fail
+			report.Results = []preflight.Result{{Check: "sudo", Status: preflight.Fail, Message: err.Error()}}
+			return report
+		}
+
+		for _, conflict := range preflight.FindConflicts(operator) {
+			if res, err := runScript(operator, conflictScript(conflict), escalation); err != nil {
+				report.Status = preflight.Fail
+				report.Results = []preflight.Result{{Check: "conflicts", Status: preflight.Fail,
+					Message: fmt.Sprintf("unable to remove %s: %s %s", conflict.Name, err, strings.TrimSpace(string(res.StdErr)))}}
+				return report
+			}
+			report.Cleaned = append(report.Cleaned, conflict.Name)
+		}
+	}
+
+	report.Results = append([]preflight.Result{{Check: "ssh", Status: preflight.Pass}}, preflight.Run(operator)...)
+	report.Status = preflight.Worst(report.Results)
+	return report
+}
+
+// printPreflightTable prints a matrix of hosts against checks, followed by
+// the message of every check which did not pass
+func printPreflightTable(reports []preflightReport) {
+	names := append([]string{"ssh"}, preflight.Names()...)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "HOST\t%s\n", strings.ToUpper(strings.Join(names, "\t")))
+
+	for _, report := range reports {
+		statuses := map[string]preflight.Status{}
+		for _, result := range report.Results {
+			statuses[result.Check] = result.Status
+		}
+
+		row := []string{}
+		for _, name := range names {
+			status, ok := statuses[name]
+			if !ok {
+				status = "-"
+			}
+			row = append(row, string(status))
+		}
+		fmt.Fprintf(w, "%s\t%s\n", report.Host, strings.Join(row, "\t"))
+	}
+	w.Flush()
+
+	for _, report := range reports {
+		if len(report.Cleaned) > 0 {
+			fmt.Printf("%s: removed %s\n", report.Host, strings.Join(report.Cleaned, ", "))
+		}
+		for _, result := range report.Results {
+			if result.Status != preflight.Pass {
+				fmt.Printf("%s: %s %s: %s\n", report.Host, result.Status, result.Check, result.Message)
+			}
+		}
+	}
+}
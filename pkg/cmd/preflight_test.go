@@ -0,0 +1,32 @@
+package cmd
+
+import "testing"
+
+func Test_parseSSHHost(t *testing.T) {
+	cases := []struct {
+		text string
+		want sshHost
+	}{
+		{"192.168.0.100", sshHost{Host: "192.168.0.100", User: "root", Port: 22}},
+		{"pi@raspberrypi.local", sshHost{Host: "raspberrypi.local", User: "pi", Port: 22}},
+		{"ubuntu@10.0.0.5:2222", sshHost{Host: "10.0.0.5", User: "ubuntu", Port: 2222}},
+		{"fd00::10", sshHost{Host: "fd00::10", User: "root", Port: 22}},
+		{"pi@[fd00::10]:2222", sshHost{Host: "fd00::10", User: "pi", Port: 2222}},
+		{"[fd00::10]", sshHost{Host: "fd00::10", User: "root", Port: 22}},
+	}
+
+	for _, c := range cases {
+		got, err := parseSSHHost(c.text, "root", 22)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.text, err)
+			continue
+		}
+		if got != c.want {
+			t.Errorf("%s: want %+v, got %+v", c.text, c.want, got)
+		}
+	}
+
+	if _, err := parseSSHHost("host:ssh", "root", 22); err == nil {
+		t.Errorf("want an error for a non-numeric port")
+	}
+}
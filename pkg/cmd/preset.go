@@ -0,0 +1,229 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// presetManifestsDir is where k3s deploys manifests from when it starts
+const presetManifestsDir = "/var/lib/rancher/k3s/server/manifests"
+
+// installPreset is a curated set of install flags for a kind of cluster,
+// and the apps k3s deploys for it from its manifests directory
+type installPreset struct {
+	Description string
+
+	// ExtraArgs go ahead of --k3s-extra-args, so that flags given on the
+	// command line come last
+	ExtraArgs string
+
+	// Flags are set unless they were given on the command line
+	Flags map[string]string
+
+	// Manifests are HelmChart resources for k3s's helm-controller, by app
+	Manifests map[string]string
+}
+
+var installPresets = map[string]installPreset{
+	"homelab": {
+		Description: "MetalLB and ingress-nginx in place of servicelb and Traefik, for LoadBalancer IPs on a home network. Needs --lb-range",
+		ExtraArgs:   "--disable servicelb --disable traefik",
+		Manifests: map[string]string{
+			"metallb":       metallbManifest,
+			"ingress-nginx": ingressNginxManifest,
+		},
+	},
+	"edge": {
+		Description: "A minimal, hardened server for small devices: no Traefik, servicelb or metrics-server, no profiling endpoints, a kubeconfig only root can read and images collected before the disk fills",
+		ExtraArgs: "--disable traefik --disable servicelb --disable metrics-server --write-kubeconfig-mode 0600" +
+			" --kube-apiserver-arg profiling=false --kube-controller-manager-arg profiling=false --kube-scheduler-arg profiling=false" +
+			" --kubelet-arg image-gc-high-threshold=70 --kubelet-arg image-gc-low-threshold=50",
+	},
+	"dev": {
+		Description: "A single node for development: a kubeconfig anyone on the host can read, and no evictions until the disk is nearly full",
+		ExtraArgs:   "--write-kubeconfig-mode 0644 --kubelet-arg eviction-hard=imagefs.available<2%,nodefs.available<2%",
+		Flags: map[string]string{
+			"context":           "k3s-dev",
+			"context-namespace": "default",
+		},
+	},
+}
+
+// metallbManifest installs MetalLB in layer 2 mode, handing out the
+// addresses of --lb-range to LoadBalancer services
+const metallbManifest = `# Managed by k3sup
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: metallb-system
+---
+apiVersion: helm.cattle.io/v1
+kind: HelmChart
+metadata:
+  name: metallb
+  namespace: kube-system
+spec:
+  repo: https://metallb.github.io/metallb
+  chart: metallb
+  version: 0.12.1
+  targetNamespace: metallb-system
+  valuesContent: |-
+    configInline:
+      address-pools:
+      - name: default
+        protocol: layer2
+        addresses:
+        - %s
+`
+
+const ingressNginxManifest = `# Managed by k3sup
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: ingress-nginx
+---
+apiVersion: helm.cattle.io/v1
+kind: HelmChart
+metadata:
+  name: ingress-nginx
+  namespace: kube-system
+spec:
+  repo: https://kubernetes.github.io/ingress-nginx
+  chart: ingress-nginx
+  targetNamespace: ingress-nginx
+  valuesContent: |-
+    controller:
+      ingressClassResource:
+        default: true
+`
+
+func addPresetFlags(command *cobra.Command) {
+	command.Flags().String("preset", "", "Install with a curated set of flags and apps: "+strings.Join(presetNames(), ", "))
+	command.Flags().Bool("show-preset", false, "Print what --preset expands to and exit without installing")
+	command.Flags().String("lb-range", "", "With --preset homelab, addresses for MetalLB to give LoadBalancer services, e.g. 192.168.0.240-192.168.0.250")
+}
+
+func presetNames() []string {
+	names := []string{}
+	for name := range installPresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyPreset sets the flags of --preset which weren't given on the
+// command line, and returns the manifests to deploy by file name
+func applyPreset(command *cobra.Command) (map[string]string, error) {
+	name, _ := command.Flags().GetString("preset")
+	lbRange, _ := command.Flags().GetString("lb-range")
+	if len(name) == 0 {
+		if len(lbRange) > 0 {
+			return nil, fmt.Errorf("--lb-range is only used with --preset homelab")
+		}
+		return nil, nil
+	}
+
+	preset, ok := installPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown --preset %q, use %s", name, strings.Join(presetNames(), ", "))
+	}
+
+	if _, ok := preset.Manifests["metallb"]; ok {
+		if err := checkLBRange(lbRange); err != nil {
+			return nil, err
+		}
+	} else if len(lbRange) > 0 {
+		return nil, fmt.Errorf("--lb-range is only used with --preset homelab")
+	}
+
+	for flag, value := range preset.Flags {
+		if !command.Flags().Changed(flag) {
+			command.Flags().Set(flag, value)
+		}
+	}
+
+	extraArgs, _ := command.Flags().GetString("k3s-extra-args")
+	command.Flags().Set("k3s-extra-args", strings.TrimSpace(preset.ExtraArgs+" "+extraArgs))
+
+	manifests := map[string]string{}
+	for app, manifest := range preset.Manifests {
+		if app == "metallb" {
+			manifest = fmt.Sprintf(manifest, lbRange)
+		}
+		manifests["k3sup-"+app+".yaml"] = manifest
+	}
+	return manifests, nil
+}
+
+// checkLBRange accepts a range of addresses or a CIDR, as MetalLB does
+func checkLBRange(lbRange string) error {
+	if len(lbRange) == 0 {
+		return fmt.Errorf("--preset homelab needs --lb-range, free addresses on your network for LoadBalancer services, e.g. 192.168.0.240-192.168.0.250")
+	}
+	if _, _, err := net.ParseCIDR(lbRange); err == nil {
+		return nil
+	}
+	bounds := strings.Split(lbRange, "-")
+	if len(bounds) != 2 || net.ParseIP(strings.TrimSpace(bounds[0])) == nil || net.ParseIP(strings.TrimSpace(bounds[1])) == nil {
+		return fmt.Errorf("--lb-range %q should be a range such as 192.168.0.240-192.168.0.250 or a CIDR", lbRange)
+	}
+	return nil
+}
+
+// printPreset shows the flags and apps --preset expands to
+func printPreset(name string) error {
+	preset, ok := installPresets[name]
+	if !ok {
+		return fmt.Errorf("unknown --preset %q, use %s", name, strings.Join(presetNames(), ", "))
+	}
+
+	fmt.Printf("%s: %s\n\n", name, preset.Description)
+	fmt.Printf("  --k3s-extra-args '%s'\n", preset.ExtraArgs)
+
+	flags := []string{}
+	for flag := range preset.Flags {
+		flags = append(flags, flag)
+	}
+	sort.Strings(flags)
+	for _, flag := range flags {
+		fmt.Printf("  --%s %s\n", flag, preset.Flags[flag])
+	}
+
+	apps := []string{}
+	for app := range preset.Manifests {
+		apps = append(apps, app)
+	}
+	sort.Strings(apps)
+	if len(apps) > 0 {
+		fmt.Println("\nDeploys:")
+	}
+	for _, app := range apps {
+		fmt.Printf("  %s from %s/k3sup-%s.yaml\n", app, presetManifestsDir, app)
+	}
+	return nil
+}
+
+// uploadPresetManifests writes the manifests of --preset for k3s to deploy
+func uploadPresetManifests(ctx context.Context, operator kssh.Operator, manifests map[string]string, escalation string) error {
+	names := []string{}
+	for name := range manifests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		manifestPath := presetManifestsDir + "/" + name
+		fmt.Printf("Deploying %s\n", manifestPath)
+		if _, err := uploadIfChanged(ctx, operator, strings.NewReader(manifests[name]), manifestPath, "0600", escalation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_applyPreset_KeepsFlagsFromTheCommandLine(t *testing.T) {
+	command := &cobra.Command{}
+	command.Flags().String("k3s-extra-args", "", "")
+	command.Flags().String("context", "default", "")
+	command.Flags().String("context-namespace", "", "")
+	addPresetFlags(command)
+
+	command.Flags().Set("preset", "dev")
+	command.Flags().Set("context", "laptop")
+	command.Flags().Set("k3s-extra-args", "--node-label dev=true")
+
+	if _, err := applyPreset(command); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := command.Flags().GetString("context"); got != "laptop" {
+		t.Errorf("want --context from the command line, got %q", got)
+	}
+	if got, _ := command.Flags().GetString("context-namespace"); got != "default" {
+		t.Errorf("want --context-namespace from the preset, got %q", got)
+	}
+	want := installPresets["dev"].ExtraArgs + " --node-label dev=true"
+	if got, _ := command.Flags().GetString("k3s-extra-args"); got != want {
+		t.Errorf("want the preset's args ahead of the command line's, got %q", got)
+	}
+}
+
+func Test_applyPreset_HomelabNeedsLBRange(t *testing.T) {
+	command := &cobra.Command{}
+	command.Flags().String("k3s-extra-args", "", "")
+	addPresetFlags(command)
+	command.Flags().Set("preset", "homelab")
+
+	if _, err := applyPreset(command); err == nil {
+		t.Fatal("want an error without --lb-range")
+	}
+
+	command.Flags().Set("lb-range", "192.168.0.240-192.168.0.250")
+	manifests, err := applyPreset(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(manifests["k3sup-metallb.yaml"], "- 192.168.0.240-192.168.0.250\n") {
+		t.Errorf("want the range in the MetalLB manifest, got:\n%s", manifests["k3sup-metallb.yaml"])
+	}
+}
@@ -0,0 +1,52 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// Prompter asks the user for what k3sup can't find out by itself, such as
+// the passphrase of an ssh key, a sudo password or whether to go ahead.
+// The CLI asks on the terminal, programs which run k3sup's commands, such
+// as a GUI, can answer instead with SetPrompter.
+type Prompter interface {
+	// Secret asks for a passphrase or password, prompt names what for
+	Secret(prompt string) (string, error)
+
+	// Confirm asks a yes or no question
+	Confirm(question string) (bool, error)
+}
+
+// errNoTerminal is returned by the terminal prompter for secrets when
+// stdin isn't a terminal, so that callers can say where else to give them
+var errNoTerminal = errors.New("no terminal to ask on")
+
+var prompter Prompter = terminalPrompter{}
+
+// SetPrompter replaces the terminal prompts for every command
+func SetPrompter(p Prompter) {
+	prompter = p
+}
+
+type terminalPrompter struct{}
+
+func (terminalPrompter) Secret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !terminal.IsTerminal(fd) {
+		return "", errNoTerminal
+	}
+
+	fmt.Printf("%s: ", prompt)
+	secret, err := terminal.ReadPassword(fd)
+	fmt.Println()
+	return string(secret), err
+}
+
+// Confirm reads the answer from stdin even when it isn't a terminal, so
+// that scripts can pipe in yes
+func (terminalPrompter) Confirm(question string) (bool, error) {
+	return askYesNo(question, os.Stdin, os.Stdout), nil
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+const rebootTimeout = time.Minute * 5
+
+// rebootRequired checks the markers Debian and Ubuntu leave behind, and
+// needs-restarting on RHEL-like hosts, which exits 1 when a reboot is due
+func rebootRequired(operator kssh.Operator, escalation string) bool {
+	command := "if [ -f /var/run/reboot-required ]; then echo yes; " +
+		"elif command -v needs-restarting > /dev/null 2>&1 && ! " + escalation + "needs-restarting -r > /dev/null 2>&1; then echo yes; " +
+		"else echo no; fi"
+
+	res, err := operator.ExecuteSilent(command)
+	return err == nil && strings.TrimSpace(string(res.StdOut)) == "yes"
+}
+
+// handleReboot reports when the host needs a reboot after the install.
+// With --reboot-if-required, the host is rebooted and a new connection is
+// returned once it is back, after the old one has been closed. The returned
+// close function is always safe to call.
+func handleReboot(ctx context.Context, operator kssh.Operator, closeOperator func(), host string, port int, user, sshKeyPath, service string, opts installOptions) (kssh.Operator, func(), error) {
+	if !rebootRequired(operator, opts.Escalation) {
+		return operator, closeOperator, nil
+	}
+
+	if !opts.RebootIfRequired {
+		warn(msgRebootRequired, host)
+		return operator, closeOperator, nil
+	}
+
+	fmt.Println(message(msgRebooting, host))
+
+	// The connection drops as the host goes down, so the error is expected
+	operator.ExecuteSilent(opts.Escalation + "reboot")
+	closeOperator()
+	select {
+	case <-ctx.Done():
+		return nil, func() {}, ctx.Err()
+	case <-time.After(time.Second * 10):
+	}
+
+	operator, closeOperator, err := waitForSSH(ctx, host, port, user, sshKeyPath, rebootTimeout)
+	if err != nil {
+		return nil, func() {}, err
+	}
+
+	// A user who needs a password for sudo needs it on the new connection
+	// too, it is cached so this doesn't ask again
+	if _, err := escalationPrefix(operator, opts.Sudo); err != nil {
+		closeOperator()
+		return nil, func() {}, err
+	}
+
+	fmt.Println(message(msgRebootBack, host, service))
+	if err := waitForService(ctx, operator, service, serviceStartTimeout, opts.Escalation); err != nil {
+		closeOperator()
+		return nil, func() {}, err
+	}
+
+	return operator, closeOperator, nil
+}
@@ -0,0 +1,310 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const (
+	recordConfigMap  = "k3sup-record"
+	recordServerPath = "/var/lib/rancher/k3s/server/k3sup-record.json"
+)
+
+// clusterRecord is an account of how a cluster was built, signed with the
+// SSH key used to build it so that auditors can tell it hasn't been edited
+type clusterRecord struct {
+	K3supVersion string            `json:"k3supVersion"`
+	Name         string            `json:"name,omitempty"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	Created      time.Time         `json:"created"`
+	Updated      time.Time         `json:"updated"`
+	Nodes        []recordNode      `json:"nodes"`
+	Signature    *recordSignature  `json:"signature,omitempty"`
+}
+
+// recordNode is one install or join. Args holds the k3s flags, but never
+// the join token or datastore endpoint, which are passed in the environment.
+type recordNode struct {
+	Host        string            `json:"host"`
+	Role        string            `json:"role"`
+	K3s         string            `json:"k3s"`
+	Args        string            `json:"args"`
+	InstalledAt time.Time         `json:"installedAt"`
+	Artifacts   map[string]string `json:"artifacts,omitempty"`
+}
+
+type recordSignature struct {
+	PublicKey string `json:"publicKey"`
+	Format    string `json:"format"`
+	Blob      []byte `json:"blob"`
+}
+
+func addRecordFlags(command *cobra.Command) {
+	command.Flags().String("record", "", "Add this node to a signed record of how the cluster was built, kept in this JSON file")
+	command.Flags().Bool("record-in-cluster", false, "With --record, also keep the record in the "+recordConfigMap+" ConfigMap in kube-system")
+}
+
+func MakeRecord() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "record",
+		Short: "Check the signed record of how a cluster was built",
+		Long: `Check the signed record of how a cluster was built, which install and
+join write with --record. The record lists each node with its k3s version,
+flags, uploaded files and when it was installed, and is signed with the
+SSH key which built the cluster.`,
+		Example:      `  k3sup record verify cluster-record.json --public-key ~/.ssh/id_rsa.pub`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeRecordVerify())
+	return command
+}
+
+func makeRecordVerify() *cobra.Command {
+	var command = &cobra.Command{
+		Use:          "verify FILE",
+		Short:        "Verify the signature of a record and print its nodes",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("public-key", "", "Only accept a record signed by this public key, e.g. ~/.ssh/id_rsa.pub")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		publicKeyPath, _ := command.Flags().GetString("public-key")
+
+		record, err := readRecord(expandPath(args[0]))
+		if err != nil {
+			return err
+		}
+		if record.Signature == nil {
+			return fmt.Errorf("%s is not signed", args[0])
+		}
+
+		signer, err := record.verify()
+		if err != nil {
+			return err
+		}
+
+		if len(publicKeyPath) > 0 {
+			data, err := ioutil.ReadFile(expandPath(publicKeyPath))
+			if err != nil {
+				return err
+			}
+			want, _, _, _, err := ssh.ParseAuthorizedKey(data)
+			if err != nil {
+				return errors.Wrapf(err, "unable to parse %s", publicKeyPath)
+			}
+			if !bytes.Equal(want.Marshal(), signer.Marshal()) {
+				return fmt.Errorf("%s was signed by %s, not by %s", args[0], ssh.FingerprintSHA256(signer), publicKeyPath)
+			}
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "HOST\tROLE\tK3S\tINSTALLED\tARGS")
+		for _, node := range record.Nodes {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", node.Host, node.Role, node.K3s, node.InstalledAt.Format(time.RFC3339), node.Args)
+		}
+		w.Flush()
+
+		fmt.Printf("Signature OK, signed by %s\n", ssh.FingerprintSHA256(signer))
+		return nil
+	}
+
+	return command
+}
+
+// updateRecord adds node to the record at path, replacing an earlier entry
+// for the same host, and signs it again. An existing record must still
+// verify, so that an edited record isn't signed over. The identity of the
+// cluster is kept when it is given.
+func updateRecord(path, sshKeyPath string, node recordNode, identity clusterIdentity) (clusterRecord, error) {
+	record := clusterRecord{Created: time.Now().UTC()}
+	if _, err := os.Stat(path); err == nil {
+		if record, err = readRecord(path); err != nil {
+			return record, err
+		}
+		if record.Signature != nil {
+			if _, err := record.verify(); err != nil {
+				return record, errors.Wrapf(err, "not updating %s", path)
+			}
+		}
+	}
+
+	if err := checkRecordIdentity(record, identity); err != nil {
+		return record, errors.Wrapf(err, "not updating %s", path)
+	}
+	if len(identity.Name) > 0 {
+		record.Name = identity.Name
+	}
+	if len(identity.Labels) > 0 {
+		record.Labels = identity.Labels
+	}
+
+	nodes := []recordNode{}
+	for _, existing := range record.Nodes {
+		if existing.Host != node.Host {
+			nodes = append(nodes, existing)
+		}
+	}
+	record.Nodes = append(nodes, node)
+	record.K3supVersion = k3supVersion()
+	record.Updated = time.Now().UTC()
+
+	if err := record.sign(sshKeyPath); err != nil {
+		return record, errors.Wrap(err, "unable to sign the record")
+	}
+
+	data, _ := json.MarshalIndent(record, "", "  ")
+	if err := writeConfig(path, append(data, '\n'), true); err != nil {
+		return record, err
+	}
+	fmt.Printf("Recorded %s in %s\n", node.Host, path)
+	return record, nil
+}
+
+// storeRecord keeps a copy of the record on the server and in a ConfigMap,
+// so that the cluster carries its own history
+func storeRecord(operator kssh.Operator, record clusterRecord, escalation string) error {
+	data, _ := json.MarshalIndent(record, "", "  ")
+	if err := uploadFile(context.Background(), operator, bytes.NewReader(data), recordServerPath, "0600", escalation); err != nil {
+		return err
+	}
+
+	command := fmt.Sprintf("%[1]sk3s kubectl create configmap %[2]s -n kube-system --from-file=record.json=%[3]s"+
+		" --dry-run -o yaml | %[1]sk3s kubectl apply -f -", escalation, recordConfigMap, recordServerPath)
+	return runClusterInfoCommand(operator, command)
+}
+
+func readRecord(path string) (clusterRecord, error) {
+	record := clusterRecord{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return record, err
+	}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return record, errors.Wrapf(err, "unable to parse %s", path)
+	}
+	return record, nil
+}
+
+// payload is what is signed, the record without its signature
+func (r clusterRecord) payload() []byte {
+	r.Signature = nil
+	data, _ := json.Marshal(r)
+	return data
+}
+
+// verify checks the signature against the public key stored with it and
+// returns that key
+func (r clusterRecord) verify() (ssh.PublicKey, error) {
+	key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(r.Signature.PublicKey))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse the public key of the signature")
+	}
+
+	signature := &ssh.Signature{Format: r.Signature.Format, Blob: r.Signature.Blob}
+	if err := key.Verify(r.payload(), signature); err != nil {
+		return nil, fmt.Errorf("the signature doesn't match, the record has been changed since it was signed")
+	}
+	return key, nil
+}
+
+// sign signs the record with the private key at keyPath, or with the
+// matching key in the SSH agent when it is encrypted
+func (r *clusterRecord) sign(keyPath string) error {
+	payload := r.payload()
+
+	key, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err != nil {
+		if err.Error() != "ssh: cannot decode encrypted private keys" {
+			return err
+		}
+		signature, err := signWithAgent(keyPath+".pub", payload)
+		if err != nil {
+			return err
+		}
+		if signature != nil {
+			r.Signature = signature
+			return nil
+		}
+
+		passphrase, err := prompter.Secret(fmt.Sprintf("Enter passphrase for '%s'", keyPath))
+		if err != nil {
+			return fmt.Errorf("unable to ask for the passphrase: %s", err)
+		}
+		if signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase)); err != nil {
+			return err
+		}
+	}
+
+	signature, err := signer.Sign(rand.Reader, payload)
+	if err != nil {
+		return err
+	}
+	r.Signature = &recordSignature{
+		PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(signer.PublicKey()))),
+		Format:    signature.Format,
+		Blob:      signature.Blob,
+	}
+	return nil
+}
+
+// signWithAgent signs payload with the agent's key which matches the public
+// key at publicKeyPath, returning nil when there is no agent or no match
+func signWithAgent(publicKeyPath string, payload []byte) (*recordSignature, error) {
+	conn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
+	if err != nil {
+		return nil, nil
+	}
+	defer conn.Close()
+
+	pubkey, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, nil
+	}
+	want, _, _, _, err := ssh.ParseAuthorizedKey(pubkey)
+	if err != nil {
+		return nil, nil
+	}
+
+	signers, err := agent.NewClient(conn).Signers()
+	if err != nil {
+		return nil, err
+	}
+	for _, signer := range signers {
+		if !bytes.Equal(signer.PublicKey().Marshal(), want.Marshal()) {
+			continue
+		}
+		signature, err := signer.Sign(rand.Reader, payload)
+		if err != nil {
+			return nil, err
+		}
+		return &recordSignature{
+			PublicKey: strings.TrimSpace(string(ssh.MarshalAuthorizedKey(want))),
+			Format:    signature.Format,
+			Blob:      signature.Blob,
+		}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+func Test_clusterRecord_SignAndVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-record")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	keyPath := path.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	record := clusterRecord{Nodes: []recordNode{{Host: "192.168.0.100", Role: "server", Args: "--no-deploy traefik"}}}
+	if err := record.sign(keyPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := record.verify(); err != nil {
+		t.Errorf("want the signed record to verify, got %s", err)
+	}
+
+	record.Nodes[0].Args = "--no-deploy servicelb"
+	if _, err := record.verify(); err == nil {
+		t.Errorf("want an error once the record is changed")
+	}
+}
@@ -0,0 +1,175 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The k3s release URLs on GitHub are variables so that tests can serve them
+var (
+	// k3sReleaseURL is a single k3s release, by tag
+	k3sReleaseURL = "https://api.github.com/repos/k3s-io/k3s/releases/tags/%s"
+
+	// k3sReleasesURL lists the k3s releases, newest first
+	k3sReleasesURL = "https://api.github.com/repos/k3s-io/k3s/releases?per_page=100"
+
+	// k3sDownloadURL is a file attached to a k3s release
+	k3sDownloadURL = "https://github.com/k3s-io/k3s/releases/download/%s/%s"
+)
+
+// releasePages caps how far back the release list is read
+const releasePages = 5
+
+var nextPage = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// k3sRelease is a k3s release on GitHub and the files attached to it
+type k3sRelease struct {
+	Tag        string    `json:"tag_name"`
+	Prerelease bool      `json:"prerelease"`
+	Published  time.Time `json:"published_at"`
+	Assets     []struct {
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+// hasAsset reports whether the release has a file with this name
+func (r k3sRelease) hasAsset(name string) bool {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseBinary names the k3s release asset for the host's architecture
+// and the asset holding its checksum
+func releaseBinary(arch string) (string, string, error) {
+	switch arch {
+	case "x86_64", "amd64":
+		return "k3s", "sha256sum-amd64.txt", nil
+	case "aarch64", "arm64":
+		return "k3s-arm64", "sha256sum-arm64.txt", nil
+	case "armv6l", "armv7l", "armhf":
+		return "k3s-armhf", "sha256sum-arm.txt", nil
+	default:
+		return "", "", fmt.Errorf("no k3s release for %s", arch)
+	}
+}
+
+// checkK3sRelease makes sure version is a k3s release before anything is
+// installed, since a typo otherwise fails on the host with a curl error.
+// When GitHub can't be reached the install goes ahead with a warning.
+func checkK3sRelease(version string) error {
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Get(fmt.Sprintf(k3sReleaseURL, url.PathEscape(version)))
+	if err == nil {
+		res.Body.Close()
+		switch res.StatusCode {
+		case http.StatusOK:
+			return nil
+		case http.StatusNotFound:
+		default:
+			err = fmt.Errorf("GitHub returned %s", res.Status)
+		}
+	}
+	if err != nil {
+		warn(msgReleaseCheck, version, err)
+		return nil
+	}
+
+	// Only a version which isn't a release pays for the list, to suggest
+	// what was meant
+	list, err := k3sReleases()
+	if err != nil || len(list) == 0 {
+		return fmt.Errorf("k3s %s is not a release", version)
+	}
+
+	releases := []string{}
+	for _, release := range list {
+		releases = append(releases, release.Tag)
+	}
+
+	suggestions := similarReleases(version, releases, 3)
+	if len(suggestions) == 0 {
+		return fmt.Errorf("k3s %s is not a release, the latest is %s", version, releases[0])
+	}
+	return fmt.Errorf("k3s %s is not a release, did you mean %s?", version, strings.Join(suggestions, ", "))
+}
+
+// k3sReleases returns the k3s releases, newest first
+func k3sReleases() ([]k3sRelease, error) {
+	client := &http.Client{Timeout: time.Second * 10}
+
+	releases := []k3sRelease{}
+	next := k3sReleasesURL
+	for page := 0; page < releasePages && len(next) > 0; page++ {
+		res, err := client.Get(next)
+		if err != nil {
+			return nil, err
+		}
+
+		if res.StatusCode != http.StatusOK {
+			res.Body.Close()
+			return nil, fmt.Errorf("GitHub returned %s", res.Status)
+		}
+
+		list := []k3sRelease{}
+		err = json.NewDecoder(res.Body).Decode(&list)
+		res.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse the release list: %s", err)
+		}
+
+		releases = append(releases, list...)
+
+		next = ""
+		if match := nextPage.FindStringSubmatch(res.Header.Get("Link")); match != nil {
+			next = match[1]
+		}
+	}
+	return releases, nil
+}
+
+// similarReleases picks up to n releases of the same minor version as
+// version, those sharing the longest prefix with it first. Among equals the
+// newest comes first, as it does in releases.
+func similarReleases(version string, releases []string, n int) []string {
+	want, ok := parseK3sVersion(version)
+
+	score := map[string]int{}
+	for _, release := range releases {
+		score[release] = commonPrefix(version, release)
+		if got, parsed := parseK3sVersion(release); ok && parsed && got[0] == want[0] && got[1] == want[1] {
+			score[release] += 1000
+		}
+	}
+
+	sorted := append([]string{}, releases...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return score[sorted[i]] > score[sorted[j]]
+	})
+
+	similar := []string{}
+	for _, release := range sorted {
+		if len(similar) == n || score[release] < 1000 {
+			break
+		}
+		similar = append(similar, release)
+	}
+	return similar
+}
+
+func commonPrefix(a, b string) int {
+	i := 0
+	for i < len(a) && i < len(b) && a[i] == b[i] {
+		i++
+	}
+	return i
+}
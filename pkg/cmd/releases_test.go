@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_similarReleases(t *testing.T) {
+	releases := []string{"v1.19.5+k3s2", "v1.19.5+k3s1", "v1.19.4+k3s1", "v1.18.13+k3s1", "v1.17.15+k3s1", "v0.10.2"}
+
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"v1.19.5+k3s3", []string{"v1.19.5+k3s2", "v1.19.5+k3s1", "v1.19.4+k3s1"}},
+		{"1.18.13+k3s1", []string{"v1.18.13+k3s1"}},
+		{"v1.18.13-k3s1", []string{"v1.18.13+k3s1"}},
+		{"v1.16.0", []string{}},
+		{"v2.0.0", []string{}},
+	}
+	for _, test := range tests {
+		got := similarReleases(test.version, releases, 3)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: want %v, got %v", test.version, test.want, got)
+		}
+	}
+}
+
+func Test_checkK3sRelease(t *testing.T) {
+	listed := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tags/v1.18.4+k3s1":
+			fmt.Fprint(w, `{"tag_name": "v1.18.4+k3s1"}`)
+		case "/tags/v1.19.5+k3s3":
+			http.NotFound(w, r)
+		case "/tags/v1.20.0+k3s1":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/releases":
+			listed++
+			fmt.Fprint(w, `[{"tag_name": "v1.19.5+k3s2"}, {"tag_name": "v1.19.5+k3s1"}]`)
+		}
+	}))
+	defer server.Close()
+
+	releaseURL, releasesURL := k3sReleaseURL, k3sReleasesURL
+	k3sReleaseURL, k3sReleasesURL = server.URL+"/tags/%s", server.URL+"/releases"
+	defer func() { k3sReleaseURL, k3sReleasesURL = releaseURL, releasesURL }()
+
+	if err := checkK3sRelease("v1.18.4+k3s1"); err != nil {
+		t.Errorf("want an older release to be found, got %s", err)
+	}
+	if listed > 0 {
+		t.Errorf("want no list for a release, got %d", listed)
+	}
+
+	err := checkK3sRelease("v1.19.5+k3s3")
+	if err == nil || !strings.Contains(err.Error(), "did you mean v1.19.5+k3s2, v1.19.5+k3s1?") {
+		t.Errorf("want suggestions for a typo, got %v", err)
+	}
+
+	if err := checkK3sRelease("v1.20.0+k3s1"); err != nil {
+		t.Errorf("want the install to go ahead when GitHub fails, got %s", err)
+	}
+}
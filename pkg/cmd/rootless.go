@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+const (
+	rootlessBinaryPath     = "~/bin/k3s"
+	rootlessServicePath    = "~/.config/systemd/user/k3s-rootless.service"
+	rootlessKubeconfigPath = "~/.kube/k3s.yaml"
+)
+
+// rootlessSince is the first k3s release with --rootless
+var rootlessSince = k3sVersion{1, 17, 0}
+
+// rootlessService is the user unit suggested by k3s for rootless mode, it
+// runs k3s from the user's ~/bin
+const rootlessService = `# Managed by k3sup
+[Unit]
+Description=k3s (Rootless)
+
+[Service]
+Environment=PATH=%%h/bin:/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin
+%sExecStart=%%h/bin/k3s server --rootless %s
+ExecReload=/bin/kill -s HUP $MAINPID
+TimeoutSec=0
+RestartSec=2
+Restart=always
+StartLimitBurst=3
+StartLimitInterval=60s
+LimitNOFILE=infinity
+LimitNPROC=infinity
+LimitCORE=infinity
+TasksMax=infinity
+Delegate=yes
+Type=simple
+KillMode=mixed
+
+[Install]
+WantedBy=default.target
+`
+
+// rootlessConflicts are the install flags which need root on the host
+var rootlessConflicts = []string{
+	"k3s-binary", "k3s-commit", "k3s-config", "k3s-config-set", "airgap-images", "install-url", "install-mirror", "local-install-script", "ca-bundle", "reboot-if-required", "sudo", "escalation", "sudo-password-file",
+	"cluster", "server-role", "preset", "datastore-endpoint", "local-storage-path", "default-storage-class", "kubeconfig-ttl",
+	"token-out", "print-join", "record-in-cluster", "verify", "service-cpu-quota", "service-memory-max", "service-nice",
+}
+
+// checkRootless turns away flags which rootless mode can't honour, and k3s
+// releases without it
+func checkRootless(command *cobra.Command, opts installOptions) error {
+	for _, name := range rootlessConflicts {
+		if command.Flags().Changed(name) {
+			return fmt.Errorf("--%s can't be used with --rootless", name)
+		}
+	}
+
+	version, ok := parseK3sVersion(opts.Version)
+	if !ok || !version.atLeast(rootlessSince) {
+		return fmt.Errorf("--rootless needs k3s %s or newer, choose one with --k3s-version", rootlessSince)
+	}
+	return nil
+}
+
+// installRootless runs k3s as the SSH user with a systemd user service,
+// without sudo. The release binary is downloaded to ~/bin and checked
+// against the release's checksums, since the k3s installer needs root.
+func installRootless(ctx context.Context, operator kssh.Operator, opts installOptions, serverArgs, token string) error {
+	arch, err := hostArch(operator)
+	if err != nil {
+		return err
+	}
+	binary, sums, err := releaseBinary(arch)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Downloading k3s %s to %s\n", opts.Version, rootlessBinaryPath)
+	download := fmt.Sprintf(`set -e
+mkdir -p ~/bin ~/.config/systemd/user
+%s > ~/bin/k3s.download
+want=$(%s | grep ' %s$' | cut -d' ' -f1)
+got=$(sha256sum ~/bin/k3s.download | cut -d' ' -f1)
+if [ -z "$want" ] || [ "$want" != "$got" ]; then rm -f ~/bin/k3s.download; echo "sha256 does not match the release" >&2; exit 1; fi
+chmod 0755 ~/bin/k3s.download && mv ~/bin/k3s.download %s`,
+		downloadCommand(fmt.Sprintf(k3sDownloadURL, opts.Version, binary)),
+		downloadCommand(fmt.Sprintf(k3sDownloadURL, opts.Version, sums)),
+		binary, rootlessBinaryPath)
+	if res, err := operator.ExecuteContext(ctx, download, nil, false); err != nil {
+		return fmt.Errorf("unable to download k3s: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	environment := ""
+	if len(token) > 0 {
+		environment = fmt.Sprintf("Environment=K3S_TOKEN=%s\n", token)
+	}
+	unit := fmt.Sprintf(rootlessService, environment, serverArgs)
+	if res, err := operator.ExecuteWithStdin("cat > "+rootlessServicePath, strings.NewReader(unit)); err != nil {
+		return fmt.Errorf("unable to write %s: %s %s", rootlessServicePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	start := "systemctl --user daemon-reload && systemctl --user enable k3s-rootless && systemctl --user restart k3s-rootless"
+	if res, err := operator.ExecuteContext(ctx, start, nil, true); err != nil {
+		return fmt.Errorf("unable to start k3s-rootless: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	if res, _ := operator.ExecuteSilent("loginctl show-user \"$(id -un)\" -p Linger"); strings.TrimSpace(string(res.StdOut)) != "Linger=yes" {
+		warn(msgRootlessLinger)
+	}
+
+	fmt.Println("Waiting for k3s to write the kubeconfig")
+	return waitFor(time.Minute*2, func() bool {
+		_, err := operator.ExecuteSilent("test -s " + rootlessKubeconfigPath)
+		return err == nil
+	})
+}
@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/alexellis/k3sup/pkg/preflight"
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// remoteScript is a shell script k3sup runs on hosts. Bump Version when
+// changing Body, so that two scripts never go by one name.
+type remoteScript struct {
+	Name    string
+	Version int
+	Body    string
+}
+
+func (s remoteScript) checksum() string {
+	sum := sha256.Sum256([]byte(s.Body))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s remoteScript) fileName() string {
+	return fmt.Sprintf("%s-v%d.sh", s.Name, s.Version)
+}
+
+// remoteScripts are listed by k3sup scripts
+var remoteScripts = []remoteScript{
+	cleanScript,
+	nodeTokenScript,
+	watchdogStartScript,
+	watchdogRemoveScript,
+	journaldRestartScript,
+	rotateLogsScript,
+	hostsScript,
+	hostnameScript,
+	etcdctlScript,
+}
+
+// runScript uploads script, checks it arrived intact and runs it with
+// args, all in one command so that a half-written script never runs. The
+// script is kept in a directory made for this run, which only the SSH user
+// can write to, so that nobody can swap it before it runs as root. prefix
+// is the escalation the script runs with, such as "sudo ".
+func runScript(operator kssh.Operator, script remoteScript, prefix string, args ...string) (kssh.CommandRes, error) {
+	quoted := []string{}
+	for _, arg := range args {
+		quoted = append(quoted, kssh.ShellQuote(arg))
+	}
+
+	command := fmt.Sprintf(`dir=$(mktemp -d /tmp/k3sup-script.XXXXXX) && [ -O "$dir" ] && chmod 700 "$dir" || exit 1
+script="$dir/%[1]s"
+cat > "$script" && echo "%[2]s  $script" | sha256sum -c > /dev/null && %[3]ssh "$script" %[4]s
+status=$?
+rm -rf "$dir"
+exit $status`, script.fileName(), script.checksum(), prefix, strings.Join(quoted, " "))
+	return operator.ExecuteWithStdin(command, strings.NewReader(script.Body))
+}
+
+var notScriptName = regexp.MustCompile(`[^a-z0-9]+`)
+
+// conflictScript is the cleanup of a conflict found by preflight
+func conflictScript(conflict preflight.Conflict) remoteScript {
+	name := strings.Trim(notScriptName.ReplaceAllString(strings.ToLower(conflict.Name), "-"), "-")
+	return remoteScript{Name: "cleanup-" + name, Version: 2, Body: conflict.Cleanup}
+}
+
+func MakeScripts() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "scripts [name]",
+		Short: "List the scripts k3sup runs on hosts",
+		Long: `List the scripts k3sup runs on hosts, with their versions and sha256
+checksums, or print one of them. A script is uploaded to a directory of
+its own on the host and checked against its checksum before it runs, so
+what ran on a host is always the script printed here.`,
+		Example: `  k3sup scripts
+  k3sup scripts clean`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		scripts := append([]remoteScript{}, remoteScripts...)
+		sort.Slice(scripts, func(i, j int) bool { return scripts[i].Name < scripts[j].Name })
+
+		if len(args) == 0 {
+			for _, script := range scripts {
+				fmt.Printf("%-18s v%-3d %s\n", script.Name, script.Version, script.checksum())
+			}
+			return nil
+		}
+
+		for _, script := range scripts {
+			if script.Name == args[0] {
+				fmt.Printf("# %s v%d sha256 %s\n%s\n", script.Name, script.Version, script.checksum(), script.Body)
+				return nil
+			}
+		}
+		return fmt.Errorf("no script is called %q, list them with k3sup scripts", args[0])
+	}
+
+	return command
+}
@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_runScript(t *testing.T) {
+	script := remoteScript{Name: "greet", Version: 2, Body: `echo "hello $1"`}
+
+	res, err := runScript(kssh.NewLocalOperator(), script, "", "it's me")
+	if err != nil {
+		t.Fatalf("%s %s", err, res.StdErr)
+	}
+	if got := strings.TrimSpace(string(res.StdOut)); got != "hello it's me" {
+		t.Errorf("want the script to run with its argument, got %q", got)
+	}
+}
+
+func Test_runScript_Corrupted(t *testing.T) {
+	script := remoteScript{Name: "greet", Version: 3, Body: `echo hello`}
+
+	// The checksum of another script stands in for a corrupted upload
+	command := ""
+	fake := kssh.NewFakeOperator(func(c string, stdin []byte) (kssh.CommandRes, error) {
+		command = c
+		return kssh.CommandRes{}, nil
+	})
+	runScript(fake, script, "sudo ")
+	corrupted := strings.Replace(command, script.checksum(), remoteScript{Body: "echo bye"}.checksum(), 1)
+
+	res, err := kssh.NewLocalOperator().ExecuteWithStdin(strings.Replace(corrupted, "sudo ", "", 1), strings.NewReader(script.Body))
+	if err == nil || strings.Contains(string(res.StdOut), "hello") {
+		t.Errorf("want a script which doesn't match its checksum not to run, got %q", res.StdOut)
+	}
+}
+
+func Test_remoteScripts_Parse(t *testing.T) {
+	names := map[string]bool{}
+	for _, script := range remoteScripts {
+		if names[script.Name] {
+			t.Errorf("want one script called %s", script.Name)
+		}
+		names[script.Name] = true
+
+		res, err := kssh.NewLocalOperator().ExecuteWithStdin("sh -n", strings.NewReader(script.Body))
+		if err != nil {
+			t.Errorf("%s: %s %s", script.Name, err, res.StdErr)
+		}
+	}
+}
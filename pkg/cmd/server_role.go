@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// --server-role values, a server runs both etcd and the control plane
+// unless it is split into one of them
+const (
+	serverRoleAll          = "all"
+	serverRoleEtcd         = "etcd"
+	serverRoleControlPlane = "control-plane"
+)
+
+// splitRolesSince is the first k3s release which can disable etcd or the
+// control plane components of a server
+var splitRolesSince = k3sVersion{1, 19, 5}
+
+// serverRoleArgs are the k3s flags of each split role. The taints keep
+// workloads off the servers, etcd nodes also evict them since the
+// apiserver isn't there to manage them.
+var serverRoleArgs = map[string]string{
+	serverRoleAll:          "",
+	serverRoleEtcd:         "--disable-apiserver --disable-controller-manager --disable-scheduler --node-taint node-role.kubernetes.io/etcd=true:NoExecute",
+	serverRoleControlPlane: "--disable-etcd --node-taint node-role.kubernetes.io/control-plane=true:NoSchedule",
+}
+
+func addServerRoleFlag(command *cobra.Command, usage string) {
+	command.Flags().String("server-role", serverRoleAll, usage)
+}
+
+// getServerRoleArgs checks --server-role and returns the k3s flags for it
+func getServerRoleArgs(command *cobra.Command, opts installOptions) (string, string, error) {
+	role, _ := command.Flags().GetString("server-role")
+
+	args, ok := serverRoleArgs[role]
+	if !ok {
+		return role, "", fmt.Errorf("unknown --server-role %q, use all, etcd or control-plane", role)
+	}
+	if role == serverRoleAll {
+		return role, "", nil
+	}
+
+	version, parsed := parseK3sVersion(opts.Version)
+	if parsed && len(opts.Commit) == 0 && len(opts.Binary) == 0 && !version.atLeast(splitRolesSince) {
+		return role, "", fmt.Errorf("--server-role %s needs k3s %s or newer, choose one with --k3s-version", role, splitRolesSince)
+	}
+	return role, args, nil
+}
+
+// etcdServerConflicts are the install flags which need the apiserver on
+// the server
+var etcdServerConflicts = []string{"local-storage-path", "default-storage-class", "kubeconfig-ttl", "record-in-cluster", "verify"}
+
+// checkEtcdServer makes sure the first server of a split cluster runs etcd
+// for the others to join, and isn't asked for what only the apiserver does
+func checkEtcdServer(command *cobra.Command, role string, cluster bool) error {
+	switch role {
+	case serverRoleControlPlane:
+		return fmt.Errorf("a control-plane server has no etcd, install the first server with --server-role etcd and join control-plane servers to it with k3sup join --server")
+	case serverRoleEtcd:
+		if !cluster {
+			return fmt.Errorf("--server-role etcd needs --cluster")
+		}
+		for _, name := range etcdServerConflicts {
+			if command.Flags().Changed(name) {
+				return fmt.Errorf("--%s can't be used with --server-role etcd, which leaves out the apiserver", name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,30 @@
+package cmd
+
+import (
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_getServerRoleArgs(t *testing.T) {
+	command := &cobra.Command{}
+	addServerRoleFlag(command, "Role")
+	command.Flags().Set("server-role", serverRoleControlPlane)
+
+	_, args, err := getServerRoleArgs(command, installOptions{Version: "v1.20.0+k3s2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args != serverRoleArgs[serverRoleControlPlane] {
+		t.Errorf("want the control-plane flags, got %q", args)
+	}
+
+	if _, _, err := getServerRoleArgs(command, installOptions{Version: "v1.19.1+k3s1"}); err == nil {
+		t.Errorf("want an error for a release which can't split roles")
+	}
+
+	command.Flags().Set("server-role", "worker")
+	if _, _, err := getServerRoleArgs(command, installOptions{Version: "v1.20.0+k3s2"}); err == nil {
+		t.Errorf("want an error for an unknown role")
+	}
+}
@@ -0,0 +1,265 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+func MakeSmoke() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "smoke",
+		Short: "Run a smoke test suite against a cluster",
+		Long: `Run a smoke test suite against a cluster using kubectl and the saved
+kubeconfig. A temporary namespace is created for the checks and removed
+afterwards.`,
+		Example: `  k3sup smoke --kubeconfig ./kubeconfig
+  k3sup smoke --skip pvc`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("kubeconfig", "kubeconfig", "Path to the kubeconfig of the cluster to test")
+	command.Flags().String("context", "", "Context within the kubeconfig to use, defaults to the current context")
+	command.Flags().String("image", "alpine:3.10", "Image to pull for the image-pull check")
+	command.Flags().StringSlice("skip", []string{}, "Checks to skip: pod, dns, image-pull, pvc")
+	command.Flags().Duration("timeout", time.Minute*2, "Time allowed for each check")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		kubeconfig, _ := command.Flags().GetString("kubeconfig")
+		context, _ := command.Flags().GetString("context")
+		image, _ := command.Flags().GetString("image")
+		skip, _ := command.Flags().GetStringSlice("skip")
+		timeout, _ := command.Flags().GetDuration("timeout")
+
+		if _, err := exec.LookPath("kubectl"); err != nil {
+			return fmt.Errorf("kubectl is required for smoke tests: %s", err)
+		}
+
+		absPath, _ := filepath.Abs(expandPath(kubeconfig))
+		if _, err := os.Stat(absPath); err != nil {
+			return fmt.Errorf("unable to read kubeconfig %s: %s", absPath, err)
+		}
+
+		rand.Seed(time.Now().UnixNano())
+		s := &smokeTest{
+			kubeconfig: absPath,
+			context:    context,
+			namespace:  fmt.Sprintf("k3sup-smoke-%d", rand.Intn(100000)),
+			image:      image,
+			timeout:    timeout,
+		}
+
+		return s.run(skip)
+	}
+
+	return command
+}
+
+type smokeCheck struct {
+	name string
+	run  func() error
+}
+
+type smokeTest struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	image      string
+	timeout    time.Duration
+}
+
+func (s *smokeTest) run(skip []string) error {
+	skipped := map[string]bool{}
+	for _, name := range skip {
+		skipped[name] = true
+	}
+
+	fmt.Printf("Creating namespace: %s\n", s.namespace)
+	if _, err := s.kubectl(nil, "create", "namespace", s.namespace); err != nil {
+		fmt.Printf("FAIL\tnamespace\t%s\n", err)
+		return fmt.Errorf("smoke tests failed: unable to create namespace")
+	}
+	fmt.Printf("PASS\tnamespace\n")
+
+	defer func() {
+		fmt.Printf("Removing namespace: %s\n", s.namespace)
+		s.kubectl(nil, "delete", "namespace", s.namespace, "--wait=false")
+	}()
+
+	checks := []smokeCheck{
+		{"pod", s.checkPod},
+		{"dns", s.checkDNS},
+		{"image-pull", s.checkImagePull},
+		{"pvc", s.checkPVC},
+	}
+
+	failed := 0
+	for _, check := range checks {
+		if skipped[check.name] {
+			fmt.Printf("SKIP\t%s\n", check.name)
+			continue
+		}
+
+		start := time.Now()
+		if err := check.run(); err != nil {
+			failed++
+			fmt.Printf("FAIL\t%s\t%s\n", check.name, err)
+			continue
+		}
+		fmt.Printf("PASS\t%s\t(%s)\n", check.name, time.Since(start).Round(time.Millisecond))
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d smoke test(s) failed", failed)
+	}
+	return nil
+}
+
+func (s *smokeTest) checkPod() error {
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-pod
+spec:
+  containers:
+  - name: busybox
+    image: busybox:1.31
+    command: ["sleep", "3600"]
+`
+	if _, err := s.kubectl([]byte(manifest), "apply", "-f", "-"); err != nil {
+		return err
+	}
+	return s.waitFor("pod/smoke-pod", "Running")
+}
+
+// checkDNS resolves the API server's service from a pod of its own, so it
+// doesn't depend on the pod check having run. The pod goes with the
+// namespace.
+func (s *smokeTest) checkDNS() error {
+	manifest := `apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-dns
+spec:
+  restartPolicy: Never
+  containers:
+  - name: nslookup
+    image: busybox:1.31
+    command: ["nslookup", "kubernetes.default.svc.cluster.local"]
+`
+	if _, err := s.kubectl([]byte(manifest), "apply", "-f", "-"); err != nil {
+		return err
+	}
+	if err := s.waitFor("pod/smoke-dns", "Succeeded"); err != nil {
+		out, _ := s.kubectl(nil, "logs", "smoke-dns")
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func (s *smokeTest) checkImagePull() error {
+	manifest := fmt.Sprintf(`apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-image-pull
+spec:
+  restartPolicy: Never
+  containers:
+  - name: pull
+    image: %s
+    imagePullPolicy: Always
+    command: ["true"]
+`, s.image)
+	if _, err := s.kubectl([]byte(manifest), "apply", "-f", "-"); err != nil {
+		return err
+	}
+	return s.waitFor("pod/smoke-image-pull", "Succeeded")
+}
+
+// checkPVC binds a volume on the local-path StorageClass, which uses
+// WaitForFirstConsumer, so a pod is needed to bind the claim
+func (s *smokeTest) checkPVC() error {
+	manifest := `apiVersion: v1
+kind: PersistentVolumeClaim
+metadata:
+  name: smoke-pvc
+spec:
+  accessModes: ["ReadWriteOnce"]
+  storageClassName: local-path
+  resources:
+    requests:
+      storage: 16Mi
+---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: smoke-pvc
+spec:
+  restartPolicy: Never
+  containers:
+  - name: write
+    image: busybox:1.31
+    command: ["sh", "-c", "echo ok > /data/ok"]
+    volumeMounts:
+    - name: data
+      mountPath: /data
+  volumes:
+  - name: data
+    persistentVolumeClaim:
+      claimName: smoke-pvc
+`
+	if _, err := s.kubectl([]byte(manifest), "apply", "-f", "-"); err != nil {
+		return err
+	}
+	if err := s.waitFor("pvc/smoke-pvc", "Bound"); err != nil {
+		return err
+	}
+	return s.waitFor("pod/smoke-pvc", "Succeeded")
+}
+
+// waitFor polls the .status.phase of a resource until it matches phase
+func (s *smokeTest) waitFor(resource, phase string) error {
+	deadline := time.Now().Add(s.timeout)
+	last := ""
+
+	for time.Now().Before(deadline) {
+		out, err := s.kubectl(nil, "get", resource, "-o", "jsonpath={.status.phase}")
+		if err == nil {
+			last = strings.TrimSpace(string(out))
+			if last == phase {
+				return nil
+			}
+			if last == "Failed" {
+				break
+			}
+		}
+		time.Sleep(time.Second * 2)
+	}
+
+	return fmt.Errorf("%s did not reach phase %s, last phase: %q", resource, phase, last)
+}
+
+func (s *smokeTest) kubectl(stdin []byte, args ...string) ([]byte, error) {
+	global := []string{"--kubeconfig", s.kubeconfig, "--namespace", s.namespace}
+	if len(s.context) > 0 {
+		global = append(global, "--context", s.context)
+	}
+
+	cmd := exec.Command("kubectl", append(global, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return out, fmt.Errorf("kubectl %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+	return out, nil
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/crypto/ssh"
+)
+
+// sshCiphers, sshKeyExchanges and sshMACs are every algorithm the ssh
+// library can use as a client, including legacy ones it doesn't offer
+// unless asked to
+var (
+	sshCiphers = []string{
+		"aes128-gcm@openssh.com", "chacha20-poly1305@openssh.com",
+		"aes128-ctr", "aes192-ctr", "aes256-ctr",
+		"arcfour256", "arcfour128", "arcfour", "aes128-cbc", "3des-cbc",
+	}
+	sshKeyExchanges = []string{
+		"curve25519-sha256@libssh.org",
+		"ecdh-sha2-nistp256", "ecdh-sha2-nistp384", "ecdh-sha2-nistp521",
+		"diffie-hellman-group14-sha1", "diffie-hellman-group1-sha1",
+		"diffie-hellman-group-exchange-sha256", "diffie-hellman-group-exchange-sha1",
+	}
+	sshMACs = []string{
+		"hmac-sha2-256-etm@openssh.com", "hmac-sha2-256", "hmac-sha1", "hmac-sha1-96",
+	}
+)
+
+// sshAlgorithms is what --ssh-ciphers, --ssh-kex and --ssh-macs chose for
+// every connection k3sup opens, empty lists keep the library's defaults.
+// sshHostKeyAlgorithms is only set by the fips crypto policy.
+var (
+	sshAlgorithms        ssh.Config
+	sshHostKeyAlgorithms []string
+)
+
+// AddSSHAlgorithmFlags adds the algorithm flags to every command, for old
+// routers and boards whose ssh servers only offer algorithms the defaults
+// leave out
+func AddSSHAlgorithmFlags(command *cobra.Command) {
+	command.PersistentFlags().StringSlice("ssh-ciphers", nil, "SSH ciphers to offer, in order of preference, such as aes128-cbc for old servers")
+	command.PersistentFlags().StringSlice("ssh-kex", nil, "SSH key exchanges to offer, in order of preference")
+	command.PersistentFlags().StringSlice("ssh-macs", nil, "SSH MACs to offer, in order of preference")
+
+	command.PersistentPreRunE = func(command *cobra.Command, args []string) error {
+		ciphers, _ := command.Flags().GetStringSlice("ssh-ciphers")
+		kex, _ := command.Flags().GetStringSlice("ssh-kex")
+		macs, _ := command.Flags().GetStringSlice("ssh-macs")
+
+		policy, err := cryptoPolicy()
+		if err != nil {
+			return err
+		}
+		config, err := parseSSHAlgorithms(policy, ciphers, kex, macs)
+		if err != nil {
+			return err
+		}
+		sshAlgorithms = config
+		if policy == cryptoPolicyFIPS {
+			sshHostKeyAlgorithms = fipsSSHHostKeyAlgorithms
+		}
+		return nil
+	}
+}
+
+// parseSSHAlgorithms checks the chosen algorithms against those the policy
+// allows. Under the fips policy nothing else may be offered, so the
+// approved algorithms replace the library's defaults.
+func parseSSHAlgorithms(policy string, ciphers, kex, macs []string) (ssh.Config, error) {
+	config := ssh.Config{}
+	var err error
+
+	supportedCiphers, supportedKex, supportedMACs := sshCiphers, sshKeyExchanges, sshMACs
+	if policy == cryptoPolicyFIPS {
+		supportedCiphers, supportedKex, supportedMACs = fipsSSHCiphers, fipsSSHKeyExchanges, fipsSSHMACs
+	}
+
+	if config.Ciphers, err = checkSSHAlgorithms(policy, "--ssh-ciphers", ciphers, supportedCiphers); err != nil {
+		return config, err
+	}
+	if config.KeyExchanges, err = checkSSHAlgorithms(policy, "--ssh-kex", kex, supportedKex); err != nil {
+		return config, err
+	}
+	if config.MACs, err = checkSSHAlgorithms(policy, "--ssh-macs", macs, supportedMACs); err != nil {
+		return config, err
+	}
+	return config, nil
+}
+
+func checkSSHAlgorithms(policy, flag string, chosen, supported []string) ([]string, error) {
+	algorithms := []string{}
+	for _, algorithm := range chosen {
+		algorithm = strings.TrimSpace(algorithm)
+		known := false
+		for _, name := range supported {
+			known = known || name == algorithm
+		}
+		if !known {
+			if policy == cryptoPolicyFIPS {
+				return nil, fmt.Errorf("%s %q isn't allowed by the fips crypto policy, use one of: %s", flag, algorithm, strings.Join(supported, ", "))
+			}
+			return nil, fmt.Errorf("unsupported %s %q, use one of: %s", flag, algorithm, strings.Join(supported, ", "))
+		}
+		algorithms = append(algorithms, algorithm)
+	}
+
+	switch {
+	case len(algorithms) > 0:
+		return algorithms, nil
+	case policy == cryptoPolicyFIPS:
+		return supported, nil
+	default:
+		return nil, nil
+	}
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import "testing"
+
+func Test_parseSSHAlgorithms_KeepsDefaultsWhenEmpty(t *testing.T) {
+	config, err := parseSSHAlgorithms(cryptoPolicyDefault, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Ciphers != nil || config.KeyExchanges != nil || config.MACs != nil {
+		t.Errorf("want the library defaults, got %v", config)
+	}
+}
+
+func Test_parseSSHAlgorithms_KeepsOrder(t *testing.T) {
+	config, err := parseSSHAlgorithms(cryptoPolicyDefault, []string{"3des-cbc", "aes128-cbc"}, []string{"diffie-hellman-group1-sha1"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Ciphers) != 2 || config.Ciphers[0] != "3des-cbc" || config.Ciphers[1] != "aes128-cbc" {
+		t.Errorf("want 3des-cbc then aes128-cbc, got %v", config.Ciphers)
+	}
+	if len(config.KeyExchanges) != 1 || config.KeyExchanges[0] != "diffie-hellman-group1-sha1" {
+		t.Errorf("want diffie-hellman-group1-sha1, got %v", config.KeyExchanges)
+	}
+}
+
+func Test_parseSSHAlgorithms_RejectsUnknown(t *testing.T) {
+	if _, err := parseSSHAlgorithms(cryptoPolicyDefault, []string{"blowfish-cbc"}, nil, nil); err == nil {
+		t.Errorf("want an error for a cipher the library doesn't have")
+	}
+}
+
+func Test_parseSSHAlgorithms_FIPSOffersOnlyApproved(t *testing.T) {
+	config, err := parseSSHAlgorithms(cryptoPolicyFIPS, nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Ciphers) != len(fipsSSHCiphers) || len(config.KeyExchanges) != len(fipsSSHKeyExchanges) || len(config.MACs) != len(fipsSSHMACs) {
+		t.Errorf("want the approved algorithms instead of the library defaults, got %v", config)
+	}
+
+	if _, err := parseSSHAlgorithms(cryptoPolicyFIPS, nil, []string{"diffie-hellman-group1-sha1"}, nil); err == nil {
+		t.Errorf("want an error for a key exchange the fips policy doesn't allow")
+	}
+}
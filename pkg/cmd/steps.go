@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// stepTimer runs the steps of a command within an overall deadline and an
+// optional budget per step, and records how long each one took
+type stepTimer struct {
+	deadline time.Time
+	budgets  map[string]time.Duration
+	records  []stepRecord
+}
+
+type stepRecord struct {
+	name     string
+	duration time.Duration
+	budget   time.Duration
+	err      error
+}
+
+// addTimeoutFlags registers --timeout and --step-timeout, naming the steps
+// the command is made up of in the help text
+func addTimeoutFlags(command *cobra.Command, steps ...string) {
+	command.Flags().Duration("timeout", 0, "Fail if the whole command takes longer than this, 0 for no limit")
+	command.Flags().StringToString("step-timeout", map[string]string{}, "Time budget per step, e.g. "+steps[0]+"=30s. Steps: "+strings.Join(steps, ", "))
+}
+
+func newStepTimer(command *cobra.Command, steps ...string) (*stepTimer, error) {
+	timeout, _ := command.Flags().GetDuration("timeout")
+	stepTimeouts, _ := command.Flags().GetStringToString("step-timeout")
+
+	budgets, err := parseStepBudgets(stepTimeouts, steps)
+	if err != nil {
+		return nil, err
+	}
+
+	t := &stepTimer{budgets: budgets}
+	if timeout > 0 {
+		t.deadline = time.Now().Add(timeout)
+	}
+	return t, nil
+}
+
+func parseStepBudgets(values map[string]string, steps []string) (map[string]time.Duration, error) {
+	known := map[string]bool{}
+	for _, step := range steps {
+		known[step] = true
+	}
+
+	budgets := map[string]time.Duration{}
+	for name, value := range values {
+		if !known[name] {
+			return nil, fmt.Errorf("unknown step %q in --step-timeout, use one of: %s", name, strings.Join(steps, ", "))
+		}
+
+		budget, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid --step-timeout for %s: %s", name, err)
+		}
+		budgets[name] = budget
+	}
+	return budgets, nil
+}
+
+// run runs fn as the named step. fn is given a context which is cancelled
+// when the step outlives its budget or the overall deadline, and run waits
+// for fn to return, so nothing of a step is left running on the host once
+// the command moves on or releases its lock.
+func (t *stepTimer) run(name string, fn func(ctx context.Context) error) error {
+	limit, reason := t.limit(name)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	if limit > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), limit)
+	}
+	defer cancel()
+
+	start := time.Now()
+	err := fn(ctx)
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("step %s did not finish within %s", name, reason)
+	}
+
+	t.records = append(t.records, stepRecord{
+		name:     name,
+		duration: time.Since(start),
+		budget:   t.budgets[name],
+		err:      err,
+	})
+	return err
+}
+
+// limit is the time left for a step, being the tighter of its own budget
+// and what remains of the overall timeout
+func (t *stepTimer) limit(name string) (time.Duration, string) {
+	limit, reason := t.budgets[name], fmt.Sprintf("its budget of %s", t.budgets[name])
+
+	if !t.deadline.IsZero() {
+		remaining := time.Until(t.deadline)
+		if remaining < 0 {
+			remaining = time.Nanosecond
+		}
+		if limit == 0 || remaining < limit {
+			limit, reason = remaining, "the overall --timeout"
+		}
+	}
+	return limit, reason
+}
+
+// printSummary prints how long each step took against its budget
+func (t *stepTimer) printSummary() {
+	if len(t.records) == 0 {
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STEP\tDURATION\tBUDGET\tRESULT")
+
+	total := time.Duration(0)
+	for _, record := range t.records {
+		budget, result := "-", "ok"
+		if record.budget > 0 {
+			budget = record.budget.String()
+		}
+		if record.err != nil {
+			result = "failed"
+		}
+		total += record.duration
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", record.name, record.duration.Round(time.Millisecond), budget, result)
+	}
+	fmt.Fprintf(w, "total\t%s\t\t\n", total.Round(time.Millisecond))
+	w.Flush()
+}
@@ -0,0 +1,93 @@
+package cmd
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_parseStepBudgets(t *testing.T) {
+	steps := []string{"connect", "install", "fetch"}
+
+	budgets, err := parseStepBudgets(map[string]string{"install": "5m", "fetch": "30s"}, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if budgets["install"] != time.Minute*5 || budgets["fetch"] != time.Second*30 {
+		t.Errorf("want install=5m and fetch=30s, got %v", budgets)
+	}
+	if _, ok := budgets["connect"]; ok {
+		t.Errorf("want no budget for connect, got %s", budgets["connect"])
+	}
+
+	if _, err := parseStepBudgets(map[string]string{"verify": "1m"}, steps); err == nil || !strings.Contains(err.Error(), "unknown step") {
+		t.Errorf("want an unknown step error, got %v", err)
+	}
+	if _, err := parseStepBudgets(map[string]string{"install": "five"}, steps); err == nil || !strings.Contains(err.Error(), "invalid --step-timeout") {
+		t.Errorf("want an invalid duration error, got %v", err)
+	}
+}
+
+func Test_stepTimer_limit(t *testing.T) {
+	cases := []struct {
+		name       string
+		budget     time.Duration
+		remaining  time.Duration
+		wantReason string
+	}{
+		{"no limit", 0, 0, ""},
+		{"budget only", time.Minute, 0, "its budget of 1m0s"},
+		{"deadline only", 0, time.Minute, "the overall --timeout"},
+		{"budget is tighter", time.Minute, time.Hour, "its budget of 1m0s"},
+		{"deadline is tighter", time.Hour, time.Minute, "the overall --timeout"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			timer := &stepTimer{budgets: map[string]time.Duration{}}
+			if tc.budget > 0 {
+				timer.budgets["install"] = tc.budget
+			}
+			if tc.remaining > 0 {
+				timer.deadline = time.Now().Add(tc.remaining)
+			}
+
+			limit, reason := timer.limit("install")
+			if tc.budget == 0 && tc.remaining == 0 {
+				if limit != 0 {
+					t.Errorf("want no limit, got %s", limit)
+				}
+				return
+			}
+			if limit <= 0 || limit > time.Minute {
+				t.Errorf("want a limit of at most 1m, got %s", limit)
+			}
+			if reason != tc.wantReason {
+				t.Errorf("want %q, got %q", tc.wantReason, reason)
+			}
+		})
+	}
+}
+
+func Test_stepTimer_run_CancelsAndWaits(t *testing.T) {
+	timer := &stepTimer{budgets: map[string]time.Duration{"install": time.Millisecond * 50}}
+
+	returned := false
+	err := timer.run("install", func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(time.Millisecond * 20)
+		returned = true
+		return ctx.Err()
+	})
+
+	if err == nil || err.Error() != "step install did not finish within its budget of 50ms" {
+		t.Errorf("want the budget error, got %v", err)
+	}
+	if !returned {
+		t.Errorf("want run to wait for the step to return")
+	}
+	if len(timer.records) != 1 || timer.records[0].err != err {
+		t.Errorf("want the step recorded as failed, got %v", timer.records)
+	}
+}
@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+const defaultClassAnnotation = "storageclass.kubernetes.io/is-default-class"
+
+// localStoragePathSince is the first k3s release with the
+// --default-local-storage-path server flag
+var localStoragePathSince = k3sVersion{1, 0, 0}
+
+// localStorageArgs returns the server flag which sets the data directory of
+// the local-path provisioner, when the k3s being installed has one. Commits
+// and custom binaries are assumed to be recent.
+func localStorageArgs(dir string, opts installOptions) string {
+	if len(dir) == 0 {
+		return ""
+	}
+
+	if version, ok := parseK3sVersion(opts.Version); ok && len(opts.Commit) == 0 && len(opts.Binary) == 0 && !version.atLeast(localStoragePathSince) {
+		return ""
+	}
+	return "--default-local-storage-path " + dir
+}
+
+// configureLocalStorage points the local-path provisioner at dir on
+// releases without --default-local-storage-path by editing its ConfigMap.
+// k3s re-applies its bundled manifests on restart, so the change may have
+// to be made again.
+func configureLocalStorage(operator kssh.Operator, dir string, opts installOptions) error {
+	if len(dir) == 0 || len(localStorageArgs(dir, opts)) > 0 {
+		return nil
+	}
+
+	config, _ := json.Marshal(map[string]interface{}{
+		"nodePathMap": []map[string]interface{}{
+			{"node": "DEFAULT_PATH_FOR_NON_LISTED_NODES", "paths": []string{dir}},
+		},
+	})
+	patch, _ := json.Marshal(map[string]map[string]string{"data": {"config.json": string(config)}})
+
+	command := fmt.Sprintf("%sk3s kubectl patch configmap local-path-config -n kube-system --type merge -p '%s'", opts.Escalation, string(patch))
+	if err := runClusterInfoCommand(operator, command); err != nil {
+		return fmt.Errorf("unable to set the local-path data directory: %s", err)
+	}
+
+	warn(msgLocalPathConfig, opts.Version)
+	return nil
+}
+
+// setDefaultStorageClass marks class as the default StorageClass and
+// removes the mark from every other class, since Kubernetes refuses to
+// pick a default when more than one is marked
+func setDefaultStorageClass(operator kssh.Operator, class, escalation string) error {
+	res, err := operator.ExecuteSilent(escalation + "k3s kubectl get storageclass -o name")
+	if err != nil {
+		return fmt.Errorf("unable to list storage classes: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+
+	names := []string{}
+	found := false
+	for _, name := range strings.Fields(string(res.StdOut)) {
+		name = name[strings.LastIndex(name, "/")+1:]
+		names = append(names, name)
+		found = found || name == class
+	}
+
+	if !found {
+		return fmt.Errorf("storage class %q was not found, install its provisioner first", class)
+	}
+
+	for _, name := range names {
+		command := fmt.Sprintf("%sk3s kubectl annotate storageclass %s --overwrite %s=%t", escalation, name, defaultClassAnnotation, name == class)
+		if res, err := operator.ExecuteSilent(command); err != nil {
+			return fmt.Errorf("unable to annotate storage class %s: %s %s", name, err, strings.TrimSpace(string(res.StdErr)))
+		}
+	}
+	return nil
+}
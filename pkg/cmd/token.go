@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+// nodeTokenPath is where a server keeps the token agents join with
+const nodeTokenPath = "/var/lib/rancher/k3s/server/node-token"
+
+// nodeTokenScript prints the token, failing clearly when the host isn't a
+// server
+var nodeTokenScript = remoteScript{Name: "node-token", Version: 1, Body: `if [ ! -f ` + nodeTokenPath + ` ]; then
+  echo "` + nodeTokenPath + ` doesn't exist, is k3s installed as a server?" >&2
+  exit 1
+fi
+cat ` + nodeTokenPath}
+
+// tokenEnv holds the cluster token when neither --token nor --token-file
+// is given, which keeps it out of the shell history
+const tokenEnv = "K3SUP_TOKEN"
+
+func addTokenFlags(command *cobra.Command, purpose string) {
+	command.Flags().String("token", "", purpose+", also read from $"+tokenEnv)
+	command.Flags().String("token-file", "", "File holding the token, instead of --token")
+}
+
+// getToken returns the token given with --token, --token-file or
+// $K3SUP_TOKEN, and an empty string when there is none
+func getToken(command *cobra.Command) (string, error) {
+	token, _ := command.Flags().GetString("token")
+	tokenFile, _ := command.Flags().GetString("token-file")
+
+	if len(token) > 0 && len(tokenFile) > 0 {
+		return "", fmt.Errorf("give only one of --token or --token-file")
+	}
+
+	if len(tokenFile) > 0 {
+		data, err := ioutil.ReadFile(expandPath(tokenFile))
+		if err != nil {
+			return "", fmt.Errorf("unable to read --token-file: %s", err)
+		}
+		token = string(data)
+	}
+	if len(token) == 0 {
+		token = os.Getenv(tokenEnv)
+	}
+
+	token = strings.TrimSpace(token)
+	if strings.ContainsAny(token, "' \t\n") {
+		return "", fmt.Errorf("the token can't contain quotes or whitespace")
+	}
+	return token, nil
+}
+
+// tokenEnvironment passes the token to the installer, which writes it to the
+// service's environment file rather than to its command line
+func tokenEnvironment(token string) string {
+	if len(token) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("K3S_TOKEN='%s'", token)
+}
+
+// fetchNodeToken reads the token agents join with from a server,
+// escalation is the prefix from escalationPrefix
+func fetchNodeToken(operator kssh.Operator, escalation string) (string, error) {
+	res, err := runScript(operator, nodeTokenScript, escalation)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s: %s %s", nodeTokenPath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	token := strings.TrimSpace(string(res.StdOut))
+	if len(token) == 0 {
+		return "", fmt.Errorf("%s is empty", nodeTokenPath)
+	}
+	return token, nil
+}
+
+// joinCommand is the k3sup join command which adds an agent to the server
+// at host. Without tokenFile, join reads the token from the server itself.
+func joinCommand(host, user, sshKey, tokenFile string) string {
+	server := "--server-host " + host
+	if net.ParseIP(host) != nil {
+		server = "--server-ip " + host
+	}
+
+	command := fmt.Sprintf("k3sup join --ip AGENT_IP %s --user %s", server, user)
+	if sshKey != "~/.ssh/id_rsa" {
+		command += " --ssh-key " + sshKey
+	}
+	if len(tokenFile) > 0 {
+		command += " --token-file " + tokenFile
+	}
+	return command
+}
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func Test_getToken(t *testing.T) {
+	file, err := ioutil.TempFile("", "k3sup-token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString("K10abc::server:def\n")
+	file.Close()
+
+	command := &cobra.Command{}
+	addTokenFlags(command, "Token")
+	command.Flags().Set("token-file", file.Name())
+
+	got, err := getToken(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "K10abc::server:def" {
+		t.Errorf("want the token from the file without its newline, got %q", got)
+	}
+
+	command.Flags().Set("token", "secret")
+	if _, err := getToken(command); err == nil {
+		t.Errorf("want an error when both --token and --token-file are given")
+	}
+}
+
+func Test_joinCommand(t *testing.T) {
+	got := joinCommand("192.168.0.100", "pi", "~/.ssh/id_rsa", "node-token")
+	want := "k3sup join --ip AGENT_IP --server-ip 192.168.0.100 --user pi --token-file node-token"
+	if got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+
+	got = joinCommand("k3s.example.com", "root", "~/.ssh/k3s", "")
+	want = "k3sup join --ip AGENT_IP --server-host k3s.example.com --user root --ssh-key ~/.ssh/k3s"
+	if got != want {
+		t.Errorf("want %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,263 @@
+package cmd
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+const (
+	// Files bigger than largeUploadSize, such as a k3s binary, are sent in
+	// chunks of uploadChunkSize so that a transfer over a slow or flaky link
+	// shows progress and can be resumed
+	largeUploadSize = 16 * 1024 * 1024
+	uploadChunkSize = 4 * 1024 * 1024
+)
+
+// uploadFile writes data to a root-owned path on the remote host, creating
+// the parent directory when it is missing. escalation is the prefix from
+// escalationPrefix.
+func uploadFile(ctx context.Context, operator kssh.Operator, data io.Reader, remotePath, mode, escalation string) error {
+	if seeker, ok := data.(io.ReadSeeker); ok {
+		size, err := readerSize(seeker)
+		if err != nil {
+			return err
+		}
+		if size > largeUploadSize {
+			return uploadChunked(ctx, operator, seeker, size, remotePath, mode, escalation)
+		}
+	}
+
+	return operator.Upload(ctx, data, remotePath, mode, escalation)
+}
+
+// readerSize returns the size of data and rewinds it to the start
+func readerSize(data io.ReadSeeker) (int64, error) {
+	size, err := data.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	_, err = data.Seek(0, io.SeekStart)
+	return size, err
+}
+
+// uploadChunked appends data to remotePath.part a chunk at a time and moves
+// it into place once its checksum matches. A .part file left by an
+// interrupted upload is resumed when it holds the start of the same data.
+// Each chunk is uploaded to remotePath.chunk and then appended, gzipped
+// when the host has gzip to decompress it.
+func uploadChunked(ctx context.Context, operator kssh.Operator, data io.ReadSeeker, size int64, remotePath, mode, escalation string) error {
+	partPath, chunkPath := remotePath+".part", remotePath+".chunk"
+
+	offset, err := resumeOffset(operator, data, size, partPath, escalation)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		fmt.Println(message(msgUploadResumed, remotePath, formatMiB(offset)))
+	}
+
+	appendCommand := fmt.Sprintf("%[1]ssh -c 'cat %[2]s >> %[3]s && rm -f %[2]s'", escalation, chunkPath, partPath)
+	res, _ := operator.ExecuteSilent("command -v gzip")
+	compress := len(strings.TrimSpace(string(res.StdOut))) > 0
+	if compress {
+		appendCommand = fmt.Sprintf("%[1]ssh -c 'gzip -dc %[2]s >> %[3]s && rm -f %[2]s'", escalation, chunkPath, partPath)
+	}
+
+	progress := &uploadProgress{name: path.Base(remotePath), size: size, done: offset, start: time.Now(), resumed: offset}
+	for offset < size {
+		if _, err := data.Seek(offset, io.SeekStart); err != nil {
+			return err
+		}
+
+		length := int64(uploadChunkSize)
+		if size-offset < length {
+			length = size - offset
+		}
+
+		var chunk io.Reader = progress.wrap(io.LimitReader(data, length))
+		if compress {
+			chunk = gzipReader(chunk)
+		}
+
+		if err := operator.Upload(ctx, chunk, chunkPath, "0600", escalation); err != nil {
+			fmt.Println()
+			return fmt.Errorf("%s, re-run to resume", err)
+		}
+		if res, err := operator.ExecuteSilent(appendCommand); err != nil {
+			fmt.Println()
+			return fmt.Errorf("unable to upload %s, re-run to resume: %s %s", remotePath, err, strings.TrimSpace(string(res.StdErr)))
+		}
+		offset += length
+	}
+	fmt.Println()
+
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	want, err := localChecksum(data, size)
+	if err != nil {
+		return err
+	}
+	if got := remoteChecksum(operator, partPath, escalation); got != want {
+		operator.ExecuteSilent(fmt.Sprintf("%srm -f '%s'", escalation, partPath))
+		return fmt.Errorf("the upload of %s was corrupted, re-run to send it again", remotePath)
+	}
+
+	res, err = operator.ExecuteSilent(fmt.Sprintf("%[1]schmod %[2]s '%[3]s' && %[1]smv '%[3]s' '%[4]s'", escalation, mode, partPath, remotePath))
+	if err != nil {
+		return fmt.Errorf("unable to move %s into place: %s %s", remotePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// resumeOffset returns how much of data is already in partPath, removing
+// it when it is not the start of data
+func resumeOffset(operator kssh.Operator, data io.ReadSeeker, size int64, partPath, escalation string) (int64, error) {
+	res, _ := operator.ExecuteSilent(fmt.Sprintf("%sstat -c %%s '%s' 2>/dev/null", escalation, partPath))
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(res.StdOut)), 10, 64)
+	if err != nil || offset == 0 {
+		return 0, nil
+	}
+
+	if offset <= size {
+		want, err := localChecksum(data, offset)
+		if err != nil {
+			return 0, err
+		}
+		res, _ = operator.ExecuteSilent(fmt.Sprintf("%shead -c %d '%s' | sha256sum", escalation, offset, partPath))
+		if fields := strings.Fields(string(res.StdOut)); len(fields) > 0 && fields[0] == want {
+			return offset, nil
+		}
+	}
+
+	if res, err := operator.ExecuteSilent(fmt.Sprintf("%srm -f '%s'", escalation, partPath)); err != nil {
+		return 0, fmt.Errorf("unable to remove %s: %s %s", partPath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return 0, nil
+}
+
+// localChecksum returns the sha256 of the first length bytes of data
+func localChecksum(data io.ReadSeeker, length int64) (string, error) {
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.CopyN(hash, data, length); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// remoteChecksum returns the sha256 of a file on the remote host, or an
+// empty string when it doesn't exist
+func remoteChecksum(operator kssh.Operator, remotePath, escalation string) string {
+	res, _ := operator.ExecuteSilent(fmt.Sprintf("%ssha256sum '%s' 2>/dev/null", escalation, remotePath))
+	fields := strings.Fields(string(res.StdOut))
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[0]
+}
+
+// remoteExists is a cheap check that a file recorded in the node cache
+// wasn't removed, by k3s-uninstall.sh for instance
+func remoteExists(operator kssh.Operator, remotePath, escalation string) bool {
+	_, err := operator.ExecuteSilent(fmt.Sprintf("%stest -f '%s'", escalation, remotePath))
+	return err == nil
+}
+
+// uploadIfChanged uploads data only when the remote file differs from it,
+// which saves re-sending large files such as a custom k3s binary over slow
+// links. The node cache is checked first, so the remote file only has to be
+// hashed when k3sup has no record of it. It reports whether the file was
+// uploaded.
+func uploadIfChanged(ctx context.Context, operator kssh.Operator, data io.ReadSeeker, remotePath, mode, escalation string) (bool, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, data); err != nil {
+		return false, err
+	}
+	if _, err := data.Seek(0, io.SeekStart); err != nil {
+		return false, err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	cache := readNodeCache(operator)
+	if (cache.Artifacts[remotePath] == sum && remoteExists(operator, remotePath, escalation)) || remoteChecksum(operator, remotePath, escalation) == sum {
+		fmt.Println(message(msgUploadUnchanged, remotePath))
+		updateNodeCache(operator, func(cache *nodeCache) { cache.Artifacts[remotePath] = sum })
+		return false, nil
+	}
+
+	if err := uploadFile(ctx, operator, data, remotePath, mode, escalation); err != nil {
+		return false, err
+	}
+	updateNodeCache(operator, func(cache *nodeCache) { cache.Artifacts[remotePath] = sum })
+	return true, nil
+}
+
+func gzipReader(data io.Reader) io.Reader {
+	reader, writer := io.Pipe()
+	go func() {
+		compressor := gzip.NewWriter(writer)
+		_, err := io.Copy(compressor, data)
+		if err == nil {
+			err = compressor.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+	return reader
+}
+
+// uploadProgress prints how much of an upload has been read, on one line
+type uploadProgress struct {
+	name    string
+	size    int64
+	done    int64
+	resumed int64
+	start   time.Time
+	printed time.Time
+}
+
+func (p *uploadProgress) wrap(data io.Reader) io.Reader {
+	return &progressReader{data: data, progress: p}
+}
+
+func (p *uploadProgress) add(n int) {
+	p.done += int64(n)
+	if time.Since(p.printed) < time.Millisecond*200 && p.done < p.size {
+		return
+	}
+	p.printed = time.Now()
+
+	rate := 0.0
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.done-p.resumed) / elapsed
+	}
+	fmt.Printf("\r%s   ", message(msgUploadProgress, p.name, formatMiB(p.done), formatMiB(p.size),
+		p.done*100/p.size, formatMiB(int64(rate))))
+}
+
+type progressReader struct {
+	data     io.Reader
+	progress *uploadProgress
+}
+
+func (r *progressReader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	r.progress.add(n)
+	return n, err
+}
+
+func formatMiB(size int64) string {
+	return fmt.Sprintf("%.1fMiB", float64(size)/1024/1024)
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func Test_gzipReader_RoundTrips(t *testing.T) {
+	data := bytes.Repeat([]byte("k3s"), uploadChunkSize)
+
+	compressed, err := ioutil.ReadAll(gzipReader(bytes.NewReader(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compressed) >= len(data) {
+		t.Errorf("want the data compressed, got %d bytes from %d", len(compressed), len(data))
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Errorf("want the data back after decompressing")
+	}
+}
+
+func Test_localChecksum_OfPrefix(t *testing.T) {
+	data := bytes.NewReader([]byte("hello world"))
+
+	got, err := localChecksum(data, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// sha256 of "hello"
+	want := "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	if got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func Test_uploadFile_ThroughOperator(t *testing.T) {
+	operator := kssh.NewFakeOperator(nil)
+
+	if err := uploadFile(context.Background(), operator, strings.NewReader("write-kubeconfig-mode: 644\n"), k3sConfigPath, "0600", "doas "); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(operator.Files[k3sConfigPath]); got != "write-kubeconfig-mode: 644\n" {
+		t.Errorf("want the file uploaded with Operator.Upload, got %q", got)
+	}
+}
@@ -0,0 +1,190 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/certs"
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/spf13/cobra"
+)
+
+const (
+	serverCACertPath = "/var/lib/rancher/k3s/server/tls/server-ca.crt"
+	clientCACertPath = "/var/lib/rancher/k3s/server/tls/client-ca.crt"
+	clientCAKeyPath  = "/var/lib/rancher/k3s/server/tls/client-ca.key"
+)
+
+func MakeUser() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "user",
+		Short: "Manage users with their own client certificates",
+		Long: `Manage users with their own client certificates, signed by the client CA
+of a k3s server, so that each person gets a personal kubeconfig rather
+than sharing the admin credentials.`,
+		Example:      `  k3sup user add alice --groups dev --ip 192.168.0.100`,
+		SilenceUsage: true,
+	}
+
+	command.AddCommand(makeUserAdd())
+
+	return command
+}
+
+func makeUserAdd() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "add NAME",
+		Short: "Create a client certificate and kubeconfig for a user",
+		Long: `Create a client certificate and kubeconfig for a user. The client CA is
+read from the server over SSH and the certificate is signed locally, the
+CA key is never written to disk. Access must then be granted to the user
+or their groups with RBAC.`,
+		Example: `  k3sup user add alice --groups dev --ip 192.168.0.100
+  kubectl create rolebinding dev-edit --clusterrole edit --group dev -n dev`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", nil, "Public IP of the k3s server")
+	command.Flags().String("host", "", "DNS name of the k3s server, instead of --ip")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().StringSlice("groups", []string{}, "Groups for the user, mapped to organizations in the certificate")
+	command.Flags().Duration("expires", time.Hour*24*365, "How long the certificate is valid for")
+	command.Flags().String("local-path", "", "Local path to save the kubeconfig file, defaults to kubeconfig-NAME")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		name := args[0]
+
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		groups, _ := command.Flags().GetStringSlice("groups")
+		expires, _ := command.Flags().GetDuration("expires")
+		localPath, _ := command.Flags().GetString("local-path")
+
+		if len(host) == 0 {
+			return fmt.Errorf("give the server with --ip or --host")
+		}
+
+		if len(localPath) == 0 {
+			localPath = "kubeconfig-" + name
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		fmt.Printf("ssh -i %s %s@%s\n", sshKeyPath, user, host)
+
+		operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+		if err != nil {
+			return err
+		}
+
+		defer printWarnings(os.Stdout)
+		defer closeOperator()
+
+		escalation, err := escalationPrefix(operator, defaultSudo)
+		if err != nil {
+			return err
+		}
+
+		kubeconfig, err := newUserKubeconfig(operator, host, name, groups, expires, escalation)
+		if err != nil {
+			return err
+		}
+
+		absPath, _ := filepath.Abs(localPath)
+		unlock, err := lockFile(absPath, lockTimeout)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		if err := writeConfig(absPath, kubeconfig, false); err != nil {
+			return err
+		}
+
+		fmt.Printf("Created user %s in groups [%s], valid until %s\n", name, strings.Join(groups, ", "), time.Now().Add(expires).Format(time.RFC3339))
+		return nil
+	}
+
+	return command
+}
+
+// newUserKubeconfig signs a client certificate with the server's client CA
+// and renders a kubeconfig which uses it
+func newUserKubeconfig(operator kssh.Operator, serverIP, name string, groups []string, ttl time.Duration, escalation string) ([]byte, error) {
+	return newClientKubeconfig(operator, serverIP, name, name, groups, ttl, escalation)
+}
+
+// newClientKubeconfig is newUserKubeconfig with a separate name for the
+// user entry in the kubeconfig, which can differ from the certificate's
+// common name
+func newClientKubeconfig(operator kssh.Operator, serverIP, name, kubeconfigUser string, groups []string, ttl time.Duration, escalation string) ([]byte, error) {
+	serverCA, err := readRemoteFile(operator, serverCACertPath, escalation)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCA, err := readRemoteFile(operator, clientCACertPath, escalation)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAKey, err := readRemoteFile(operator, clientCAKeyPath, escalation)
+	if err != nil {
+		return nil, err
+	}
+
+	certPEM, keyPEM, err := certs.NewClientCertificate(clientCA, clientCAKey, name, groups, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	server := serverURL(serverIP)
+	return clientKubeconfig(server, kubeconfigUser, serverCA, certPEM, keyPEM), nil
+}
+
+// readRemoteFile reads a root-owned file without echoing it to the console
+func readRemoteFile(operator kssh.Operator, path, escalation string) ([]byte, error) {
+	file := bytes.Buffer{}
+	if err := operator.Download(context.Background(), path, &file, escalation); err != nil {
+		return nil, err
+	}
+	return file.Bytes(), nil
+}
+
+func clientKubeconfig(server, user string, caPEM, certPEM, keyPEM []byte) []byte {
+	encode := base64.StdEncoding.EncodeToString
+
+	return []byte(fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- cluster:
+    certificate-authority-data: %s
+    server: %s
+  name: default
+contexts:
+- context:
+    cluster: default
+    user: %s
+  name: %s
+current-context: %s
+preferences: {}
+users:
+- name: %s
+  user:
+    client-certificate-data: %s
+    client-key-data: %s
+`, encode(caPEM), server, user, user, user, user, encode(certPEM), encode(keyPEM)))
+}
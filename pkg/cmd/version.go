@@ -13,6 +13,11 @@ var (
 )
 
 func PrintK3supASCIIArt() {
+	if !colorOutput() {
+		fmt.Print(k3supFigletStr)
+		return
+	}
+
 	k3supLogo := aec.RedF.Apply(k3supFigletStr)
 	fmt.Print(k3supLogo)
 }
@@ -32,6 +37,12 @@ func MakeVersion() *cobra.Command {
 			fmt.Println("Version:", Version)
 		}
 		fmt.Println("Git Commit:", GitCommit)
+
+		policy, err := cryptoPolicy()
+		if err != nil {
+			policy = err.Error()
+		}
+		fmt.Println("Crypto Policy:", policy)
 	}
 	return command
 }
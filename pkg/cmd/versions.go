@@ -0,0 +1,185 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+// releaseArches are the architectures k3s publishes a binary for, by the
+// names uname and Go use
+var releaseArches = []string{"amd64", "arm64", "armhf"}
+
+// minorChannel matches channels named after a minor version, such as v1.19
+var minorChannel = regexp.MustCompile(`^v[0-9]+\.[0-9]+$`)
+
+// k3sChannel is a k3s update channel and the release it points at
+type k3sChannel struct {
+	Name    string `json:"name"`
+	Version string `json:"latest"`
+}
+
+func MakeVersions() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "versions",
+		Short: "List the k3s releases which can be installed",
+		Long: `List the k3s releases on GitHub which can be passed to install and join
+with --k3s-version, newest first, or the update channels and the release
+each points at with --channels.`,
+		Example: `  k3sup versions
+  k3sup versions --channel v1.19 --arch arm64
+  k3sup versions --channels`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+	}
+
+	command.Flags().String("channel", "", "Only list releases in a channel, such as stable, latest or v1.19")
+	command.Flags().String("arch", "", "Only list releases with a binary for this architecture: amd64, arm64 or armhf")
+	command.Flags().Bool("prereleases", false, "Also list release candidates")
+	command.Flags().Int("limit", 20, "Most releases to list, 0 for all")
+	command.Flags().Bool("channels", false, "List the update channels instead of releases")
+	command.Flags().StringP("output", "o", "table", "Output format: table or json")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		channel, _ := command.Flags().GetString("channel")
+		arch, _ := command.Flags().GetString("arch")
+		prereleases, _ := command.Flags().GetBool("prereleases")
+		limit, _ := command.Flags().GetInt("limit")
+		listChannels, _ := command.Flags().GetBool("channels")
+		output, _ := command.Flags().GetString("output")
+
+		if output != "table" && output != "json" {
+			return fmt.Errorf("unknown output format %q, use table or json", output)
+		}
+
+		if listChannels {
+			if len(channel) > 0 || len(arch) > 0 {
+				return fmt.Errorf("--channel and --arch filter releases, they can't be used with --channels")
+			}
+			channels, err := k3sChannels()
+			if err != nil {
+				return err
+			}
+			if output == "json" {
+				out, _ := json.MarshalIndent(channels, "", "  ")
+				fmt.Println(string(out))
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "CHANNEL\tVERSION")
+			for _, c := range channels {
+				fmt.Fprintf(w, "%s\t%s\n", c.Name, c.Version)
+			}
+			return w.Flush()
+		}
+
+		binary := ""
+		if len(arch) > 0 {
+			var err error
+			if binary, _, err = releaseBinary(arch); err != nil {
+				return fmt.Errorf("unknown --arch %q, use %s", arch, strings.Join(releaseArches, ", "))
+			}
+		}
+
+		include, err := channelFilter(channel)
+		if err != nil {
+			return err
+		}
+
+		releases, err := k3sReleases()
+		if err != nil {
+			return fmt.Errorf("unable to list the k3s releases: %s", err)
+		}
+
+		listed := []k3sRelease{}
+		for _, release := range releases {
+			if limit > 0 && len(listed) == limit {
+				break
+			}
+			if (release.Prerelease && !prereleases) || !include(release.Tag) {
+				continue
+			}
+			if len(binary) > 0 && !release.hasAsset(binary) {
+				continue
+			}
+			listed = append(listed, release)
+		}
+
+		if output == "json" {
+			versions := []string{}
+			for _, release := range listed {
+				versions = append(versions, release.Tag)
+			}
+			out, _ := json.MarshalIndent(versions, "", "  ")
+			fmt.Println(string(out))
+			return nil
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tPUBLISHED\tARCH")
+		for _, release := range listed {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", release.Tag, release.Published.Format("2006-01-02"), strings.Join(releaseArchesOf(release), ","))
+		}
+		return w.Flush()
+	}
+
+	return command
+}
+
+// channelFilter matches the releases in a channel. A minor version channel
+// holds each of its patch releases, and other channels the one release they
+// point at now.
+func channelFilter(channel string) (func(string) bool, error) {
+	switch {
+	case len(channel) == 0:
+		return func(string) bool { return true }, nil
+	case minorChannel.MatchString(channel):
+		return func(tag string) bool { return strings.HasPrefix(tag, channel+".") }, nil
+	}
+
+	version, err := resolveK3sChannel(channel)
+	if err != nil {
+		return nil, err
+	}
+	return func(tag string) bool { return tag == version }, nil
+}
+
+// releaseArchesOf lists the architectures a release has a binary for
+func releaseArchesOf(release k3sRelease) []string {
+	arches := []string{}
+	for _, arch := range releaseArches {
+		if binary, _, _ := releaseBinary(arch); release.hasAsset(binary) {
+			arches = append(arches, arch)
+		}
+	}
+	return arches
+}
+
+// k3sChannels lists the update channels which --k3s-channel takes
+func k3sChannels() ([]k3sChannel, error) {
+	client := &http.Client{Timeout: time.Second * 10}
+	res, err := client.Get(strings.TrimSuffix(k3sChannelURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the k3s channels: %s", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to list the k3s channels: %s", res.Status)
+	}
+
+	list := struct {
+		Data []k3sChannel `json:"data"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("unable to parse the k3s channels: %s", err)
+	}
+	return list.Data, nil
+}
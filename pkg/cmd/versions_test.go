@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func Test_releaseArchesOf(t *testing.T) {
+	release := k3sRelease{}
+	data := `{"tag_name": "v1.19.5+k3s2", "assets": [{"name": "k3s"}, {"name": "k3s-armhf"}, {"name": "sha256sum-amd64.txt"}]}`
+	if err := json.Unmarshal([]byte(data), &release); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"amd64", "armhf"}
+	if got := releaseArchesOf(release); !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, got %v", want, got)
+	}
+}
+
+func Test_channelFilter_minorVersion(t *testing.T) {
+	include, err := channelFilter("v1.19")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, want := range map[string]bool{"v1.19.5+k3s2": true, "v1.19.0-rc1+k3s1": true, "v1.1.0": false, "v1.190.0": false} {
+		if got := include(tag); got != want {
+			t.Errorf("%s: want %t, got %t", tag, want, got)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/alexellis/k3sup/pkg/preflight"
+	"github.com/spf13/cobra"
+)
+
+func MakeWaitSSH() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "wait-ssh",
+		Short: "Wait until a host accepts SSH and passes preflight checks",
+		Long: `Wait until a host accepts SSH logins and passes the preflight checks,
+for machines created outside of k3sup by PXE, Terraform or cloud-init.
+Failing checks are retried, since packages may still be installing.`,
+		Example: `  k3sup wait-ssh --ip 192.168.0.100 --timeout 15m && \
+    k3sup install --ip 192.168.0.100`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IP("ip", nil, "Public IP of the host")
+	command.Flags().String("host", "", "DNS name of the host, instead of --ip")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().Duration("timeout", time.Minute*15, "How long to wait before giving up")
+	command.Flags().Bool("skip-preflight", false, "Only wait for SSH logins to succeed")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		host, err := getHost(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		timeout, _ := command.Flags().GetDuration("timeout")
+		skipPreflight, _ := command.Flags().GetBool("skip-preflight")
+
+		if len(host) == 0 {
+			return fmt.Errorf("give the host with --ip or --host")
+		}
+
+		start := time.Now()
+		deadline := start.Add(timeout)
+		sshKeyPath := expandPath(sshKey)
+
+		fmt.Printf("Waiting for ssh %s@%s\n", user, host)
+		operator, closeOperator, err := waitForSSH(context.Background(), host, port, user, sshKeyPath, timeout)
+		if err != nil {
+			return err
+		}
+		defer printWarnings(os.Stdout)
+		defer closeOperator()
+
+		fmt.Printf("ssh is ready after %s\n", time.Since(start).Round(time.Second))
+		if skipPreflight {
+			return nil
+		}
+
+		for {
+			results := preflight.Run(operator)
+			if preflight.Worst(results) != preflight.Fail {
+				fmt.Printf("Preflight passed after %s\n", time.Since(start).Round(time.Second))
+				return nil
+			}
+
+			if time.Now().After(deadline) {
+				for _, result := range results {
+					if result.Status == preflight.Fail {
+						fmt.Printf("fail %s: %s\n", result.Check, result.Message)
+					}
+				}
+				return fmt.Errorf("gave up waiting for preflight checks to pass after %s", timeout)
+			}
+
+			time.Sleep(time.Second * 10)
+		}
+	}
+
+	return command
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// warning is a non-fatal finding which is collected while a command runs
+// and printed as a block at the end, where it won't be lost in the output
+type warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var collected = struct {
+	sync.Mutex
+	warnings []warning
+}{}
+
+// warn records the catalogued message id as a warning, ignoring repeats of the same message so that
+// per-host findings don't pile up on retries
+func warn(id string, args ...interface{}) {
+	w := warning{Code: id, Message: message(id, args...)}
+
+	collected.Lock()
+	defer collected.Unlock()
+
+	for _, existing := range collected.warnings {
+		if existing == w {
+			return
+		}
+	}
+	collected.warnings = append(collected.warnings, w)
+}
+
+// recordedWarnings returns the warnings recorded so far
+func recordedWarnings() []warning {
+	collected.Lock()
+	defer collected.Unlock()
+
+	return append([]warning{}, collected.warnings...)
+}
+
+// printWarnings writes the summary block, or nothing when there were no
+// warnings
+func printWarnings(w io.Writer) {
+	warnings := recordedWarnings()
+	if len(warnings) == 0 {
+		return
+	}
+
+	fmt.Fprintf(w, "\n%s\n", message(msgWarningsHeader, len(warnings)))
+	for _, warning := range warnings {
+		fmt.Fprintf(w, "  [%s] %s\n", warning.Code, warning.Message)
+	}
+}
@@ -0,0 +1,26 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func Test_warn_IgnoresRepeats(t *testing.T) {
+	collected.warnings = nil
+	defer func() { collected.warnings = nil }()
+
+	warn(msgRebootRequired, "192.168.0.100")
+	warn(msgRebootRequired, "192.168.0.100")
+	warn(msgRebootRequired, "192.168.0.101")
+
+	if got := len(recordedWarnings()); got != 2 {
+		t.Fatalf("want 2 warnings, got %d", got)
+	}
+
+	out := &bytes.Buffer{}
+	printWarnings(out)
+	if !strings.Contains(out.String(), "Warnings (2):") {
+		t.Errorf("want a summary of 2 warnings, got: %q", out.String())
+	}
+}
@@ -0,0 +1,252 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	watchdogScriptPath  = "/usr/local/bin/k3sup-watchdog"
+	watchdogServicePath = "/etc/systemd/system/k3sup-watchdog.service"
+	watchdogTimerPath   = "/etc/systemd/system/k3sup-watchdog.timer"
+	watchdogStateDir    = "/var/lib/k3sup/watchdog"
+)
+
+// watchdogStartScript starts the timer once the watchdog's files are in place
+var watchdogStartScript = remoteScript{Name: "watchdog-start", Version: 1, Body: `systemctl daemon-reload &&
+systemctl enable k3sup-watchdog.timer &&
+systemctl restart k3sup-watchdog.timer`}
+
+// watchdogRemoveScript stops the timer and removes what installWatchdog wrote
+var watchdogRemoveScript = remoteScript{Name: "watchdog-remove", Version: 1, Body: `systemctl disable --now k3sup-watchdog.timer 2> /dev/null
+rm -rf ` + watchdogScriptPath + ` ` + watchdogServicePath + ` ` + watchdogTimerPath + ` ` + watchdogStateDir + ` &&
+systemctl daemon-reload`}
+
+// watchdogScript restarts the service when systemd has given up on it,
+// which happens once it fails too often in a row, and records what it
+// saw in status.json for k3sup watchdog --status
+const watchdogScript = `#!/bin/sh
+# Managed by k3sup
+service=%s
+dir=%s
+mkdir -p $dir
+
+state=$(systemctl is-active $service)
+restarts=$(systemctl show -p NRestarts $service | cut -d= -f2)
+restarts=${restarts:-0}
+previous=$(cat $dir/restarts 2> /dev/null || echo $restarts)
+echo $restarts > $dir/restarts
+recoveries=$(cat $dir/recoveries 2> /dev/null || echo 0)
+recovered=$(cat $dir/recovered 2> /dev/null)
+
+if [ "$state" = failed ] || [ "$state" = inactive ]; then
+  logger -t k3sup-watchdog "$service is $state, restarting it"
+  systemctl reset-failed $service
+  systemctl restart $service
+  recoveries=$((recoveries + 1))
+  recovered=$(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ)
+  echo $recoveries > $dir/recoveries
+  echo $recovered > $dir/recovered
+  state=$(systemctl is-active $service)
+elif [ $((restarts - previous)) -ge %d ]; then
+  logger -t k3sup-watchdog "$service restarted $((restarts - previous)) times since the last check"
+  state=crash-looping
+fi
+
+printf '{"service":"%%s","state":"%%s","restarts":%%d,"recoveries":%%d,"lastRecovery":"%%s","checkedAt":"%%s"}\n' \
+  $service $state $restarts $recoveries "$recovered" $(date -u +%%Y-%%m-%%dT%%H:%%M:%%SZ) > $dir/status.json
+`
+
+const watchdogService = `# Managed by k3sup
+[Unit]
+Description=Restart %s when it stops
+After=%s.service
+
+[Service]
+Type=oneshot
+ExecStart=%s
+`
+
+const watchdogTimer = `# Managed by k3sup
+[Unit]
+Description=Check %s every %s
+
+[Timer]
+OnBootSec=2min
+OnUnitActiveSec=%ds
+
+[Install]
+WantedBy=timers.target
+`
+
+// watchdogStatus is what the watchdog wrote on its last check
+type watchdogStatus struct {
+	Service      string `json:"service"`
+	State        string `json:"state"`
+	Restarts     int    `json:"restarts"`
+	Recoveries   int    `json:"recoveries"`
+	LastRecovery string `json:"lastRecovery"`
+	CheckedAt    string `json:"checkedAt"`
+}
+
+func MakeWatchdog() *cobra.Command {
+	var command = &cobra.Command{
+		Use:   "watchdog",
+		Short: "Keep k3s running on unattended nodes",
+		Long: `Install a systemd timer on nodes which restarts k3s-agent when systemd
+has given up restarting it, such as after it crash-looped while the
+network was down, for edge devices nobody is watching. Each check is
+recorded on the node, and --status prints the last check of each node.`,
+		Example: `  k3sup watchdog --ip 192.168.0.101,192.168.0.102
+  k3sup watchdog --ip 192.168.0.101 --status
+  k3sup watchdog --ip 192.168.0.101 --remove`,
+		SilenceUsage: true,
+	}
+
+	command.Flags().IPSlice("ip", nil, "Public IPs of the nodes, can be repeated")
+	command.Flags().StringSlice("host", nil, "DNS names of the nodes, can be repeated")
+	command.Flags().String("user", "root", "Username for SSH login")
+	command.Flags().String("ssh-key", "~/.ssh/id_rsa", "The ssh key to use for remote login")
+	command.Flags().Int("ssh-port", 22, "The port on which to connect for ssh")
+	command.Flags().String("service", "k3s-agent", "Service to watch, use k3s for servers")
+	command.Flags().Duration("interval", time.Minute, "Time between checks")
+	command.Flags().Int("crash-loop-restarts", 5, "Report the service as crash-looping when systemd restarted it this many times between two checks")
+	command.Flags().Bool("status", false, "Print the last check of each node instead of installing the watchdog")
+	command.Flags().Bool("remove", false, "Remove the watchdog")
+
+	command.RunE = func(command *cobra.Command, args []string) error {
+		hosts, err := getHosts(command, "ip", "host")
+		if err != nil {
+			return err
+		}
+		user, _ := command.Flags().GetString("user")
+		sshKey, _ := command.Flags().GetString("ssh-key")
+		port, _ := command.Flags().GetInt("ssh-port")
+		service, _ := command.Flags().GetString("service")
+		interval, _ := command.Flags().GetDuration("interval")
+		crashLoopRestarts, _ := command.Flags().GetInt("crash-loop-restarts")
+		status, _ := command.Flags().GetBool("status")
+		remove, _ := command.Flags().GetBool("remove")
+
+		if len(hosts) == 0 {
+			return fmt.Errorf("give at least one node with --ip or --host")
+		}
+		if status && remove {
+			return fmt.Errorf("--status and --remove can't be used together")
+		}
+		if service != "k3s" && service != "k3s-agent" {
+			return fmt.Errorf("unknown --service %q, use k3s or k3s-agent", service)
+		}
+		if interval < time.Second*10 {
+			return fmt.Errorf("--interval should be at least 10s")
+		}
+
+		sshKeyPath := expandPath(sshKey)
+		defer printWarnings(os.Stdout)
+
+		if status {
+			return printWatchdogStatus(hosts, port, user, sshKeyPath)
+		}
+
+		for _, host := range hosts {
+			operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+			if err != nil {
+				return errors.Wrapf(err, "unable to connect to %s", host)
+			}
+
+			if remove {
+				fmt.Printf("Removing the watchdog from %s\n", host)
+				err = removeWatchdog(operator)
+			} else {
+				fmt.Printf("Installing the watchdog for %s on %s\n", service, host)
+				err = installWatchdog(operator, service, interval, crashLoopRestarts)
+			}
+			closeOperator()
+			if err != nil {
+				return errors.Wrapf(err, "unable to configure the watchdog on %s", host)
+			}
+		}
+		return nil
+	}
+
+	return command
+}
+
+func installWatchdog(operator kssh.Operator, service string, interval time.Duration, crashLoopRestarts int) error {
+	if res, err := operator.ExecuteSilent("command -v systemctl"); err != nil || len(res.StdOut) == 0 {
+		return fmt.Errorf("the watchdog needs systemd")
+	}
+
+	files := []struct {
+		path, content, mode string
+	}{
+		{watchdogScriptPath, fmt.Sprintf(watchdogScript, service, watchdogStateDir, crashLoopRestarts), "0755"},
+		{watchdogServicePath, fmt.Sprintf(watchdogService, service, service, watchdogScriptPath), "0644"},
+		{watchdogTimerPath, fmt.Sprintf(watchdogTimer, service, interval, int(interval.Seconds())), "0644"},
+	}
+	for _, file := range files {
+		if _, err := uploadIfChanged(context.Background(), operator, strings.NewReader(file.content), file.path, file.mode, escalationSudo+" "); err != nil {
+			return err
+		}
+	}
+
+	if res, err := runScript(operator, watchdogStartScript, escalationSudo+" "); err != nil {
+		return fmt.Errorf("unable to start the watchdog: %s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+func removeWatchdog(operator kssh.Operator) error {
+	if res, err := runScript(operator, watchdogRemoveScript, escalationSudo+" "); err != nil {
+		return fmt.Errorf("%s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+func printWatchdogStatus(hosts []string, port int, user, sshKeyPath string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "HOST\tSERVICE\tSTATE\tRESTARTS\tRECOVERIES\tLAST RECOVERY\tCHECKED")
+
+	for _, host := range hosts {
+		status, err := readWatchdogStatus(host, port, user, sshKeyPath)
+		if err != nil {
+			fmt.Fprintf(w, "%s\t-\t%s\t-\t-\t-\t-\n", host, err)
+			continue
+		}
+
+		lastRecovery := status.LastRecovery
+		if len(lastRecovery) == 0 {
+			lastRecovery = "never"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n", host, status.Service, status.State,
+			status.Restarts, status.Recoveries, lastRecovery, status.CheckedAt)
+	}
+	return w.Flush()
+}
+
+func readWatchdogStatus(host string, port int, user, sshKeyPath string) (watchdogStatus, error) {
+	status := watchdogStatus{}
+	operator, closeOperator, err := connectOperator(host, port, user, sshKeyPath)
+	if err != nil {
+		return status, fmt.Errorf("unreachable")
+	}
+	defer closeOperator()
+
+	res, err := operator.ExecuteSilent("cat " + watchdogStateDir + "/status.json")
+	if err != nil {
+		return status, fmt.Errorf("not installed")
+	}
+	if err := json.Unmarshal(res.StdOut, &status); err != nil {
+		return status, fmt.Errorf("unreadable status")
+	}
+	return status, nil
+}
@@ -0,0 +1,150 @@
+package preflight
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// now is replaced by tests
+var now = time.Now
+
+// releaseEOL is when a distro release stops getting security updates, by
+// the ID and VERSION_ID of /etc/os-release
+type releaseEOL struct {
+	ID      string
+	Version string
+	EOL     string
+	Link    string
+}
+
+// releaseEOLs are the standard support of releases k3s is commonly
+// installed on. Paid extended support isn't counted, since few homelab and
+// edge hosts have it.
+var releaseEOLs = []releaseEOL{
+	{"ubuntu", "16.04", "2021-04-30", "https://endoflife.date/ubuntu"},
+	{"ubuntu", "18.04", "2023-05-31", "https://endoflife.date/ubuntu"},
+	{"ubuntu", "20.04", "2025-05-31", "https://endoflife.date/ubuntu"},
+	{"ubuntu", "22.04", "2027-06-01", "https://endoflife.date/ubuntu"},
+	{"ubuntu", "24.04", "2029-05-31", "https://endoflife.date/ubuntu"},
+	{"debian", "9", "2020-07-06", "https://endoflife.date/debian"},
+	{"debian", "10", "2022-09-10", "https://endoflife.date/debian"},
+	{"debian", "11", "2024-08-14", "https://endoflife.date/debian"},
+	{"debian", "12", "2026-06-10", "https://endoflife.date/debian"},
+	{"raspbian", "9", "2020-07-06", "https://endoflife.date/debian"},
+	{"raspbian", "10", "2022-09-10", "https://endoflife.date/debian"},
+	{"raspbian", "11", "2024-08-14", "https://endoflife.date/debian"},
+	{"raspbian", "12", "2026-06-10", "https://endoflife.date/debian"},
+	{"centos", "7", "2024-06-30", "https://endoflife.date/centos"},
+	{"centos", "8", "2021-12-31", "https://endoflife.date/centos"},
+	{"rhel", "7", "2024-06-30", "https://endoflife.date/rhel"},
+	{"rhel", "8", "2029-05-31", "https://endoflife.date/rhel"},
+	{"rhel", "9", "2032-05-31", "https://endoflife.date/rhel"},
+	{"alpine", "3.16", "2024-05-23", "https://endoflife.date/alpine"},
+	{"alpine", "3.17", "2024-11-22", "https://endoflife.date/alpine"},
+	{"alpine", "3.18", "2025-05-09", "https://endoflife.date/alpine"},
+	{"alpine", "3.19", "2025-11-01", "https://endoflife.date/alpine"},
+	{"alpine", "3.20", "2026-04-01", "https://endoflife.date/alpine"},
+}
+
+// eolSoon is how long before its end of life a release is warned about
+const eolSoon = time.Hour * 24 * 90
+
+// kernelAdvisory is a known problem of k3s on kernels before Below
+type kernelAdvisory struct {
+	Status   Status
+	Below    kernelVersion
+	CgroupV2 bool
+	Message  string
+	Link     string
+}
+
+var kernelAdvisories = []kernelAdvisory{
+	{Status: Fail, Below: kernelVersion{3, 10}, Message: "k3s needs kernel 3.10 or newer",
+		Link: "https://docs.k3s.io/installation/requirements"},
+	{Status: Warn, Below: kernelVersion{5, 8}, CgroupV2: true, Message: "cgroups v2 needs kernel 5.8 or newer for the kubelet to manage memory and pids reliably",
+		Link: "https://kubernetes.io/docs/concepts/architecture/cgroups/"},
+	{Status: Warn, Below: kernelVersion{5, 6}, Message: "kernels before 5.6 lack WireGuard, so flannel's wireguard-native backend needs the wireguard module installed",
+		Link: "https://docs.k3s.io/networking/basic-network-options"},
+}
+
+// checkOS warns about distro releases past or near their end of life
+func checkOS(r Runner) (Status, string) {
+	out, err := output(r, ". /etc/os-release && echo \"$ID $VERSION_ID\"")
+	fields := strings.Fields(out)
+	if err != nil || len(fields) == 0 {
+		return Warn, "unable to read /etc/os-release"
+	}
+	if len(fields) == 1 {
+		return Pass, fields[0]
+	}
+	id, version := fields[0], fields[1]
+	release := id + " " + version
+
+	for _, eol := range releaseEOLs {
+		if eol.ID != id || (version != eol.Version && !strings.HasPrefix(version, eol.Version+".")) {
+			continue
+		}
+
+		date, _ := time.Parse("2006-01-02", eol.EOL)
+		switch {
+		case now().After(date):
+			return Warn, fmt.Sprintf("%s reached its end of life on %s and gets no security updates, see %s", release, eol.EOL, eol.Link)
+		case now().Add(eolSoon).After(date):
+			return Warn, fmt.Sprintf("%s reaches its end of life on %s, see %s", release, eol.EOL, eol.Link)
+		}
+		return Pass, fmt.Sprintf("%s, supported until %s", release, eol.EOL)
+	}
+	return Pass, release
+}
+
+// kernelVersion is the major and minor version of a kernel
+type kernelVersion [2]int
+
+func parseKernelVersion(release string) (kernelVersion, bool) {
+	v := kernelVersion{}
+	parts := strings.SplitN(release, ".", 3)
+	if len(parts) < 2 {
+		return v, false
+	}
+	for i := range v {
+		digits := strings.TrimRightFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+		n, err := strconv.Atoi(digits)
+		if err != nil {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func (v kernelVersion) below(other kernelVersion) bool {
+	return v[0] < other[0] || (v[0] == other[0] && v[1] < other[1])
+}
+
+// checkKernel matches the kernel against known problems of k3s
+func checkKernel(r Runner) (Status, string) {
+	release, err := output(r, "uname -r")
+	cgroups, _ := output(r, "[ -f /sys/fs/cgroup/cgroup.controllers ] && echo 2 || echo 1")
+	version, ok := parseKernelVersion(release)
+	if err != nil || !ok {
+		return Warn, "unable to read the kernel version"
+	}
+
+	status, messages := Pass, []string{}
+	for _, advisory := range kernelAdvisories {
+		if !version.below(advisory.Below) || (advisory.CgroupV2 && cgroups != "2") {
+			continue
+		}
+		if advisory.Status == Fail || status == Pass {
+			status = advisory.Status
+		}
+		messages = append(messages, fmt.Sprintf("%s, see %s", advisory.Message, advisory.Link))
+	}
+
+	if len(messages) == 0 {
+		return Pass, release
+	}
+	return status, release + ": " + strings.Join(messages, "; ")
+}
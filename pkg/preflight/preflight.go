@@ -0,0 +1,329 @@
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// Status is the outcome of a single check
+type Status string
+
+const (
+	Pass Status = "pass"
+	Warn Status = "warn"
+	Fail Status = "fail"
+)
+
+// Runner executes commands on the host being checked
+type Runner interface {
+	ExecuteSilent(command string) (kssh.CommandRes, error)
+}
+
+// BatchRunner can also run several commands in one round trip
+type BatchRunner interface {
+	Runner
+	ExecuteBatch(commands []string) ([]kssh.BatchResult, error)
+}
+
+// Check inspects one aspect of a host's suitability for k3s
+type Check struct {
+	Name string
+	Run  func(r Runner) (Status, string)
+}
+
+// Result is the outcome of a Check on a host
+type Result struct {
+	Check   string `json:"check"`
+	Status  Status `json:"status"`
+	Message string `json:"message,omitempty"`
+}
+
+// Checks is the full preflight suite, in the order it is run
+var Checks = []Check{
+	{"sudo", checkSudo},
+	{"downloader", checkDownloader},
+	{"arch", checkArch},
+	{"os", checkOS},
+	{"kernel", checkKernel},
+	{"init", checkInit},
+	{"cgroups", checkCgroups},
+	{"memory", checkMemory},
+	{"disk", checkDisk},
+	{"ports", checkPorts},
+	{"conflicts", checkConflicts},
+}
+
+// Names returns the name of each check in the suite
+func Names() []string {
+	names := []string{}
+	for _, check := range Checks {
+		names = append(names, check.Name)
+	}
+	return names
+}
+
+// Run runs every check in the suite against a host. With a BatchRunner the
+// probes are run together up front.
+func Run(r Runner) []Result {
+	if batch, ok := r.(BatchRunner); ok {
+		r = prefetch(batch)
+	}
+
+	results := []Result{}
+	for _, check := range Checks {
+		status, message := check.Run(r)
+		results = append(results, Result{Check: check.Name, Status: status, Message: message})
+	}
+	return results
+}
+
+// recorder notes the commands the checks run without running them
+type recorder struct {
+	commands []string
+}
+
+func (r *recorder) ExecuteSilent(command string) (kssh.CommandRes, error) {
+	r.commands = append(r.commands, command)
+	return kssh.CommandRes{}, errors.New("not run")
+}
+
+// cached answers commands from a batch and runs any others, such as those
+// a check only runs after seeing the output of its first probe
+type cached struct {
+	results map[string]kssh.BatchResult
+	live    Runner
+}
+
+func (c cached) ExecuteSilent(command string) (kssh.CommandRes, error) {
+	if result, ok := c.results[command]; ok {
+		return result.CommandRes, result.Err()
+	}
+	return c.live.ExecuteSilent(command)
+}
+
+// prefetch runs the probe of every check in one batch. When the batch
+// fails, the checks run their commands one by one as usual.
+func prefetch(r BatchRunner) Runner {
+	rec := &recorder{}
+	for _, check := range Checks {
+		check.Run(rec)
+	}
+
+	results, err := r.ExecuteBatch(rec.commands)
+	if err != nil {
+		return r
+	}
+
+	c := cached{results: map[string]kssh.BatchResult{}, live: r}
+	for i, command := range rec.commands {
+		c.results[command] = results[i]
+	}
+	return c
+}
+
+// Worst returns the most severe status among results
+func Worst(results []Result) Status {
+	worst := Pass
+	for _, result := range results {
+		if result.Status == Fail {
+			return Fail
+		}
+		if result.Status == Warn {
+			worst = Warn
+		}
+	}
+	return worst
+}
+
+func output(r Runner, command string) (string, error) {
+	res, err := r.ExecuteSilent(command)
+	return strings.TrimSpace(string(res.StdOut)), err
+}
+
+// checkSudo makes sure k3sup can run commands as root, as root itself or
+// with sudo or doas, which minimal distros such as Alpine ship instead
+func checkSudo(r Runner) (Status, string) {
+	if id, err := output(r, "id -u"); err == nil && id == "0" {
+		return Pass, "logged in as root"
+	}
+	for _, tool := range []string{"sudo", "doas"} {
+		if _, err := output(r, tool+" -n true"); err == nil {
+			return Pass, tool
+		}
+	}
+	return Fail, "neither passwordless sudo nor doas is available"
+}
+
+func checkDownloader(r Runner) (Status, string) {
+	out, _ := output(r, "command -v curl || command -v wget")
+	if len(out) == 0 {
+		return Fail, "neither curl nor wget is installed"
+	}
+	return Pass, out
+}
+
+func checkArch(r Runner) (Status, string) {
+	arch, err := output(r, "uname -m")
+	if err != nil {
+		return Fail, "unable to detect architecture"
+	}
+
+	switch arch {
+	case "x86_64", "amd64", "aarch64", "arm64", "armv7l":
+		return Pass, arch
+	case "armv6l":
+		return Warn, arch + " is slow and only supported by some k3s releases"
+	}
+	return Fail, arch + " is not supported by k3s"
+}
+
+func checkInit(r Runner) (Status, string) {
+	out, _ := output(r, "if command -v systemctl > /dev/null 2>&1; then echo systemd; elif command -v rc-service > /dev/null 2>&1; then echo openrc; fi")
+	if len(out) == 0 {
+		return Fail, "neither systemd nor openrc was found"
+	}
+	return Pass, out
+}
+
+// checkCgroups makes sure the memory controller is enabled, which is
+// disabled by default on Raspbian and stops k3s from starting
+func checkCgroups(r Runner) (Status, string) {
+	out, _ := output(r, "if [ -f /sys/fs/cgroup/cgroup.controllers ]; then grep -qw memory /sys/fs/cgroup/cgroup.controllers && echo 1; "+
+		"else awk '$1 == \"memory\" { print $4 }' /proc/cgroups; fi")
+	if out != "1" {
+		return Fail, "the memory cgroup is not enabled, add cgroup_enable=memory cgroup_memory=1 to the kernel command line"
+	}
+	return Pass, ""
+}
+
+func checkMemory(r Runner) (Status, string) {
+	out, err := output(r, "awk '/^MemTotal:/ { print $2 }' /proc/meminfo")
+	kb, convErr := strconv.Atoi(out)
+	if err != nil || convErr != nil {
+		return Warn, "unable to read total memory"
+	}
+
+	mb := kb / 1024
+	message := fmt.Sprintf("%dMB", mb)
+	switch {
+	case mb < 512:
+		return Fail, message + ", at least 512MB is required"
+	case mb < 1024:
+		return Warn, message + ", 1GB or more is recommended"
+	}
+	return Pass, message
+}
+
+func checkDisk(r Runner) (Status, string) {
+	out, err := output(r, "df -Pk /var/lib | awk 'NR == 2 { print $4 }'")
+	kb, convErr := strconv.Atoi(out)
+	if err != nil || convErr != nil {
+		return Warn, "unable to read free disk space for /var/lib"
+	}
+
+	mb := kb / 1024
+	message := fmt.Sprintf("%dMB free in /var/lib", mb)
+	switch {
+	case mb < 1024:
+		return Fail, message + ", at least 1GB is required"
+	case mb < 4096:
+		return Warn, message + ", 4GB or more is recommended"
+	}
+	return Pass, message
+}
+
+// checkPorts warns when ports k3s listens on are already taken, which is
+// expected when k3s is already installed
+func checkPorts(r Runner) (Status, string) {
+	out, err := output(r, "if command -v ss > /dev/null 2>&1; then ss -ltn; else netstat -ltn; fi")
+	if err != nil {
+		return Warn, "unable to list listening ports"
+	}
+
+	inUse := []string{}
+	for _, port := range []string{"6443", "10250"} {
+		if listening(out, port) {
+			inUse = append(inUse, port)
+		}
+	}
+
+	if len(inUse) > 0 {
+		return Warn, "already listening on " + strings.Join(inUse, ", ")
+	}
+	return Pass, ""
+}
+
+// Conflict is something left by another Kubernetes distribution which
+// k3s clashes with, along with the command which removes it. Cleanup runs
+// as root, so it has no sudo of its own.
+type Conflict struct {
+	Name    string `json:"name"`
+	Cleanup string `json:"cleanup"`
+}
+
+// conflictProbes each print 1 when they find a conflict. Network
+// interfaces are only a conflict when k3s, which creates some of the same
+// ones, isn't installed. The probes run as the SSH user, so docker is
+// asked directly and then through sudo or doas.
+var conflictProbes = []struct {
+	name, probe, cleanup string
+}{
+	{"kubeadm",
+		"[ -e /etc/kubernetes/admin.conf -o -e /etc/kubernetes/kubelet.conf ] && echo 1",
+		"(! command -v kubeadm > /dev/null 2>&1 || kubeadm reset -f) && rm -rf /etc/kubernetes /var/lib/etcd"},
+	{"kubelet",
+		"systemctl is-active --quiet kubelet 2> /dev/null && echo 1",
+		"systemctl disable --now kubelet"},
+	{"microk8s",
+		"{ [ -e /snap/microk8s/current ] || command -v microk8s > /dev/null 2>&1; } && echo 1",
+		"snap remove --purge microk8s"},
+	{"docker swarm",
+		"for d in '' 'sudo -n' 'doas -n'; do [ \"$($d docker info --format '{{.Swarm.LocalNodeState}}' 2> /dev/null)\" = active ] && echo 1 && break; done",
+		"docker swarm leave --force"},
+	{"cni config",
+		"ls -A /etc/cni/net.d 2> /dev/null | grep -q . && echo 1",
+		"rm -rf /etc/cni/net.d /var/lib/cni"},
+	{"cni interfaces",
+		"! command -v k3s > /dev/null 2>&1 && ip -o link show 2> /dev/null | grep -Eq ': (cni0|flannel\\.1|kube-ipvs0|weave|vxlan\\.calico)[:@]' && echo 1",
+		"for i in cni0 flannel.1 kube-ipvs0 weave vxlan.calico; do ip link delete $i 2> /dev/null; done; true"},
+}
+
+// FindConflicts looks for kubeadm, a standalone kubelet, microk8s, docker
+// swarm and CNI leftovers, which make k3s fail to start in confusing ways
+func FindConflicts(r Runner) []Conflict {
+	conflicts := []Conflict{}
+	for _, c := range conflictProbes {
+		if out, _ := output(r, c.probe); out == "1" {
+			conflicts = append(conflicts, Conflict{Name: c.name, Cleanup: c.cleanup})
+		}
+	}
+	return conflicts
+}
+
+func checkConflicts(r Runner) (Status, string) {
+	conflicts := FindConflicts(r)
+	if len(conflicts) == 0 {
+		return Pass, ""
+	}
+
+	names := []string{}
+	for _, c := range conflicts {
+		names = append(names, c.Name)
+	}
+	return Fail, "found " + strings.Join(names, ", ") + ", remove with --clean-conflicts or reinstall the host"
+}
+
+func listening(out, port string) bool {
+	for _, line := range strings.Split(out, "\n") {
+		for _, field := range strings.Fields(line) {
+			if strings.HasSuffix(field, ":"+port) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,186 @@
+package preflight
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// fakeRunner answers commands containing a key with its output, any
+// other command fails
+type fakeRunner map[string]string
+
+func (f fakeRunner) ExecuteSilent(command string) (kssh.CommandRes, error) {
+	for key, out := range f {
+		if strings.Contains(command, key) {
+			return kssh.CommandRes{StdOut: []byte(out)}, nil
+		}
+	}
+	return kssh.CommandRes{}, errors.New("Process exited with status 1")
+}
+
+func Test_checkMemory(t *testing.T) {
+	cases := []struct {
+		meminfo string
+		want    Status
+	}{
+		{"262144\n", Fail},
+		{"949152\n", Warn},
+		{"3951632\n", Pass},
+		{"", Warn},
+	}
+
+	for _, c := range cases {
+		got, message := checkMemory(fakeRunner{"MemTotal": c.meminfo})
+		if got != c.want {
+			t.Errorf("MemTotal %q: want %s, got %s (%s)", c.meminfo, c.want, got, message)
+		}
+	}
+}
+
+func Test_checkPorts(t *testing.T) {
+	ss := `State  Recv-Q Send-Q Local Address:Port Peer Address:Port
+LISTEN 0      128    0.0.0.0:22         0.0.0.0:*
+LISTEN 0      128    *:6443             *:*
+`
+	got, message := checkPorts(fakeRunner{"ss -ltn": ss})
+	if got != Warn || message != "already listening on 6443" {
+		t.Errorf("want warning for 6443, got %s %q", got, message)
+	}
+
+	got, _ = checkPorts(fakeRunner{"ss -ltn": "LISTEN 0 128 0.0.0.0:16443 0.0.0.0:*\n"})
+	if got != Pass {
+		t.Errorf("want pass when only 16443 is used, got %s", got)
+	}
+}
+
+func Test_checkSudo(t *testing.T) {
+	if got, _ := checkSudo(fakeRunner{}); got != Fail {
+		t.Errorf("want fail without sudo, got %s", got)
+	}
+	if got, _ := checkSudo(fakeRunner{"sudo -n true": ""}); got != Pass {
+		t.Errorf("want pass with sudo, got %s", got)
+	}
+	if got, _ := checkSudo(fakeRunner{"doas -n true": ""}); got != Pass {
+		t.Errorf("want pass with doas, got %s", got)
+	}
+	if got, _ := checkSudo(fakeRunner{"id -u": "0\n"}); got != Pass {
+		t.Errorf("want pass as root without sudo, got %s", got)
+	}
+	if got, _ := checkSudo(fakeRunner{"id -u": "1000\n"}); got != Fail {
+		t.Errorf("want fail as a user without sudo, got %s", got)
+	}
+}
+
+func Test_Worst(t *testing.T) {
+	results := []Result{{Status: Pass}, {Status: Warn}, {Status: Pass}}
+	if got := Worst(results); got != Warn {
+		t.Errorf("want warn, got %s", got)
+	}
+
+	results = append(results, Result{Status: Fail})
+	if got := Worst(results); got != Fail {
+		t.Errorf("want fail, got %s", got)
+	}
+}
+
+func Test_checkConflicts(t *testing.T) {
+	if got, message := checkConflicts(fakeRunner{}); got != Pass {
+		t.Errorf("want pass on a clean host, got %s %q", got, message)
+	}
+
+	got, message := checkConflicts(fakeRunner{"/etc/kubernetes/admin.conf": "1\n", "Swarm.LocalNodeState": "1\n"})
+	if got != Fail || !strings.Contains(message, "kubeadm, docker swarm") {
+		t.Errorf("want kubeadm and docker swarm found, got %s %q", got, message)
+	}
+}
+
+// fakeBatchRunner answers a batch from a fakeRunner and counts round trips
+type fakeBatchRunner struct {
+	fakeRunner
+	trips int
+}
+
+func (f *fakeBatchRunner) ExecuteSilent(command string) (kssh.CommandRes, error) {
+	f.trips++
+	return f.fakeRunner.ExecuteSilent(command)
+}
+
+func (f *fakeBatchRunner) ExecuteBatch(commands []string) ([]kssh.BatchResult, error) {
+	f.trips++
+	results := []kssh.BatchResult{}
+	for _, command := range commands {
+		res, err := f.fakeRunner.ExecuteSilent(command)
+		result := kssh.BatchResult{CommandRes: res}
+		if err != nil {
+			result.ExitCode = 1
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func Test_Run_BatchesProbes(t *testing.T) {
+	runner := &fakeBatchRunner{fakeRunner: fakeRunner{"sudo -n true": "", "MemTotal": "3951632\n"}}
+	results := Run(runner)
+
+	if runner.trips != 1 {
+		t.Errorf("want one round trip, got %d", runner.trips)
+	}
+	for _, result := range results {
+		if result.Check == "sudo" && result.Status != Pass {
+			t.Errorf("want sudo to pass from the batch, got %s", result.Status)
+		}
+		if result.Check == "memory" && result.Status != Pass {
+			t.Errorf("want memory to pass from the batch, got %s %q", result.Status, result.Message)
+		}
+	}
+}
+
+func Test_checkOS(t *testing.T) {
+	defer func() { now = time.Now }()
+	now = func() time.Time { return time.Date(2024, 4, 15, 0, 0, 0, 0, time.UTC) }
+
+	cases := []struct {
+		release string
+		want    Status
+		message string
+	}{
+		{"ubuntu 18.04\n", Warn, "reached its end of life on 2023-05-31"},
+		{"centos 7\n", Warn, "reaches its end of life on 2024-06-30"},
+		{"debian 12\n", Pass, "supported until 2026-06-10"},
+		{"alpine 3.18.4\n", Pass, "supported until 2025-05-09"},
+		{"fedora 39\n", Pass, "fedora 39"},
+	}
+
+	for _, c := range cases {
+		got, message := checkOS(fakeRunner{"os-release": c.release})
+		if got != c.want || !strings.Contains(message, c.message) {
+			t.Errorf("%q: want %s %q, got %s %q", c.release, c.want, c.message, got, message)
+		}
+	}
+}
+
+func Test_checkKernel(t *testing.T) {
+	cases := []struct {
+		release string
+		cgroups string
+		want    Status
+	}{
+		{"6.1.0-18-amd64", "2", Pass},
+		{"5.4.0-150-generic", "1", Warn},
+		{"5.15.0-1034-raspi", "2", Pass},
+		{"5.6.19", "2", Warn},
+		{"3.2.0-4-amd64", "1", Fail},
+	}
+
+	for _, c := range cases {
+		got, message := checkKernel(fakeRunner{"uname -r": c.release, "cgroup.controllers": c.cgroups})
+		if got != c.want {
+			t.Errorf("%s with cgroups v%s: want %s, got %s (%s)", c.release, c.cgroups, c.want, got, message)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// BatchResult is the output and exit code of one command of a batch
+type BatchResult struct {
+	CommandRes
+	ExitCode int
+}
+
+// Err returns an error for a non-zero exit code, like Execute does, so that
+// callers can treat batched and single commands alike
+func (r BatchResult) Err() error {
+	if r.ExitCode == 0 {
+		return nil
+	}
+	return fmt.Errorf("Process exited with status %d", r.ExitCode)
+}
+
+// ExecuteBatch runs independent commands one after another in a single
+// shell on the host and returns the result of each, in order. Each command
+// runs in its own subshell with no stdin, so one failing or exiting doesn't
+// stop the rest. This costs one round trip instead of one per command,
+// which adds up on high-latency links.
+func (s *SSHOperator) ExecuteBatch(commands []string) ([]BatchResult, error) {
+	boundary, err := batchBoundary()
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.ExecuteWithStdin("sh -s", strings.NewReader(batchScript(boundary, commands)))
+	if err != nil {
+		return nil, fmt.Errorf("%s %s", err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return parseBatch(boundary, len(commands), res.StdOut)
+}
+
+func batchBoundary() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "k3sup-batch-" + hex.EncodeToString(b), nil
+}
+
+// batchScript prints each command's stdout and then its stderr between
+// lines holding the boundary, followed by its exit code
+func batchScript(boundary string, commands []string) string {
+	script := bytes.Buffer{}
+	script.WriteString("k3sup_batch=$(mktemp -d) || exit 1\ntrap 'rm -rf \"$k3sup_batch\"' EXIT\n")
+	for i, command := range commands {
+		fmt.Fprintf(&script, "printf '%s out %d\\n'\n", boundary, i)
+		fmt.Fprintf(&script, "(\n%s\n) < /dev/null 2> \"$k3sup_batch/err\"\n", command)
+		fmt.Fprintf(&script, "k3sup_rc=$?\nprintf '\\n%s err %d\\n'\ncat \"$k3sup_batch/err\"\n", boundary, i)
+		fmt.Fprintf(&script, "printf '\\n%s end %d %%d\\n' $k3sup_rc\n", boundary, i)
+	}
+	return script.String()
+}
+
+func parseBatch(boundary string, n int, output []byte) ([]BatchResult, error) {
+	results := []BatchResult{}
+	rest := string(output)
+
+	for i := 0; i < n; i++ {
+		out := fmt.Sprintf("%s out %d\n", boundary, i)
+		errMarker := fmt.Sprintf("\n%s err %d\n", boundary, i)
+		end := fmt.Sprintf("\n%s end %d ", boundary, i)
+
+		start := strings.Index(rest, out)
+		errAt := strings.Index(rest, errMarker)
+		endAt := strings.Index(rest, end)
+		if start < 0 || errAt < start || endAt < errAt {
+			return nil, fmt.Errorf("incomplete output from command %d of the batch", i)
+		}
+
+		line := rest[endAt+len(end):]
+		if newline := strings.Index(line, "\n"); newline >= 0 {
+			line = line[:newline]
+		}
+		code, err := strconv.Atoi(line)
+		if err != nil {
+			return nil, fmt.Errorf("no exit code for command %d of the batch", i)
+		}
+
+		results = append(results, BatchResult{
+			CommandRes: CommandRes{
+				StdOut: []byte(rest[start+len(out) : errAt]),
+				StdErr: []byte(rest[errAt+len(errMarker) : endAt]),
+			},
+			ExitCode: code,
+		})
+		rest = rest[endAt+len(end)+len(line):]
+	}
+
+	return results, nil
+}
@@ -0,0 +1,31 @@
+package ssh
+
+import "testing"
+
+func Test_ExecuteBatch_Local(t *testing.T) {
+	results, err := NewLocalOperator().ExecuteBatch([]string{
+		"echo one",
+		"printf 'no newline'; echo oops >&2; exit 3",
+		"exit",
+		"cat",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("want 4 results, got %d", len(results))
+	}
+
+	if string(results[0].StdOut) != "one\n" || results[0].Err() != nil {
+		t.Errorf("want one and no error, got %q %v", results[0].StdOut, results[0].Err())
+	}
+	if string(results[1].StdOut) != "no newline" || string(results[1].StdErr) != "oops\n" || results[1].ExitCode != 3 {
+		t.Errorf("want output, error output and exit code 3, got %q %q %d", results[1].StdOut, results[1].StdErr, results[1].ExitCode)
+	}
+	if results[2].ExitCode != 0 {
+		t.Errorf("want exit to only end its own command, got %d", results[2].ExitCode)
+	}
+	if len(results[3].StdOut) != 0 {
+		t.Errorf("want no stdin for batched commands, got %q", results[3].StdOut)
+	}
+}
@@ -0,0 +1,153 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// FakeOperator is an Operator for tests, which answers each command with
+// Handler and keeps what was run and uploaded. Files holds the contents
+// of remote paths, for Upload and Download.
+type FakeOperator struct {
+	// Handler answers a command and the stdin it was given, a nil Handler
+	// answers every command with no output
+	Handler func(command string, stdin []byte) (CommandRes, error)
+
+	Files map[string][]byte
+
+	mu           sync.Mutex
+	commands     []string
+	sudoPassword string
+	closed       bool
+}
+
+var _ Operator = &FakeOperator{}
+
+// NewFakeOperator returns a FakeOperator which answers with handler
+func NewFakeOperator(handler func(command string, stdin []byte) (CommandRes, error)) *FakeOperator {
+	return &FakeOperator{Handler: handler, Files: map[string][]byte{}}
+}
+
+// Commands returns the commands run so far, in order
+func (f *FakeOperator) Commands() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string{}, f.commands...)
+}
+
+// SudoPassword returns the password given to SetSudoPassword
+func (f *FakeOperator) SudoPassword() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.sudoPassword
+}
+
+// Closed reports whether Close was called
+func (f *FakeOperator) Closed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+func (f *FakeOperator) Execute(command string) (CommandRes, error) {
+	return f.ExecuteContext(context.Background(), command, nil, true)
+}
+
+func (f *FakeOperator) ExecuteSilent(command string) (CommandRes, error) {
+	return f.ExecuteContext(context.Background(), command, nil, false)
+}
+
+func (f *FakeOperator) ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	return f.ExecuteContext(context.Background(), command, stdin, false)
+}
+
+func (f *FakeOperator) ExecuteScript(command string, script io.Reader) (CommandRes, error) {
+	return f.ExecuteContext(context.Background(), command, script, true)
+}
+
+func (f *FakeOperator) ExecuteContext(ctx context.Context, command string, stdin io.Reader, stream bool) (CommandRes, error) {
+	if err := ctx.Err(); err != nil {
+		return CommandRes{}, err
+	}
+
+	var input []byte
+	if stdin != nil {
+		var err error
+		if input, err = ioutil.ReadAll(stdin); err != nil {
+			return CommandRes{}, err
+		}
+	}
+
+	f.mu.Lock()
+	f.commands = append(f.commands, command)
+	handler := f.Handler
+	f.mu.Unlock()
+
+	if handler == nil {
+		return CommandRes{}, nil
+	}
+	return handler(command, input)
+}
+
+func (f *FakeOperator) ExecuteBatch(commands []string) ([]BatchResult, error) {
+	results := []BatchResult{}
+	for _, command := range commands {
+		res, err := f.ExecuteSilent(command)
+		result := BatchResult{CommandRes: res}
+		if err != nil {
+			result.ExitCode = 1
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (f *FakeOperator) Upload(ctx context.Context, data io.Reader, remotePath, mode, escalation string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	contents, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.Files == nil {
+		f.Files = map[string][]byte{}
+	}
+	f.Files[remotePath] = contents
+	return nil
+}
+
+func (f *FakeOperator) Download(ctx context.Context, remotePath string, w io.Writer, escalation string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	contents, ok := f.Files[remotePath]
+	f.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("unable to download %s: no such file", remotePath)
+	}
+	_, err := io.Copy(w, bytes.NewReader(contents))
+	return err
+}
+
+func (f *FakeOperator) SetSudoPassword(password string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sudoPassword = password
+}
+
+func (f *FakeOperator) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.closed = true
+	return nil
+}
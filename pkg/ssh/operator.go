@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Operator is how k3sup and tools built on it run commands and copy files
+// on a host. SSHOperator is the implementation over SSH and on this
+// machine, FakeOperator stands in for a host in tests.
+type Operator interface {
+	// Execute runs a command, streaming its output to the console as well
+	// as capturing it
+	Execute(command string) (CommandRes, error)
+
+	// ExecuteSilent runs a command and only captures its output
+	ExecuteSilent(command string) (CommandRes, error)
+
+	// ExecuteWithStdin runs a command reading stdin, capturing its output
+	ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error)
+
+	// ExecuteScript runs a command reading a script from stdin, streaming
+	// its output
+	ExecuteScript(command string, script io.Reader) (CommandRes, error)
+
+	// ExecuteContext runs a command until it exits or ctx is done, with
+	// stdin when it isn't nil and streaming its output when stream is set
+	ExecuteContext(ctx context.Context, command string, stdin io.Reader, stream bool) (CommandRes, error)
+
+	// ExecuteBatch runs independent commands in one round trip
+	ExecuteBatch(commands []string) ([]BatchResult, error)
+
+	// Upload writes data to remotePath with the given octal mode, creating
+	// its directory when it is missing. escalation, such as "sudo ", runs
+	// each step as root for paths the user can't write.
+	Upload(ctx context.Context, data io.Reader, remotePath, mode, escalation string) error
+
+	// Download copies the contents of remotePath to w, reading it with
+	// escalation
+	Download(ctx context.Context, remotePath string, w io.Writer, escalation string) error
+
+	// SetSudoPassword runs every following command as root with sudo -S
+	SetSudoPassword(password string)
+
+	Close() error
+}
+
+var _ Operator = &SSHOperator{}
+
+// Upload writes data to remotePath as the user, or as root with escalation
+// or after SetSudoPassword
+func (s *SSHOperator) Upload(ctx context.Context, data io.Reader, remotePath, mode, escalation string) error {
+	command := fmt.Sprintf("%[1]smkdir -p %[2]s && %[1]ssh -c %[3]s && %[1]schmod %[4]s %[5]s",
+		escalation, quotePath(path.Dir(remotePath)), ShellQuote("cat > "+quotePath(remotePath)), ShellQuote(mode), quotePath(remotePath))
+
+	res, err := s.ExecuteContext(ctx, command, data, false)
+	if err != nil {
+		return fmt.Errorf("unable to upload %s: %s %s", remotePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	return nil
+}
+
+// Download copies the contents of remotePath to w
+func (s *SSHOperator) Download(ctx context.Context, remotePath string, w io.Writer, escalation string) error {
+	res, err := s.ExecuteContext(ctx, escalation+"cat "+quotePath(remotePath), nil, false)
+	if err != nil {
+		return fmt.Errorf("unable to download %s: %s %s", remotePath, err, strings.TrimSpace(string(res.StdErr)))
+	}
+	_, err = io.Copy(w, bytes.NewReader(res.StdOut))
+	return err
+}
+
+// quotePath quotes a remote path for the shell, leaving a leading ~/ for
+// the shell to expand to the user's home
+func quotePath(remotePath string) string {
+	if strings.HasPrefix(remotePath, "~/") {
+		return "~/" + ShellQuote(strings.TrimPrefix(remotePath, "~/"))
+	}
+	return ShellQuote(remotePath)
+}
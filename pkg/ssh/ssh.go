@@ -2,22 +2,45 @@ package ssh
 
 import (
 	"bytes"
+	"context"
 	"io"
 	"os"
+	"os/exec"
+	"strings"
 	"sync"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// SSHOperator runs commands on a host over SSH, or on this machine when it
+// was created with NewLocalOperator
 type SSHOperator struct {
 	conn *ssh.Client
+
+	sudoPassword string
 }
 
 func (s *SSHOperator) Close() error {
-
+	if s.conn == nil {
+		return nil
+	}
 	return s.conn.Close()
 }
 
+// NewLocalOperator runs commands on this machine with sh instead of over
+// SSH, for when k3sup runs on the host it is setting up
+func NewLocalOperator() *SSHOperator {
+	return &SSHOperator{}
+}
+
+// SetSudoPassword runs every following command as root through sudo -S,
+// which reads the password from the start of the command's stdin, for
+// users who need a password for sudo. The password never appears on a
+// command line, and commands keep the user's HOME.
+func (s *SSHOperator) SetSudoPassword(password string) {
+	s.sudoPassword = password
+}
+
 func NewSSHOperator(address string, config *ssh.ClientConfig) (*SSHOperator, error) {
 	conn, err := ssh.Dial("tcp", address, config)
 	if err != nil {
@@ -31,25 +54,66 @@ func NewSSHOperator(address string, config *ssh.ClientConfig) (*SSHOperator, err
 	return &operator, nil
 }
 
-func (s *SSHOperator) Execute(command string) (commandRes, error) {
+// Execute runs a command on the remote host, streaming its output to the
+// console as well as capturing it
+func (s *SSHOperator) Execute(command string) (CommandRes, error) {
+	return s.ExecuteContext(context.Background(), command, nil, true)
+}
+
+// ExecuteSilent runs a command on the remote host and only captures its
+// output, for probes whose output is not useful to the user
+func (s *SSHOperator) ExecuteSilent(command string) (CommandRes, error) {
+	return s.ExecuteContext(context.Background(), command, nil, false)
+}
+
+// ExecuteWithStdin runs a command on the remote host with stdin connected
+// to the given reader, which is how files are copied to the host
+func (s *SSHOperator) ExecuteWithStdin(command string, stdin io.Reader) (CommandRes, error) {
+	return s.ExecuteContext(context.Background(), command, stdin, false)
+}
+
+// ExecuteScript runs a command which reads a script from stdin, such as
+// sh -, streaming its output to the console like Execute
+func (s *SSHOperator) ExecuteScript(command string, script io.Reader) (CommandRes, error) {
+	return s.ExecuteContext(context.Background(), command, script, true)
+}
+
+// ExecuteContext runs a command with stdin connected to the given reader,
+// when it isn't nil, and streams its output when stream is set. The command
+// is stopped and ctx.Err() returned when ctx is done first.
+func (s *SSHOperator) ExecuteContext(ctx context.Context, command string, stdin io.Reader, stream bool) (CommandRes, error) {
+	if len(s.sudoPassword) > 0 {
+		command, stdin = sudoCommand(command, stdin, s.sudoPassword)
+	}
+
+	if s.conn == nil {
+		return executeCommand(ctx, command, stdin, stream)
+	}
 
 	sess, err := s.conn.NewSession()
 	if err != nil {
-		return commandRes{}, err
+		return CommandRes{}, err
 	}
 
 	defer sess.Close()
 
+	if stdin != nil {
+		sess.Stdin = stdin
+	}
+
 	sessStdOut, err := sess.StdoutPipe()
 	if err != nil {
-		return commandRes{}, err
+		return CommandRes{}, err
 	}
 
 	output := bytes.Buffer{}
 
 	wg := sync.WaitGroup{}
 
-	stdOutWriter := io.MultiWriter(os.Stdout, &output)
+	var stdOutWriter io.Writer = &output
+	if stream {
+		stdOutWriter = io.MultiWriter(os.Stdout, &output)
+	}
 	wg.Add(1)
 	go func() {
 		io.Copy(stdOutWriter, sessStdOut)
@@ -57,37 +121,97 @@ func (s *SSHOperator) Execute(command string) (commandRes, error) {
 	}()
 	sessStderr, err := sess.StderrPipe()
 	if err != nil {
-		return commandRes{}, err
+		return CommandRes{}, err
 	}
 
 	errorOutput := bytes.Buffer{}
-	stdErrWriter := io.MultiWriter(os.Stderr, &errorOutput)
+	var stdErrWriter io.Writer = &errorOutput
+	if stream {
+		stdErrWriter = io.MultiWriter(os.Stderr, &errorOutput)
+	}
 	wg.Add(1)
 	go func() {
 		io.Copy(stdErrWriter, sessStderr)
 		wg.Done()
 	}()
 
-	err = sess.Run(command)
-
-	wg.Wait()
+	if err := sess.Start(command); err != nil {
+		return CommandRes{}, err
+	}
 
-	if err != nil {
-		return commandRes{}, err
+	// Closing the session ends the command, as not every SSH server
+	// delivers signals
+	done := make(chan error, 1)
+	go func() { done <- sess.Wait() }()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		sess.Close()
+		<-done
+		err = ctx.Err()
 	}
 
-	return commandRes{
+	wg.Wait()
+
+	// Output is returned alongside any error so that callers can explain
+	// why a remote command failed
+	return CommandRes{
 		StdErr: errorOutput.Bytes(),
 		StdOut: output.Bytes(),
-	}, nil
+	}, err
 }
 
-type commandRes struct {
+// CommandRes holds the output captured from a remote command
+type CommandRes struct {
 	StdOut []byte
 	StdErr []byte
 }
 
-func executeCommand(cmd string) (commandRes, error) {
+func executeCommand(ctx context.Context, command string, stdin io.Reader, stream bool) (CommandRes, error) {
+	output, errorOutput := bytes.Buffer{}, bytes.Buffer{}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdin = stdin
+	cmd.Stdout, cmd.Stderr = &output, &errorOutput
+	if stream {
+		cmd.Stdout = io.MultiWriter(os.Stdout, &output)
+		cmd.Stderr = io.MultiWriter(os.Stderr, &errorOutput)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return CommandRes{}, err
+	}
+
+	// Children of sh can hold its output open after it is killed, so the
+	// wait isn't finished when ctx is done and the output is left behind
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return CommandRes{
+			StdErr: errorOutput.Bytes(),
+			StdOut: output.Bytes(),
+		}, err
+	case <-ctx.Done():
+		cmd.Process.Kill()
+		return CommandRes{}, ctx.Err()
+	}
+}
+
+// sudoCommand wraps command in sudo and puts the password ahead of its
+// stdin. -k makes sudo ask for the password every time, since a cached
+// credential would leave the password for the command to read instead.
+func sudoCommand(command string, stdin io.Reader, password string) (string, io.Reader) {
+	wrapped := "sudo -k -S -p '' env HOME=\"$HOME\" sh -c " + ShellQuote(command)
+
+	passwordReader := strings.NewReader(password + "\n")
+	if stdin == nil {
+		return wrapped, passwordReader
+	}
+	return wrapped, io.MultiReader(passwordReader, stdin)
+}
 
-	return commandRes{}, nil
+// ShellQuote quotes s as a single word for sh
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
 }
@@ -0,0 +1,98 @@
+package ssh
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_sudoCommand_PasswordAheadOfStdin(t *testing.T) {
+	command, stdin := sudoCommand("echo 'it''s' > /tmp/f", strings.NewReader("data"), "secret")
+
+	want := `sudo -k -S -p '' env HOME="$HOME" sh -c 'echo '\''it'\'''\''s'\'' > /tmp/f'`
+	if command != want {
+		t.Errorf("want %s, got %s", want, command)
+	}
+
+	input, _ := ioutil.ReadAll(stdin)
+	if string(input) != "secret\ndata" {
+		t.Errorf("want the password line and then the stdin, got %q", input)
+	}
+}
+
+func Test_ExecuteScript_ReadsScriptFromStdin(t *testing.T) {
+	operator := NewLocalOperator()
+
+	res, err := operator.ExecuteScript("cat | GREETING=hello sh -", strings.NewReader("#!/bin/sh\necho $GREETING\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(res.StdOut)); got != "hello" {
+		t.Errorf("want hello, got %q", got)
+	}
+}
+
+func Test_ExecuteContext_StopsWhenCancelled(t *testing.T) {
+	operator := NewLocalOperator()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*100)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := operator.ExecuteContext(ctx, "sleep 10", nil, false); err != context.DeadlineExceeded {
+		t.Errorf("want the deadline to stop the command, got %v", err)
+	}
+	if time.Since(start) > time.Second*5 {
+		t.Errorf("want the command stopped straight away, took %s", time.Since(start))
+	}
+}
+
+func Test_UploadAndDownload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-operator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	operator := NewLocalOperator()
+	remotePath := filepath.Join(dir, "it's here", "config.yaml")
+	if err := operator.Upload(context.Background(), strings.NewReader("write-kubeconfig-mode: 644\n"), remotePath, "0600", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got := bytes.Buffer{}
+	if err := operator.Download(context.Background(), remotePath, &got, ""); err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != "write-kubeconfig-mode: 644\n" {
+		t.Errorf("want the uploaded file back, got %q", got.String())
+	}
+}
+
+func Test_Upload_ExpandsHome(t *testing.T) {
+	dir, err := ioutil.TempDir("", "k3sup-home")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("HOME", os.Getenv("HOME"))
+	os.Setenv("HOME", dir)
+
+	operator := NewLocalOperator()
+	if err := operator.Upload(context.Background(), strings.NewReader("k3s"), "~/it's/k3s.yaml", "0600", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(dir, "it's", "k3s.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "k3s" {
+		t.Errorf("want the file uploaded under $HOME, got %q", string(got))
+	}
+}
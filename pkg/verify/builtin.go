@@ -0,0 +1,58 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+func init() {
+	Register(&nodeReadyVerifier{interval: time.Second * 5})
+}
+
+// nodeReadyVerifier waits until every node known to the server reports Ready
+type nodeReadyVerifier struct {
+	interval time.Duration
+}
+
+func (v *nodeReadyVerifier) Name() string {
+	return "node-ready"
+}
+
+func (v *nodeReadyVerifier) Run(ctx context.Context, kubeconfig []byte, operator kssh.Operator, escalation string) error {
+	command := escalation + "k3s kubectl get nodes --no-headers"
+
+	for {
+		res, err := operator.Execute(command)
+		if err == nil && allNodesReady(string(res.StdOut)) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if err != nil {
+				return fmt.Errorf("nodes did not become ready: %s", err)
+			}
+			return fmt.Errorf("nodes did not become ready: %s", strings.TrimSpace(string(res.StdOut)))
+		case <-time.After(v.interval):
+		}
+	}
+}
+
+func allNodesReady(out string) bool {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	if len(lines) == 0 || len(lines[0]) == 0 {
+		return false
+	}
+
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[1] != "Ready" {
+			return false
+		}
+	}
+	return true
+}
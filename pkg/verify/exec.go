@@ -0,0 +1,49 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// ExecVerifier runs an external program, such as a script or a binary
+// plugin, with KUBECONFIG pointing at the new cluster. A non-zero exit code
+// fails the verification.
+type ExecVerifier struct {
+	Path string
+}
+
+// NewExecVerifier creates a verifier for the program found at path
+func NewExecVerifier(path string) *ExecVerifier {
+	return &ExecVerifier{Path: path}
+}
+
+func (v *ExecVerifier) Name() string {
+	return filepath.Base(v.Path)
+}
+
+func (v *ExecVerifier) Run(ctx context.Context, kubeconfig []byte, operator kssh.Operator, escalation string) error {
+	file, err := ioutil.TempFile(os.TempDir(), "k3sup-verify-*")
+	if err != nil {
+		return fmt.Errorf("could not write kubeconfig for verifier: %s", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.Write(kubeconfig); err != nil {
+		file.Close()
+		return fmt.Errorf("could not write kubeconfig for verifier: %s", err)
+	}
+	file.Close()
+
+	cmd := exec.CommandContext(ctx, v.Path)
+	cmd.Env = append(os.Environ(), "KUBECONFIG="+file.Name())
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
@@ -0,0 +1,81 @@
+package verify
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	kssh "github.com/alexellis/k3sup/pkg/ssh"
+)
+
+// Verifier asserts a site-specific invariant about a node after k3s has
+// been installed, before k3sup reports success. escalation is what
+// commands which need root are prefixed with on the node, such as "sudo ".
+type Verifier interface {
+	Name() string
+	Run(ctx context.Context, kubeconfig []byte, operator kssh.Operator, escalation string) error
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]Verifier{}
+)
+
+// Register makes a verifier available by name, replacing any existing
+// verifier registered under the same name
+func Register(v Verifier) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[v.Name()] = v
+}
+
+// Lookup returns the verifier registered under name
+func Lookup(name string) (Verifier, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	v, ok := registry[name]
+	return v, ok
+}
+
+// Names returns the names of all registered verifiers in sorted order
+func Names() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	names := []string{}
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve turns a list of names or executable paths into verifiers. Names
+// of registered verifiers take precedence, anything else is treated as an
+// external program to run.
+func Resolve(specs []string) []Verifier {
+	verifiers := []Verifier{}
+	for _, spec := range specs {
+		if v, ok := Lookup(spec); ok {
+			verifiers = append(verifiers, v)
+			continue
+		}
+		verifiers = append(verifiers, NewExecVerifier(spec))
+	}
+	return verifiers
+}
+
+// RunAll runs each verifier in order and stops at the first failure
+func RunAll(ctx context.Context, verifiers []Verifier, kubeconfig []byte, operator kssh.Operator, escalation string) error {
+	for _, v := range verifiers {
+		fmt.Printf("Running verifier: %s\n", v.Name())
+
+		if err := v.Run(ctx, kubeconfig, operator, escalation); err != nil {
+			return fmt.Errorf("verifier %s failed: %s", v.Name(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package verify
+
+import "testing"
+
+func Test_allNodesReady(t *testing.T) {
+	cases := []struct {
+		name string
+		out  string
+		want bool
+	}{
+		{"empty output", "", false},
+		{"single ready node", "k3s-1   Ready    master   2m   v1.14.6-k3s.1\n", true},
+		{"one node not ready", "k3s-1   Ready    master   2m   v1.14.6-k3s.1\nk3s-2   NotReady   <none>   5s   v1.14.6-k3s.1\n", false},
+	}
+
+	for _, c := range cases {
+		if got := allNodesReady(c.out); got != c.want {
+			t.Errorf("%s: want %v, got %v", c.name, c.want, got)
+		}
+	}
+}
+
+func Test_Resolve_FallsBackToExec(t *testing.T) {
+	verifiers := Resolve([]string{"node-ready", "/opt/checks/site.sh"})
+
+	if len(verifiers) != 2 {
+		t.Fatalf("want 2 verifiers, got %d", len(verifiers))
+	}
+	if verifiers[0].Name() != "node-ready" {
+		t.Errorf("want built-in node-ready, got %s", verifiers[0].Name())
+	}
+	if _, ok := verifiers[1].(*ExecVerifier); !ok {
+		t.Errorf("want ExecVerifier for a path, got %T", verifiers[1])
+	}
+	if verifiers[1].Name() != "site.sh" {
+		t.Errorf("want name site.sh, got %s", verifiers[1].Name())
+	}
+}